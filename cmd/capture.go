@@ -4,23 +4,68 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"goskydarks/config"
+	"goskydarks/logging"
 	"goskydarks/session"
 	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 // captureCmd represents the capture command
 var captureCmd = &cobra.Command{
 	Use:   "capture",
 	Short: "Use TheSkyX to capture calibration frames",
-	Long: `Uses TheSkyX to capture dark and bias frames as specified in the configuration file.  
+	Long: `Uses TheSkyX to capture dark, bias and flat-dark frames as specified in the configuration file.  
 If the state file indicates that a previous run was terminated but unfinished, capture will pick up from where the previous run left off.  
 Use the RESET command to prevent this and start over.
+Use --dryrun to see what a capture would do, in order, without contacting TheSkyX or waiting.
+If the connection to TheSkyX drops, capture reconnects and carries on (see the retry settings).
+
+The config file's cooling section may list several target temperatures (coolToList).  The whole set
+of frames is then captured at each temperature in turn, with progress kept in a state file per temperature.
+Use --captureorder to interleave the sets (roundrobin), so a night cut short still has some frames of
+every set, or to take them by exposure (shortestfirst, longestfirst) or grouped by binning (bybinning).
+
+Flat-darks are dark frames with the short exposures used for flats.  TheSkyX saves them as dark
+frames, but with "FlatDark_" added to the start of its autosave prefix while they are captured, so
+their files can be told apart; the prefix is put back afterwards.  goskydarks keeps their own counts
+in the state file and tags them FlatDark in the event log and summary.  They are done after the
+other frames, or first with --flatdarkfirst.
+
+A dark or flat-dark set can stand for a series of sets: "20,30..600x2,1" is 20 frames each of
+30, 60, 120, 240 and 480 seconds, and "20,[60,120,300],1|2" is every combination of those exposures
+and binnings.  The series is expanded into ordinary sets, which are what the plan and state file show.
+
+On CMOS cameras, calibration frames must match the lights' gain, offset and readout mode.  Any
+frame set may end with these, e.g. --dark "16,300,1,gain=100,offset=30,readout=High Gain Mode".
+They are set on the camera before the set is captured, and sets with different settings are
+counted separately in the state file.  They are set through the ccdsoftCamera Gain, Offset and
+ReadoutMode properties; if the camera's driver doesn't offer one, TheSkyX reports an error and the
+capture stops.
+A dry run shows where they would be set.
+
+The config file may hold named profiles, e.g. one per camera or season, under "profiles".  Choose
+one with --profile; its settings override those in the rest of the file, and flags override both.
+A profile may extend another with "extends".  Give each profile its own stateFile if their frames differ.
 
 Note the config file allows the capture to be deferred until later - e.g. after dark when it is cooler.
+
+When the capture ends, a summary of the frames captured is shown, and the exit status tells how it went:
+   0  all frames captured
+   2  configuration error, nothing attempted (including camera settings or flat-dark tags the server can't make);
+      every problem found in the settings is listed, as by the validate command
+   3  could not connect to the server, or lost the connection and could not reconnect
+   4  camera could not reach, or drifted from, the cooling target
+   5  stopped before all frames were captured, for some other reason
+ 130  interrupted (Ctrl-C or SIGTERM)
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if viper.GetBool(config.ShowSettingsSetting) {
@@ -29,102 +74,300 @@ Note the config file allows the capture to be deferred until later - e.g. after
 		//	State file is mandatory when doing a capture
 		if viper.GetString(config.StateFileSetting) == "" {
 			_, _ = fmt.Fprintln(os.Stderr, "State file is required for capture")
+			exitCode = exitConfigError
 			return
 		}
 
 		consistentizeCooling(cmd)
-
-		//	Get bias and dark frame specs
-		biasFrames := viper.GetStringSlice(config.BiasFramesSetting)
-		darkFrames := viper.GetStringSlice(config.DarkFramesSetting)
-		if err := validateBiasFrames(biasFrames); err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, err)
-			return
-		}
-		if err := validateDarkFrames(darkFrames); err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, err)
-			return
-		}
-		if len(biasFrames) == 0 && len(darkFrames) == 0 {
-			fmt.Println("Nothing to capture - specify bias or dark frames")
+		consistentizeOrder(cmd)
+		if err := config.Validate(); err != nil {
+			reportConfigProblems(err)
+			exitCode = exitConfigError
 			return
 		}
-
-		//	Create the capture session
-		session, err := session.NewSession()
+		temperatures, err := captureTemperatures(cmd)
 		if err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
 			return
 		}
-		defer func() {
-			//fmt.Println("Closing Session")
-			_ = session.Close()
-		}()
 
-		//	Delay start
-		delay, targetTime, err := config.ParseStart()
+		//	Get bias, dark and flat-dark frame specs
+		biasFrames, darkFrames, flatDarkFrames, err := captureFrameSets()
 		if err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
 			return
 		}
-		if delay {
-			err = session.DelayStart(targetTime)
-			if err != nil {
+
+		//	Optional machine-readable log of what happens during the session (nil if not wanted)
+		var eventLog *session.EventLog
+		if eventLogPath := viper.GetString(config.EventLogSetting); eventLogPath != "" && !viper.GetBool(config.EstimateSetting) {
+			var err error
+			if eventLog, err = session.NewEventLog(eventLogPath); err != nil {
 				_, _ = fmt.Fprintln(os.Stderr, err)
+				exitCode = exitConfigError
 				return
 			}
+			defer func() {
+				_ = eventLog.Close()
+			}()
 		}
 
-		//	Establish server connection
-		err = session.ConnectToServer()
+		//	Create the capture session.  A dry run uses stand-in services that only report what they would do
+		newSession := session.NewSession
+		if viper.GetBool(config.DryRunSetting) {
+			newSession = session.NewDryRunSession
+		}
+		captureSession, err := newSession()
 		if err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConnectionFailed
+			return
+		}
+		defer func() {
+			//fmt.Println("Closing Session")
+			_ = captureSession.Close()
+		}()
+		captureSession.SetEventLog(eventLog)
+
+		if viper.GetBool(config.EstimateSetting) {
+			if err := printCaptureEstimate(captureSession, temperatures, biasFrames, darkFrames, flatDarkFrames); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+			}
 			return
 		}
 
-		//	Do the captures until done, interrupted, or cooling aborts
-		err = session.CaptureFrames(areDarksFirst(cmd), biasFrames, darkFrames)
+		//	Ctrl-C or SIGTERM cancels the session's context: waits end at once and the capture stops
+		//	after the current frame, so the cooler can be turned off and the connection closed.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go handleCaptureSignals(signals, cancel)
 
-		//	Stop cooling
-		err = session.StopCooling()
+		delay, targetTime, err := config.ParseStart()
 		if err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
 			return
 		}
 
+		//	Capture until done, interrupted, or stopped by cooling or connection problems, then
+		//	report what was achieved, in the output and the exit code
+		started := time.Now()
+		captureSession.RecordSessionStart(biasFrames, darkFrames, flatDarkFrames)
+		captureErr := runCapture(ctx, captureSession, delay, targetTime, areDarksFirst(cmd), temperatures,
+			biasFrames, darkFrames, flatDarkFrames)
+		captureSession.RecordSessionEnd(captureErr)
+		printCaptureSummary(captureSession.Results(), time.Since(started), captureErr)
+		exitCode = captureExitCode(captureErr)
 	},
 }
 
+// runCapture waits for a delayed start, if wanted, then connects to the server and captures the
+// frames at each cooling temperature.  Once connected, the cooler is turned off at the end
+// whether or not the capture succeeded.
+func runCapture(ctx context.Context, captureSession *session.Session, delay bool, targetTime time.Time,
+	darksFirst bool, temperatures []float64, biasFrames []string, darkFrames []string, flatDarkFrames []string) error {
+	if delay {
+		if err := captureSession.DelayStart(ctx, targetTime); err != nil {
+			return err
+		}
+	}
+	if err := captureSession.ConnectToServer(); err != nil {
+		return err
+	}
+	captureErr := captureSession.CaptureFramesAtTemperatures(ctx, darksFirst, temperatures, biasFrames, darkFrames, flatDarkFrames)
+	if err := captureSession.StopCooling(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	}
+	return captureErr
+}
+
+// captureExitCode classifies how a capture ended, for the program's exit status
+func captureExitCode(captureErr error) int {
+	switch {
+	case captureErr == nil:
+		return exitComplete
+	case errors.Is(captureErr, session.ErrInterrupted):
+		return exitInterrupted
+	case errors.Is(captureErr, session.ErrCameraSettingsUnsupported), errors.Is(captureErr, session.ErrFlatDarkTagUnsupported):
+		return exitConfigError
+	case errors.Is(captureErr, session.ErrConnectionFailed):
+		return exitConnectionFailed
+	case errors.Is(captureErr, session.ErrCoolingTimeout), errors.Is(captureErr, session.ErrCoolingAbort):
+		return exitCoolingAbort
+	default:
+		return exitIncomplete
+	}
+}
+
+// printCaptureSummary reports, for each frame set worked on, the frames captured by this run and
+// how many remain, followed by the total time taken and the reason the capture stopped
+func printCaptureSummary(results []session.SetResult, elapsed time.Duration, captureErr error) {
+	fmt.Println("Capture summary")
+	framesCaptured := 0
+	if len(results) > 0 {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		_, _ = fmt.Fprintln(writer, "Temp\tType\tExposure\tBinning\tRequired\tCaptured\tDone\tRemaining\t")
+		for _, result := range results {
+			temperature := "-"
+			if result.Cooled {
+				temperature = fmt.Sprintf("%g", result.Temperature)
+			}
+			exposure := fmt.Sprintf("%.2f", result.Exposure)
+			if result.FrameType == "Bias" {
+				exposure = "-"
+			}
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t\n", temperature, result.FrameType,
+				exposure, result.Binning, result.Count, result.Captured, result.Done, result.Remaining)
+			framesCaptured += result.Captured
+		}
+		_ = writer.Flush()
+	}
+	fmt.Printf("Frames captured: %d\n", framesCaptured)
+	fmt.Printf("Total time:      %s\n", elapsed.Round(time.Second))
+	switch {
+	case captureErr == nil:
+		fmt.Println("Stopped because: all frames captured")
+	case errors.Is(captureErr, session.ErrInterrupted):
+		fmt.Println("Stopped because: interrupted")
+	default:
+		fmt.Printf("Stopped because: %v\n", captureErr)
+	}
+}
+
+// handleCaptureSignals cancels the capture when the first interrupt arrives.  A second interrupt,
+// for when the current frame is too long to wait for, exits at once without cleaning up.
+func handleCaptureSignals(signals chan os.Signal, cancel context.CancelFunc) {
+	<-signals
+	cancel()
+	logging.Default().Minimalf("Interrupted: stopping after the current frame (interrupt again to quit immediately)")
+	<-signals
+	logging.Default().Errorf("Interrupted again: quitting without turning off the cooler or saving progress")
+	os.Exit(exitInterrupted)
+}
+
 //	User may use the --coolto flag thinking that is sufficient to turn on cooling
 //	(it isn't - also need the useCooling flag).  If --coolto flag is explicitly used
 //	then we'll set --useCooling on.  We'll warn them if this was a change.
 
 func consistentizeCooling(cmd *cobra.Command) {
-	if config.FlagExplicitlySet(cmd, "coolto") {
+	if config.FlagExplicitlySet(cmd, "coolto") || config.FlagExplicitlySet(cmd, "cooltolist") {
 		if !viper.GetBool(config.UseCoolerSetting) {
-			fmt.Println("--coolto used without --usecooler. Turning --usecooler on too.")
+			logging.Default().Minimalf("--coolto used without --usecooler. Turning --usecooler on too.")
 			viper.Set(config.UseCoolerSetting, true)
 		}
 	}
 }
 
-func validateDarkFrames(frameStrings []string) error {
-	for _, frameString := range frameStrings {
-		_, _, _, err := config.ParseDarkSet(frameString)
-		if err != nil {
-			return err
-		}
+// consistentizeOrder lets a --darkfirst or --biasfirst flag override the other setting from the
+// config file, so only giving both flags counts as a conflict
+func consistentizeOrder(cmd *cobra.Command) {
+	darkFlag := config.FlagExplicitlySet(cmd, "darkfirst")
+	biasFlag := config.FlagExplicitlySet(cmd, "biasfirst")
+	if darkFlag && !biasFlag {
+		viper.Set(config.BiasFirstSetting, false)
 	}
-	return nil
+	if biasFlag && !darkFlag {
+		viper.Set(config.DarkFirstSetting, false)
+	}
+}
+
+// captureTemperatures returns the cooling temperatures to capture at, in order.  A --coolto flag
+// asks for just that temperature, even if the config file lists several.
+func captureTemperatures(cmd *cobra.Command) ([]float64, error) {
+	if config.FlagExplicitlySet(cmd, "coolto") && !config.FlagExplicitlySet(cmd, "cooltolist") {
+		return []float64{viper.GetFloat64(config.CoolToSetting)}, nil
+	}
+	return config.CoolingTemperatures()
+}
+
+// stateTemperatures returns the cooling temperatures whose state files status, reset and history
+// work on: the command's own --coolto if given, otherwise every temperature a capture would use
+func stateTemperatures(cmd *cobra.Command, coolToFlag float64) ([]float64, error) {
+	if config.FlagExplicitlySet(cmd, "coolto") {
+		return []float64{coolToFlag}, nil
+	}
+	return config.CoolingTemperatures()
+}
+
+// printTemperatureHeading separates the output for each temperature, when there is more than one
+func printTemperatureHeading(temperatures []float64, coolTo float64) {
+	if len(temperatures) > 1 {
+		fmt.Printf("\nCooling temperature %g\n", coolTo)
+	}
+}
+
+// captureFrameSets returns the bias, dark and flat-dark sets to capture, as set strings, whether
+// they were given as strings or, in the config file, as mappings
+func captureFrameSets() ([]string, []string, []string, error) {
+	biasFrames, err := config.FrameSets(config.BiasFramesSetting)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	darkFrames, err := config.FrameSets(config.DarkFramesSetting)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	flatDarkFrames, err := config.FrameSets(config.FlatDarkFramesSetting)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return biasFrames, darkFrames, flatDarkFrames, nil
 }
 
-func validateBiasFrames(frameStrings []string) error {
-	for _, frameString := range frameStrings {
-		_, _, err := config.ParseBiasSet(frameString)
+// printCaptureEstimate shows how long the capture should take, and when it should finish,
+// taking into account frames already done according to the state files and any delayed start
+func printCaptureEstimate(captureSession *session.Session, temperatures []float64,
+	biasFrames []string, darkFrames []string, flatDarkFrames []string) error {
+	coolingWaitSeconds := 0.0
+	if viper.GetBool(config.UseCoolerSetting) {
+		coolingWaitSeconds = float64(viper.GetInt(config.CoolWaitMinutesSetting) * 60)
+	}
+	var estimate session.CaptureEstimate
+	for _, temperature := range temperatures {
+		captureSession.UseCoolingTemperature(temperature)
+		plan, err := captureSession.PreviewCapturePlan(biasFrames, darkFrames, flatDarkFrames)
 		if err != nil {
 			return err
 		}
+		temperatureEstimate, err := session.EstimateCapture(plan,
+			!viper.GetBool(config.NoDarkSetting), !viper.GetBool(config.NoBiasSetting),
+			!viper.GetBool(config.NoFlatDarkSetting), coolingWaitSeconds)
+		if err != nil {
+			return err
+		}
+		estimate.Add(temperatureEstimate)
+	}
+	delay, startTime, err := config.ParseStart()
+	if err != nil {
+		return err
+	}
+	if !delay {
+		startTime = time.Now()
+	}
+	finishTime := startTime.Add(time.Duration(estimate.TotalSeconds() * float64(time.Second)))
+
+	if len(temperatures) > 1 {
+		fmt.Printf("Cooling temperatures: %v\n", temperatures)
+	}
+	fmt.Printf("Frames remaining to capture: %d\n", estimate.RemainingFrames)
+	fmt.Printf("   Imaging time:      %s\n", formatSeconds(estimate.ImagingSeconds))
+	fmt.Printf("   Download overhead: %s\n", formatSeconds(estimate.DownloadSeconds))
+	if len(estimate.UnmeasuredBinnings) > 0 {
+		fmt.Printf("      (not including binning %v, download time not yet measured)\n", estimate.UnmeasuredBinnings)
+	}
+	if viper.GetBool(config.UseCoolerSetting) {
+		fmt.Printf("   Cooling wait:      up to %s\n", formatSeconds(estimate.CoolingWaitSeconds))
+	}
+	fmt.Printf("   Total:             %s\n", formatSeconds(estimate.TotalSeconds()))
+	if delay {
+		fmt.Printf("Delayed start at %s\n", startTime.Format("2006-01-02 15:04"))
 	}
+	fmt.Printf("Projected finish:  %s\n", finishTime.Format("2006-01-02 15:04"))
 	return nil
 }
 
@@ -137,7 +380,7 @@ func init() {
 // Determine which set of frames to do first.  We return this result by returning a boolean
 // - true if darks are to be done first
 // - false if bias frames are to be done first
-// If one of (bias, dark) is explicitly set in the cli, use that (darks if both are specified)
+// If one of (bias, dark) is explicitly set in the cli, use that (validation rejects both)
 // Otherwise, use the setting in the config file
 func areDarksFirst(cmd *cobra.Command) bool {
 	if config.FlagExplicitlySet(cmd, "darkfirst") {
@@ -0,0 +1,182 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"goskydarks/session"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the frames recorded in the state file's capture history",
+	Long: `Reads the state file for each configured cooling temperature and lists every frame capture
+attempted: when it finished, its set, the sensor temperature before and after, the download time
+allowed, and the result TheSkyX reported.  A summary follows, with the number of failures and the
+range of sensor temperatures, to help judge the quality of a dark library long after it was captured.
+Select frames with --from and --to (dates as 2006-01-02, or 2006-01-02 15:04, in local time; a --to
+date without a time includes that whole day) and --key (a set key, as listed), and a single
+cooling temperature with --coolto.
+TheSkyX is not contacted.
+Exits with status 2 if no state file is configured or a selection is wrong, or 6 if a state file
+can't be read.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetBool(config.ShowSettingsSetting) {
+			config.ShowAllSettings()
+		}
+		if viper.GetString(config.StateFileSetting) == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "State file is required for history")
+			exitCode = exitConfigError
+			return
+		}
+		filter, err := historyFilter()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
+			return
+		}
+		temperatures, err := stateTemperatures(cmd, historyCoolTo)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
+			return
+		}
+		for _, coolTo := range temperatures {
+			printTemperatureHeading(temperatures, coolTo)
+			if err := reportHistory(coolTo, filter); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				exitCode = exitStateFileError
+			}
+		}
+	},
+}
+
+// reportHistory lists the selected frames recorded in the state file for one cooling temperature
+func reportHistory(coolTo float64, filter session.HistoryFilter) error {
+	stateFileService := session.NewStateStore(viper.GetString(config.StateFileSetting), coolTo)
+	plan, err := stateFileService.ReadStateFile()
+	if err != nil {
+		return err
+	}
+	if plan == nil {
+		fmt.Printf("No state file for cooling temperature %g - nothing has been captured yet\n", coolTo)
+		return nil
+	}
+	printFrameHistory(plan.FrameHistory(filter))
+	return nil
+}
+
+// Flags of the history command; not bound to viper, as they only select what to list
+var (
+	historyCoolTo float64
+	historyFrom   string
+	historyTo     string
+	historyKey    string
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().Float64VarP(&historyCoolTo, "coolto", "t", 0.0, "Cooling temperature whose state file to read (default: from config)")
+	historyCmd.Flags().StringVar(&historyFrom, "from", "", "List frames captured on or after this date (and time)")
+	historyCmd.Flags().StringVar(&historyTo, "to", "", "List frames captured before this time, or up to the end of this date")
+	historyCmd.Flags().StringVar(&historyKey, "key", "", "List frames of the set with this key")
+}
+
+// historyFilter makes the history selection from the command's flags
+func historyFilter() (session.HistoryFilter, error) {
+	filter := session.HistoryFilter{Key: historyKey}
+	if historyFrom != "" {
+		from, _, err := parseHistoryTime(historyFrom)
+		if err != nil {
+			return filter, fmt.Errorf("--from: %w", err)
+		}
+		filter.From = from
+	}
+	if historyTo != "" {
+		to, dateOnly, err := parseHistoryTime(historyTo)
+		if err != nil {
+			return filter, fmt.Errorf("--to: %w", err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("--from must be before --to")
+	}
+	return filter, nil
+}
+
+// parseHistoryTime parses a local date, or date and time, reporting whether it was a date only
+func parseHistoryTime(text string) (time.Time, bool, error) {
+	if parsed, err := time.ParseInLocation("2006-01-02 15:04", text, time.Local); err == nil {
+		return parsed, false, nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", text, time.Local)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is not a date (2006-01-02) or date and time (2006-01-02 15:04)", text)
+	}
+	return parsed, true, nil
+}
+
+// printFrameHistory prints a table of frame records, then a summary of them
+func printFrameHistory(records []session.FrameRecord) {
+	if len(records) == 0 {
+		fmt.Println("No frames recorded")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "Time\tKey\tExposure\tBinning\tTemp before\tTemp after\tDownload\tResult")
+	failures := 0
+	var coldest, warmest *float64
+	for _, record := range records {
+		exposure := fmt.Sprintf("%.2f", record.Exposure)
+		if record.FrameType == "Bias" {
+			exposure = "-"
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\t%s\t%.2f\t%s\n",
+			record.Time.Local().Format("2006-01-02 15:04:05"), record.Key, exposure, record.Binning,
+			formatTemperature(record.TemperatureBefore), formatTemperature(record.TemperatureAfter),
+			record.DownloadSeconds, record.Result)
+		if !record.Succeeded() {
+			failures++
+		}
+		for _, temperature := range []*float64{record.TemperatureBefore, record.TemperatureAfter} {
+			if temperature == nil {
+				continue
+			}
+			if coldest == nil || *temperature < *coldest {
+				coldest = temperature
+			}
+			if warmest == nil || *temperature > *warmest {
+				warmest = temperature
+			}
+		}
+	}
+	_ = writer.Flush()
+
+	fmt.Printf("%d frames, %d failed\n", len(records), failures)
+	if coldest != nil {
+		fmt.Printf("Sensor temperature from %.2f to %.2f\n", *coldest, *warmest)
+	}
+}
+
+// formatTemperature displays a recorded temperature, which may not have been readable
+func formatTemperature(temperature *float64) string {
+	if temperature == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", *temperature)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"goskydarks/session"
+	"os"
+)
+
+// resetCmd represents the reset command
+var resetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Reset the state file so capture starts over",
+	Long: `Resets the state file for each configured cooling temperature (or just --coolto), so the next capture
+does not pick up where a previous run left off.  Choose what to reset:
+   --all             delete the state file entirely, with its backup and frame history
+   --done            set the "done" count of every set back to zero
+   --downloadtimes   forget the measured download times, so they are measured again
+   --key KEY         remove one set, e.g. --key Dark_300.0000_1_-10.00C (see the status command)
+--done, --downloadtimes and --key may be combined.  With the sqlite state store, --all removes
+everything recorded at the temperature, including its history; other temperatures are kept.
+Exits with status 2 if no state file is configured or the options are wrong (including a --key no
+state file has), or 6 if a state file can't be read, changed or deleted.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetBool(config.ShowSettingsSetting) {
+			config.ShowAllSettings()
+		}
+		if viper.GetString(config.StateFileSetting) == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "State file is required for reset")
+			exitCode = exitConfigError
+			return
+		}
+		if err := validateResetFlags(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
+			return
+		}
+		temperatures, err := stateTemperatures(cmd, resetOptions.coolTo)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
+			return
+		}
+		keyFound := false
+		for _, coolTo := range temperatures {
+			printTemperatureHeading(temperatures, coolTo)
+			removed, err := resetStateFile(coolTo)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				exitCode = exitStateFileError
+			}
+			keyFound = keyFound || removed
+		}
+		//	A key names its temperature, so it is only an error if no state file had it
+		if resetOptions.key != "" && !keyFound {
+			_, _ = fmt.Fprintf(os.Stderr, "Set %s is not in the state file\n", resetOptions.key)
+			if exitCode == exitComplete {
+				exitCode = exitConfigError
+			}
+		}
+	},
+}
+
+// resetStateFile resets the state file for one cooling temperature, as the options ask,
+// reporting whether it held the set to be removed with --key
+func resetStateFile(coolTo float64) (bool, error) {
+	stateFileService := session.NewStateStore(viper.GetString(config.StateFileSetting), coolTo)
+	if resetOptions.all {
+		if err := stateFileService.DeleteStateFile(); err != nil {
+			return false, err
+		}
+		fmt.Printf("State file for cooling temperature %g deleted\n", coolTo)
+		return false, nil
+	}
+
+	plan, err := stateFileService.ReadStateFile()
+	if err != nil {
+		return false, err
+	}
+	if plan == nil {
+		fmt.Printf("No state file for cooling temperature %g - nothing to reset\n", coolTo)
+		return false, nil
+	}
+	if resetOptions.done {
+		plan.ClearDoneCounts()
+		fmt.Println("Done counts set to zero")
+	}
+	if resetOptions.downloadTimes {
+		plan.ClearDownloadTimes()
+		fmt.Println("Download times cleared")
+	}
+	removed := false
+	if resetOptions.key != "" {
+		removed = plan.RemoveSet(resetOptions.key)
+		if removed {
+			fmt.Printf("Set %s removed\n", resetOptions.key)
+		} else if !resetOptions.done && !resetOptions.downloadTimes {
+			return false, nil
+		}
+	}
+	return removed, stateFileService.SavePlanToFile(plan)
+}
+
+// resetOptions are local to the reset command, not bound to viper, since they are
+// one-off actions rather than settings
+var resetOptions struct {
+	all           bool
+	done          bool
+	downloadTimes bool
+	key           string
+	coolTo        float64
+}
+
+func init() {
+	rootCmd.AddCommand(resetCmd)
+	resetCmd.Flags().BoolVarP(&resetOptions.all, "all", "", false, "Delete the state file")
+	resetCmd.Flags().BoolVarP(&resetOptions.done, "done", "", false, "Set all done counts to zero")
+	resetCmd.Flags().BoolVarP(&resetOptions.downloadTimes, "downloadtimes", "", false, "Clear measured download times")
+	resetCmd.Flags().StringVarP(&resetOptions.key, "key", "", "", "Remove the set with this key, e.g. Dark_300.0000_1_-10.00C")
+	resetCmd.Flags().Float64VarP(&resetOptions.coolTo, "coolto", "t", 0.0, "Cooling temperature whose state file to reset (default: from config)")
+}
+
+func validateResetFlags() error {
+	if !resetOptions.all && !resetOptions.done && !resetOptions.downloadTimes && resetOptions.key == "" {
+		return errors.New("specify what to reset: --all, --done, --downloadtimes, or --key")
+	}
+	if resetOptions.all && (resetOptions.done || resetOptions.downloadTimes || resetOptions.key != "") {
+		return errors.New("--all deletes the whole state file and can't be combined with other reset options")
+	}
+	return nil
+}
@@ -6,14 +6,23 @@ package cmd
 import (
 	"fmt"
 	"goskydarks/config"
+	"goskydarks/logging"
 	"os"
+	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var Settings *config.SettingsType
 
+// coolToListFlag receives --cooltolist; viper converts its strings to the numbers in Settings.Cooling.CoolToList
+var coolToListFlag []string
+
+// configFile receives --config, an explicit path to the config file instead of searching for one
+var configFile string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "goskydarks",
@@ -29,6 +38,11 @@ network. They will be saved on the computer where TheSkyX is running, in the loc
 specified in the "autosave location" in the application.  You can configure theSky to save
 to a network drive if you like, but that is up to you. This program only causes the images
 to be captured, it does not deal with where they are stored.
+
+Settings are read from the file given with --config (or the GOSKYDARKS_CONFIG environment variable),
+otherwise from the first config.yml found in the current directory, $XDG_CONFIG_HOME/goskydarks,
+~/.config/goskydarks and /etc/goskydarks.  Environment variables such as GOSKYDARKS_VERBOSITY or
+GOSKYDARKS_COOLING_COOLTO override the config file, and command line flags override both.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if viper.GetBool(config.ShowSettingsSetting) {
@@ -48,11 +62,29 @@ to be captured, it does not deal with where they are stored.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := rootCmd.Execute()
+	_ = logging.Default().Close()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitConfigError)
+	}
+	if exitCode != exitComplete {
+		os.Exit(exitCode)
 	}
 }
 
+// exitCode is set by commands whose outcome should be visible to a calling script
+var exitCode = exitComplete
+
+// Exit codes, so scripts (e.g. run from cron) can tell how a capture went
+const (
+	exitComplete         = 0   // Everything asked for was done
+	exitConfigError      = 2   // Bad command line or configuration; nothing was attempted
+	exitConnectionFailed = 3   // Couldn't reach the server, or lost it and couldn't reconnect
+	exitCoolingAbort     = 4   // Camera couldn't reach, or drifted from, the cooling target
+	exitIncomplete       = 5   // Stopped before all frames were captured, for some other reason
+	exitStateFileError   = 6   // The state file couldn't be read, changed or deleted
+	exitInterrupted      = 130 // Stopped by Ctrl-C or SIGTERM (128 + SIGINT, by convention)
+)
+
 func init() {
 	rootCmd.Root().CompletionOptions.DisableDefaultCmd = true
 
@@ -60,7 +92,9 @@ func init() {
 
 	defineGlobalSettings()
 	defineCaptureSettings()
-	readConfigFile()
+	defineSimulatorSettings()
+	//	The config file is read once the command line is parsed, so --profile can choose a profile in it
+	cobra.OnInitialize(readConfigFile, initLogging)
 
 }
 
@@ -74,37 +108,101 @@ func defineGlobalSettings() {
 	rootCmd.PersistentFlags().StringVarP(&Settings.StateFile, "statefile", "", "./stateFile", "State file to store session status")
 	_ = viper.BindPFlag("statefile", rootCmd.PersistentFlags().Lookup("statefile"))
 
+	rootCmd.PersistentFlags().StringVarP(&Settings.StateStore, "statestore", "", config.StateStoreFile, "Keep state in a JSON file per temperature (file) or one SQLite database (sqlite)")
+	_ = viper.BindPFlag(config.StateStoreSetting, rootCmd.PersistentFlags().Lookup("statestore"))
+
+	rootCmd.PersistentFlags().StringVarP(&Settings.LogFile, "logfile", "", "", "Also write log output to this file")
+	_ = viper.BindPFlag(config.LogFileSetting, rootCmd.PersistentFlags().Lookup("logfile"))
+
+	rootCmd.PersistentFlags().StringVarP(&Settings.LogFormat, "logformat", "", "text", "Log file format: text or json")
+	_ = viper.BindPFlag(config.LogFormatSetting, rootCmd.PersistentFlags().Lookup("logformat"))
+
+	rootCmd.PersistentFlags().IntVarP(&Settings.LogFileVerbosity, "logfileverbosity", "", 5, "Verbosity level from 0 to 5 for the log file")
+	_ = viper.BindPFlag(config.LogFileVerbositySetting, rootCmd.PersistentFlags().Lookup("logfileverbosity"))
+
 	rootCmd.PersistentFlags().BoolVarP(&Settings.ShowSettings, "showsettings", "", false, "show settings")
 	_ = viper.BindPFlag("showsettings", rootCmd.PersistentFlags().Lookup("showsettings"))
 
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "", "",
+		fmt.Sprintf("Config file to use (default: config.yml in %s)", strings.Join(config.ConfigSearchPaths(), ", ")))
+
+	rootCmd.PersistentFlags().StringVarP(&Settings.Profile, "profile", "", "", "Use this profile from the config file's profiles section")
+	_ = viper.BindPFlag(config.ProfileSetting, rootCmd.PersistentFlags().Lookup("profile"))
+
 }
 
 func readConfigFile() {
-	//	Read config settings from config file
-	viper.SetConfigName("config")
+	//	GOSKYDARKS_ environment variables override the config file, and flags override them
+	viper.SetEnvPrefix(config.EnvPrefix)
+	viper.SetEnvKeyReplacer(config.EnvKeyReplacer)
+	viper.AutomaticEnv()
+
+	//	Read config settings from the given config file, or the first one found in the search paths
+	if configFile == "" {
+		configFile = os.Getenv(config.ConfigFileEnv)
+	}
 	viper.SetConfigType("yml")
-	viper.AddConfigPath(".")
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName(config.ConfigFileName)
+		for _, path := range config.ConfigSearchPaths() {
+			viper.AddConfigPath(path)
+		}
+	}
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			//	No config file is not an error. Just mention it and then leave
-			fmt.Println("No config.yml file found")
+			//	No config file is not an error, unless a profile in it was asked for. Just mention it and then leave
+			fmt.Println("No config.yml file found in", strings.Join(config.ConfigSearchPaths(), ", "))
+			if profile := viper.GetString(config.ProfileSetting); profile != "" {
+				fmt.Printf("Profile %q needs a config file\n", profile)
+				os.Exit(exitConfigError)
+			}
 			return
 		}
 		fmt.Println("Error reading config:", err)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
-	if err := viper.UnmarshalExact(Settings); err != nil {
-		fmt.Println("Unmarshal err:", err)
-		os.Exit(1)
+	if profile := viper.GetString(config.ProfileSetting); profile != "" {
+		if err := config.ApplyProfile(profile); err != nil {
+			fmt.Println("Error in config:", err)
+			os.Exit(exitConfigError)
+		}
 	}
-
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 3 {
-		fmt.Printf("Read configuration from file: %s\n", viper.ConfigFileUsed())
+	//	Frame sets may be written as mappings in the config file; they are unmarshalled as strings
+	frameSetHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		config.FrameSetDecodeHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := viper.UnmarshalExact(Settings, frameSetHook); err != nil {
+		fmt.Println("Unmarshal err:", err)
+		os.Exit(exitConfigError)
 	}
 
 	if err := config.ValidateGlobals(); err != nil {
 		fmt.Println("Error validating global settings:", err)
-		os.Exit(1)
+		os.Exit(exitConfigError)
+	}
+}
+
+// initLogging sets up the default logger once the config file and command line flags have
+// both been processed, so the verbosity and log file settings are final
+func initLogging() {
+	logger, err := logging.New(logging.Options{
+		Verbosity:     viper.GetInt(config.VerbositySetting),
+		Debug:         viper.GetBool(config.DebugSetting),
+		LogFile:       viper.GetString(config.LogFileSetting),
+		LogFormat:     viper.GetString(config.LogFormatSetting),
+		FileVerbosity: viper.GetInt(config.LogFileVerbositySetting),
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitConfigError)
+	}
+	logging.SetDefault(logger)
+	if viper.ConfigFileUsed() != "" {
+		logger.Verbosef("Read configuration from file: %s", viper.ConfigFileUsed())
 	}
 }
 
@@ -112,6 +210,7 @@ func defineCaptureSettings() {
 	captureCmd := findCommand(rootCmd, "capture")
 
 	defineServerFlags(captureCmd)
+	defineRetryFlags(captureCmd)
 	defineStartDelayFlags(captureCmd)
 	defineCoolingFlags(captureCmd)
 	defineFramesFlags(captureCmd)
@@ -123,9 +222,12 @@ func defineFramesFlags(_ *cobra.Command) {
 	captureCmd.Flags().StringArrayVarP(&Settings.BiasFrames, "bias", "b", []string{}, "Bias frame \"count,binning\" - can repeat multiple times")
 	_ = viper.BindPFlag(config.BiasFramesSetting, captureCmd.Flags().Lookup("bias"))
 
-	captureCmd.Flags().StringArrayVarP(&Settings.DarkFrames, "dark", "d", []string{}, "Dark frame \"count,seconds,binning\" - can repeat multiple times")
+	captureCmd.Flags().StringArrayVarP(&Settings.DarkFrames, "dark", "d", []string{}, "Dark frame \"count,seconds,binning\", or a series like \"20,30..600x2,1\" - can repeat multiple times")
 	_ = viper.BindPFlag(config.DarkFramesSetting, captureCmd.Flags().Lookup("dark"))
 
+	captureCmd.Flags().StringArrayVarP(&Settings.FlatDarks, "flatdark", "", []string{}, "Flat-dark frame \"count,seconds,binning\" - can repeat multiple times")
+	_ = viper.BindPFlag(config.FlatDarkFramesSetting, captureCmd.Flags().Lookup("flatdark"))
+
 	captureCmd.Flags().BoolVarP(&Settings.ClearDone, "cleardone", "", false, "Clear done counts in state file, start from zero")
 	_ = viper.BindPFlag(config.ClearDoneSetting, captureCmd.Flags().Lookup("cleardone"))
 
@@ -135,12 +237,31 @@ func defineFramesFlags(_ *cobra.Command) {
 	captureCmd.Flags().BoolVarP(&Settings.NoBias, "nobias", "", false, "Don't do bias frames, regardless of the list")
 	_ = viper.BindPFlag(config.NoBiasSetting, captureCmd.Flags().Lookup("nobias"))
 
+	captureCmd.Flags().BoolVarP(&Settings.NoFlatDark, "noflatdark", "", false, "Don't do flat-dark frames, regardless of the list")
+	_ = viper.BindPFlag(config.NoFlatDarkSetting, captureCmd.Flags().Lookup("noflatdark"))
+
 	captureCmd.Flags().BoolVarP(&Settings.DarkFirst, "darkfirst", "", false, "Do Dark frames first")
 	_ = viper.BindPFlag(config.DarkFirstSetting, captureCmd.Flags().Lookup("darkfirst"))
 
 	captureCmd.Flags().BoolVarP(&Settings.BiasFirst, "biasfirst", "", false, "Do Bias frames first")
 	_ = viper.BindPFlag(config.BiasFirstSetting, captureCmd.Flags().Lookup("biasfirst"))
 
+	captureCmd.Flags().BoolVarP(&Settings.FlatDarkFirst, "flatdarkfirst", "", false, "Do Flat-dark frames before darks and bias (otherwise after)")
+	_ = viper.BindPFlag(config.FlatDarkFirstSetting, captureCmd.Flags().Lookup("flatdarkfirst"))
+
+	captureCmd.Flags().StringVarP(&Settings.CaptureOrder, "captureorder", "", config.CaptureOrderByType,
+		"Order to capture sets: bytype, roundrobin, shortestfirst, longestfirst, or bybinning")
+	_ = viper.BindPFlag(config.CaptureOrderSetting, captureCmd.Flags().Lookup("captureorder"))
+
+	captureCmd.Flags().BoolVarP(&Settings.Estimate, "estimate", "", false, "Estimate how long the capture will take, then stop")
+	_ = viper.BindPFlag(config.EstimateSetting, captureCmd.Flags().Lookup("estimate"))
+
+	captureCmd.Flags().BoolVarP(&Settings.DryRun, "dryrun", "", false, "Show what the capture would do, without contacting TheSkyX or waiting")
+	_ = viper.BindPFlag(config.DryRunSetting, captureCmd.Flags().Lookup("dryrun"))
+
+	captureCmd.Flags().StringVarP(&Settings.EventLog, "eventlog", "", "", "Append a JSON Lines record of session events to this file")
+	_ = viper.BindPFlag(config.EventLogSetting, captureCmd.Flags().Lookup("eventlog"))
+
 }
 
 func defineServerFlags(captureCmd *cobra.Command) {
@@ -153,6 +274,23 @@ func defineServerFlags(captureCmd *cobra.Command) {
 
 }
 
+func defineRetryFlags(captureCmd *cobra.Command) {
+
+	captureCmd.Flags().IntVarP(&Settings.Retry.Attempts, "retryattempts", "", 3, "Tries of each server request before giving up (1 for no retries)")
+	_ = viper.BindPFlag(config.RetryAttemptsSetting, captureCmd.Flags().Lookup("retryattempts"))
+
+	captureCmd.Flags().IntVarP(&Settings.Retry.BackoffSeconds, "retrybackoff", "", 10, "Seconds to wait before reconnecting; doubled for each further attempt")
+	_ = viper.BindPFlag(config.RetryBackoffSecondsSetting, captureCmd.Flags().Lookup("retrybackoff"))
+
+	captureCmd.Flags().IntVarP(&Settings.Retry.MaxBackoffSeconds, "retrymaxbackoff", "", 300, "Longest wait in seconds between reconnects")
+	_ = viper.BindPFlag(config.RetryMaxBackoffSecondsSetting, captureCmd.Flags().Lookup("retrymaxbackoff"))
+
+	captureCmd.Flags().StringSliceVarP(&Settings.Retry.Transient, "retrytransient", "", config.DefaultTransientErrors,
+		"Error text that marks a dropped connection, worth retrying")
+	_ = viper.BindPFlag(config.RetryTransientSetting, captureCmd.Flags().Lookup("retrytransient"))
+
+}
+
 func defineStartDelayFlags(captureCmd *cobra.Command) {
 
 	captureCmd.Flags().BoolVarP(&Settings.Start.Delay, "delaystart", "", false, "Delay start until later")
@@ -174,6 +312,9 @@ func defineCoolingFlags(captureCmd *cobra.Command) {
 	captureCmd.Flags().Float64VarP(&Settings.Cooling.CoolTo, "coolto", "t", 0.0, "Camera target temperature")
 	_ = viper.BindPFlag(config.CoolToSetting, captureCmd.Flags().Lookup("coolto"))
 
+	captureCmd.Flags().StringSliceVarP(&coolToListFlag, "cooltolist", "", []string{}, "Camera target temperatures, captured at each in turn (e.g. --cooltolist=-5,-10)")
+	_ = viper.BindPFlag(config.CoolToListSetting, captureCmd.Flags().Lookup("cooltolist"))
+
 	captureCmd.Flags().Float64VarP(&Settings.Cooling.CoolStartTol, "coolstarttol", "", 2.0, "Cooling start tolerance")
 	_ = viper.BindPFlag(config.CoolStartTolSetting, captureCmd.Flags().Lookup("coolstarttol"))
 
@@ -193,6 +334,27 @@ func defineCoolingFlags(captureCmd *cobra.Command) {
 	_ = viper.BindPFlag(config.CoolerOffAtEndSetting, captureCmd.Flags().Lookup("coolingoffafter"))
 }
 
+func defineSimulatorSettings() {
+
+	simulateCmd.Flags().IntVarP(&Settings.Simulator.Port, "port", "", 3040, "Port number for simulator to listen on")
+	_ = viper.BindPFlag(config.SimulatorPortSetting, simulateCmd.Flags().Lookup("port"))
+
+	simulateCmd.Flags().Float64VarP(&Settings.Simulator.AmbientTemperature, "ambient", "", 20.0, "Simulated camera temperature with cooler off")
+	_ = viper.BindPFlag(config.SimulatorAmbientSetting, simulateCmd.Flags().Lookup("ambient"))
+
+	simulateCmd.Flags().Float64VarP(&Settings.Simulator.CoolingTimeConstant, "cooltimeconstant", "", 120.0, "Seconds for simulated camera to cover 63% of a temperature change")
+	_ = viper.BindPFlag(config.SimulatorCoolingTimeConstantSetting, simulateCmd.Flags().Lookup("cooltimeconstant"))
+
+	simulateCmd.Flags().Float64VarP(&Settings.Simulator.MaxCoolingDelta, "maxcoolingdelta", "", 35.0, "How far below ambient the simulated cooler can reach")
+	_ = viper.BindPFlag(config.SimulatorMaxCoolingDeltaSetting, simulateCmd.Flags().Lookup("maxcoolingdelta"))
+
+	simulateCmd.Flags().Float64VarP(&Settings.Simulator.FullFrameDownloadSeconds, "downloadseconds", "", 6.0, "Simulated download time of an unbinned frame")
+	_ = viper.BindPFlag(config.SimulatorDownloadSecondsSetting, simulateCmd.Flags().Lookup("downloadseconds"))
+
+	simulateCmd.Flags().Float64VarP(&Settings.Simulator.TimeScale, "timescale", "", 1.0, "Simulated seconds per real second")
+	_ = viper.BindPFlag(config.SimulatorTimeScaleSetting, simulateCmd.Flags().Lookup("timescale"))
+}
+
 func findCommand(rootCmd *cobra.Command, name string) *cobra.Command {
 	commands := rootCmd.Commands()
 	for _, cmd := range commands {
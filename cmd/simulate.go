@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"goskydarks/logging"
+	"goskydarks/simulator"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Run a simulated TheSkyX server for testing",
+	Long: `Starts a local TCP server that speaks the same JavaScript-over-socket protocol as 
+the server inside TheSkyX, with a simulated camera.  The simulated camera models the sensor 
+temperature as the cooler runs, the time an exposure takes, and the time to download the image.
+
+Run this in one window, then run the capture command in another with --server localhost and 
+the same port, to exercise a whole capture session with no telescope attached.
+Use --timescale to make simulated time run faster than real time.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetBool(config.ShowSettingsSetting) {
+			config.ShowAllSettings()
+		}
+		server := simulator.NewServer(simulator.Settings{
+			Port:                     viper.GetInt(config.SimulatorPortSetting),
+			AmbientTemperature:       viper.GetFloat64(config.SimulatorAmbientSetting),
+			CoolingTimeConstant:      viper.GetFloat64(config.SimulatorCoolingTimeConstantSetting),
+			MaxCoolingDelta:          viper.GetFloat64(config.SimulatorMaxCoolingDeltaSetting),
+			FullFrameDownloadSeconds: viper.GetFloat64(config.SimulatorDownloadSecondsSetting),
+			TimeScale:                viper.GetFloat64(config.SimulatorTimeScaleSetting),
+			Verbosity:                viper.GetInt(config.VerbositySetting),
+			Debug:                    viper.GetBool(config.DebugSetting),
+		})
+
+		//	Run until interrupted
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			logging.Default().Minimalf("Stopping simulator")
+			_ = server.Close()
+		}()
+
+		if err := server.ListenAndServe(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"goskydarks/session"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report capture progress recorded in the state file",
+	Long: `Reads the state file for each configured cooling temperature and reports, for each dark
+and bias set, how many frames are required, done, and still remaining.  Also shows the measured
+download time for each binning and an estimate of the time needed to finish.  The history
+command lists the individual frames.
+Use --coolto to report on just one temperature.
+TheSkyX is not contacted, so this is safe to use before leaving a run unattended.
+Exits with status 2 if no state file is configured, or 6 if a state file can't be read.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetBool(config.ShowSettingsSetting) {
+			config.ShowAllSettings()
+		}
+		if viper.GetString(config.StateFileSetting) == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "State file is required for status")
+			exitCode = exitConfigError
+			return
+		}
+		temperatures, err := stateTemperatures(cmd, statusCoolTo)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			exitCode = exitConfigError
+			return
+		}
+		for _, coolTo := range temperatures {
+			printTemperatureHeading(temperatures, coolTo)
+			if err := reportStatus(coolTo); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				exitCode = exitStateFileError
+			}
+		}
+	},
+}
+
+// reportStatus prints the progress recorded in the state file for one cooling temperature
+func reportStatus(coolTo float64) error {
+	stateFileService := session.NewStateStore(viper.GetString(config.StateFileSetting), coolTo)
+	plan, err := stateFileService.ReadStateFile()
+	if err != nil {
+		return err
+	}
+	if plan == nil {
+		fmt.Printf("No state file for cooling temperature %g - nothing has been captured yet\n", coolTo)
+		return nil
+	}
+	return printPlanStatus(plan)
+}
+
+// statusCoolTo selects the state file; it is not bound to viper, so it doesn't override capture's --coolto
+var statusCoolTo float64
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().Float64VarP(&statusCoolTo, "coolto", "t", 0.0, "Cooling temperature whose state file to report (default: from config)")
+}
+
+// printPlanStatus prints a table of set progress, the download times, and the estimated time to finish
+func printPlanStatus(plan *session.CapturePlan) error {
+	progress, err := session.PlanProgress(plan)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(writer, "Type\tExposure\tBinning\tRequired\tDone\tRemaining\t")
+	for _, set := range progress {
+		exposure := fmt.Sprintf("%.2f", set.Exposure)
+		if set.FrameType == "Bias" {
+			exposure = "-"
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%d\t%d\t\n",
+			set.FrameType, exposure, set.Binning, set.Count, set.Done, set.Remaining)
+	}
+	_ = writer.Flush()
+
+	fmt.Println("Download times")
+	binnings := make([]int, 0, len(plan.DownloadTimes))
+	for binning := range plan.DownloadTimes {
+		binnings = append(binnings, binning)
+	}
+	sort.Ints(binnings)
+	for _, binning := range binnings {
+		if plan.DownloadTimes[binning] > 0 {
+			fmt.Printf("   Binning %d: %.2f seconds\n", binning, plan.DownloadTimes[binning])
+		} else {
+			fmt.Printf("   Binning %d: not yet measured\n", binning)
+		}
+	}
+
+	seconds, unmeasured, err := session.EstimateRemainingSeconds(plan)
+	if err != nil {
+		return err
+	}
+	if seconds == 0 {
+		fmt.Println("All frames captured")
+		return nil
+	}
+	fmt.Printf("Estimated time to finish: %s\n", formatSeconds(seconds))
+	if len(unmeasured) > 0 {
+		fmt.Printf("   (not including download time for binning %v, not yet measured)\n", unmeasured)
+	}
+	return nil
+}
+
+// formatSeconds displays a number of seconds as hours, minutes and seconds
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+}
@@ -4,20 +4,39 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
 	"github.com/spf13/cobra"
 	"goskydarks/config"
+	"os"
 )
 
 // validateCmd represents the validate command
 var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate the config file",
-	Long:  `Validates the config file and displays all the settings.  No capture is performed.`,
-	Run:   RunValidateCommand,
+	Long: `Validates the config file and displays all the settings.  No capture is performed.
+Every problem found is listed, and the exit status is 2 if there are any, 0 if the settings are good.
+Use --profile to validate the settings as they are with one of the config file's profiles applied.`,
+	Run: RunValidateCommand,
 }
 
 func RunValidateCommand(_ *cobra.Command, _ []string) {
 	config.ShowAllSettings()
+	if err := config.Validate(); err != nil {
+		reportConfigProblems(err)
+		exitCode = exitConfigError
+		return
+	}
+	fmt.Println("Settings are valid")
+}
+
+// reportConfigProblems lists every problem config.Validate found
+func reportConfigProblems(err error) {
+	problems := config.Problems(err)
+	_, _ = fmt.Fprintf(os.Stderr, "Found %d problem(s) in the settings:\n", len(problems))
+	for _, problem := range problems {
+		_, _ = fmt.Fprintf(os.Stderr, "   %v\n", problem)
+	}
 }
 
 func init() {
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//	A frame set string may end with optional camera settings, for CMOS cameras whose calibration
+//	frames must match the lights on gain, offset and readout mode.  They are written as key=value
+//	parts after the usual numbers, e.g. "16,300,1,gain=100,offset=30,readout=High Gain Mode"
+
+// CameraSettings are the camera settings asked for by one frame set.  Settings not given are
+// left as they are on the camera.
+type CameraSettings struct {
+	Gain        *int   // nil if not specified
+	Offset      *int   // nil if not specified
+	ReadoutMode string // "" if not specified
+}
+
+// IsEmpty is true if the frame set doesn't ask for any camera settings
+func (c CameraSettings) IsEmpty() bool {
+	return c.Gain == nil && c.Offset == nil && c.ReadoutMode == ""
+}
+
+// String describes the settings for progress output, e.g. "gain 100, offset 30".  Equal
+// settings give equal strings.
+func (c CameraSettings) String() string {
+	var parts []string
+	if c.Gain != nil {
+		parts = append(parts, fmt.Sprintf("gain %d", *c.Gain))
+	}
+	if c.Offset != nil {
+		parts = append(parts, fmt.Sprintf("offset %d", *c.Offset))
+	}
+	if c.ReadoutMode != "" {
+		parts = append(parts, fmt.Sprintf("readout %q", c.ReadoutMode))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// KeySuffix is added to a set's state file key, so sets that differ only in camera settings
+// are counted separately.  It is empty if there are no settings, leaving older keys unchanged.
+func (c CameraSettings) KeySuffix() string {
+	suffix := ""
+	if c.Gain != nil {
+		suffix += fmt.Sprintf("_g%d", *c.Gain)
+	}
+	if c.Offset != nil {
+		suffix += fmt.Sprintf("_o%d", *c.Offset)
+	}
+	if c.ReadoutMode != "" {
+		suffix += "_r" + c.ReadoutMode
+	}
+	return suffix
+}
+
+// ParseCameraSettings returns the camera settings at the end of a frame set string, if any
+func ParseCameraSettings(frameSet string) (CameraSettings, error) {
+	_, settings, err := splitFrameSet(frameSet)
+	return settings, err
+}
+
+// cameraSettingsNote describes a frame set's camera settings for ShowAllSettings, e.g. " (gain 100)"
+func cameraSettingsNote(frameSet string) string {
+	settings, err := ParseCameraSettings(frameSet)
+	if err != nil || settings.IsEmpty() {
+		return ""
+	}
+	return " (" + settings.String() + ")"
+}
+
+// splitFrameSet separates a frame set string into its numeric parts and its camera settings
+func splitFrameSet(frameSet string) ([]string, CameraSettings, error) {
+	var settings CameraSettings
+	var parts []string
+	for _, part := range strings.Split(frameSet, ",") {
+		key, value, isSetting := strings.Cut(part, "=")
+		if !isSetting {
+			if !settings.IsEmpty() {
+				return nil, settings, errors.New("frame set camera settings must come after the numbers")
+			}
+			parts = append(parts, part)
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "gain", "offset":
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, settings, errors.New("error in frame set " + key + ": " + err.Error())
+			}
+			if number < 0 {
+				return nil, settings, errors.New("frame set " + key + " must be >= 0")
+			}
+			if key == "gain" {
+				settings.Gain = &number
+			} else {
+				settings.Offset = &number
+			}
+		case "readout":
+			if value == "" {
+				return nil, settings, errors.New("frame set readout mode must not be empty")
+			}
+			settings.ReadoutMode = value
+		default:
+			return nil, settings, fmt.Errorf("unknown frame set camera setting %q: expected gain, offset or readout", key)
+		}
+	}
+	return parts, settings, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCameraSettings(t *testing.T) {
+
+	t.Run("no settings", func(t *testing.T) {
+		settings, err := ParseCameraSettings("16,300,1")
+		require.Nil(t, err)
+		require.True(t, settings.IsEmpty())
+		require.Equal(t, "", settings.KeySuffix(), "Sets without settings keep their old keys")
+	})
+
+	t.Run("gain, offset and readout mode", func(t *testing.T) {
+		settings, err := ParseCameraSettings("16,300,1, Gain=100,offset = 30,readout=High Gain Mode")
+		require.Nil(t, err)
+		require.Equal(t, 100, *settings.Gain)
+		require.Equal(t, 30, *settings.Offset)
+		require.Equal(t, "High Gain Mode", settings.ReadoutMode)
+		require.Equal(t, `gain 100, offset 30, readout "High Gain Mode"`, settings.String())
+		require.Equal(t, "_g100_o30_rHigh Gain Mode", settings.KeySuffix())
+	})
+
+	t.Run("zero gain is a setting", func(t *testing.T) {
+		settings, err := ParseCameraSettings("16,1,gain=0")
+		require.Nil(t, err)
+		require.False(t, settings.IsEmpty())
+		require.Equal(t, "_g0", settings.KeySuffix())
+	})
+
+	t.Run("frame set parsers accept settings", func(t *testing.T) {
+		count, exposure, binning, err := ParseDarkSet("16,300,2,gain=100")
+		require.Nil(t, err)
+		require.Equal(t, 16, count)
+		require.Equal(t, 300.0, exposure)
+		require.Equal(t, 2, binning)
+		count, binning, err = ParseBiasSet("32,1,offset=10,readout=Low Noise")
+		require.Nil(t, err)
+		require.Equal(t, 32, count)
+		require.Equal(t, 1, binning)
+		_, _, _, err = ParseFlatDarkSet("20,0.5,1,gain=120")
+		require.Nil(t, err)
+	})
+
+	t.Run("fail on bad gain", func(t *testing.T) {
+		_, _, _, err := ParseDarkSet("16,300,1,gain=high")
+		require.ErrorContains(t, err, "error in frame set gain")
+		_, _, err = ParseBiasSet("16,1,offset=-5")
+		require.ErrorContains(t, err, "offset must be >= 0")
+	})
+
+	t.Run("fail on unknown setting", func(t *testing.T) {
+		_, _, _, err := ParseDarkSet("16,300,1,iso=800")
+		require.ErrorContains(t, err, "unknown frame set camera setting")
+	})
+
+	t.Run("fail on settings before the numbers", func(t *testing.T) {
+		_, _, _, err := ParseDarkSet("16,gain=100,300,1")
+		require.ErrorContains(t, err, "must come after the numbers")
+	})
+
+	t.Run("fail on empty readout mode", func(t *testing.T) {
+		_, err := ParseCameraSettings("16,1,readout=")
+		require.ErrorContains(t, err, "readout mode must not be empty")
+	})
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+// Orders in which the frame sets can be captured
+const (
+	CaptureOrderByType        = "bytype"        // All darks then all bias frames, or the reverse (DarkFirst/BiasFirst)
+	CaptureOrderRoundRobin    = "roundrobin"    // One frame from each set in turn, so a short night gets some of every set
+	CaptureOrderShortestFirst = "shortestfirst" // Sets with the shortest exposure first (bias frames count as shortest)
+	CaptureOrderLongestFirst  = "longestfirst"  // Sets with the longest exposure first
+	CaptureOrderByBinning     = "bybinning"     // Sets grouped by binning, lowest first, to minimize binning changes
+)
+
+// CaptureOrders lists the valid capture order settings
+var CaptureOrders = []string{
+	CaptureOrderByType,
+	CaptureOrderRoundRobin,
+	CaptureOrderShortestFirst,
+	CaptureOrderLongestFirst,
+	CaptureOrderByBinning,
+}
+
+// CaptureOrder returns the configured capture order, in lower case.  Empty means the original
+// by-type order.  An unknown order is an error.
+func CaptureOrder() (string, error) {
+	order := strings.ToLower(strings.TrimSpace(viper.GetString(CaptureOrderSetting)))
+	if order == "" {
+		return CaptureOrderByType, nil
+	}
+	for _, valid := range CaptureOrders {
+		if order == valid {
+			return order, nil
+		}
+	}
+	return "", fmt.Errorf("invalid capture order %q; must be one of %s", order, strings.Join(CaptureOrders, ", "))
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCaptureOrder(t *testing.T) {
+
+	t.Run("empty means by type", func(t *testing.T) {
+		viper.Set(CaptureOrderSetting, "")
+		order, err := CaptureOrder()
+		require.Nil(t, err)
+		require.Equal(t, CaptureOrderByType, order)
+	})
+
+	t.Run("case and spaces are ignored", func(t *testing.T) {
+		viper.Set(CaptureOrderSetting, " RoundRobin ")
+		order, err := CaptureOrder()
+		require.Nil(t, err)
+		require.Equal(t, CaptureOrderRoundRobin, order)
+	})
+
+	t.Run("unknown order is an error", func(t *testing.T) {
+		viper.Set(CaptureOrderSetting, "random")
+		_, err := CaptureOrder()
+		require.ErrorContains(t, err, "invalid capture order")
+	})
+	viper.Set(CaptureOrderSetting, "")
+}
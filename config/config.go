@@ -9,32 +9,48 @@ import (
 )
 
 type SettingsType struct {
-	Verbosity    int
-	Debug        bool
-	StateFile    string //	Path to state file
-	ShowSettings bool
-	Cooling      CoolingConfig
-	Start        StartConfig
-	Server       ServerConfig
-	BiasFrames   []string
-	DarkFrames   []string
-	ClearDone    bool // clear the "done" counts in the state file
-	NoDark       bool // No dark frames even if specified
-	NoBias       bool // No bias frames even if specified
-	DarkFirst    bool // Do dark frames first
-	BiasFirst    bool // Do bias frames first
+	Verbosity        int
+	Debug            bool
+	StateFile        string //	Path to state file
+	StateStore       string //	"file" for a JSON state file per temperature, or "sqlite" for one database
+	EventLog         string //	Path to JSON Lines event log, if wanted
+	LogFile          string //	Path to log file, if wanted
+	LogFormat        string //	"text" or "json"
+	LogFileVerbosity int    //	Verbosity of the log file, 0 to 5
+	ShowSettings     bool
+	Cooling          CoolingConfig
+	Start            StartConfig
+	Server           ServerConfig
+	Retry            RetryConfig
+	Simulator        SimulatorConfig
+	BiasFrames       []string
+	DarkFrames       []string
+	FlatDarks        []string               // Flat-dark sets, "count,exposure,binning"
+	ClearDone        bool                   // clear the "done" counts in the state file
+	NoDark           bool                   // No dark frames even if specified
+	NoBias           bool                   // No bias frames even if specified
+	NoFlatDark       bool                   // No flat-dark frames even if specified
+	DarkFirst        bool                   // Do dark frames first
+	BiasFirst        bool                   // Do bias frames first
+	FlatDarkFirst    bool                   // Do flat-dark frames before darks and bias (otherwise after)
+	CaptureOrder     string                 // Order to capture the frame sets in: bytype, roundrobin, shortestfirst, longestfirst, bybinning
+	Estimate         bool                   // Only estimate the time a capture would take
+	DryRun           bool                   // Go through the capture without contacting TheSkyX
+	Profile          string                 // Name of the profile, from Profiles, to use
+	Profiles         map[string]interface{} // Named sets of settings overriding the rest of the file
 }
 
 // CoolingConfig is configuration about use the cameras cooler
 type CoolingConfig struct {
-	UseCooler        bool    //	Camera has cooler and we'll use it
-	CoolTo           float64 //	Target temperature
-	CoolStartTol     float64 //	Target plus-or-minus this
-	StartPollSeconds int     //	How often to poll during cooling start
-	CoolWaitMinutes  int     //	How long to wait for target (minutes)
-	AbortOnCooling   bool    //	Abort collection if temp rises
-	CoolAbortTol     float64 //	Amount of temp rise before abort
-	OffAtEnd         bool    //	Turn off cooler at end of session
+	UseCooler        bool      //	Camera has cooler and we'll use it
+	CoolTo           float64   //	Target temperature
+	CoolToList       []float64 //	Several target temperatures, done in turn; overrides CoolTo
+	CoolStartTol     float64   //	Target plus-or-minus this
+	StartPollSeconds int       //	How often to poll during cooling start
+	CoolWaitMinutes  int       //	How long to wait for target (minutes)
+	AbortOnCooling   bool      //	Abort collection if temp rises
+	CoolAbortTol     float64   //	Amount of temp rise before abort
+	OffAtEnd         bool      //	Turn off cooler at end of session
 }
 
 // StartConfig is configuration about delayed start to the collection
@@ -50,14 +66,61 @@ type ServerConfig struct {
 	Port    int    // TCP port number
 }
 
+// RetryConfig is configuration about reconnecting when the TheSkyX server can't be reached
+type RetryConfig struct {
+	Attempts          int      // Tries of each server request before giving up; 1 means no retries
+	BackoffSeconds    int      // Wait before the first reconnect; doubled for each further attempt
+	MaxBackoffSeconds int      // Longest wait between reconnects
+	Transient         []string // Error text that marks an error as a dropped connection, worth retrying
+}
+
+// DefaultTransientErrors is the error text, as reported by the network, that usually means the
+// connection to the server dropped and is worth trying again
+var DefaultTransientErrors = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"no route to host",
+	"network is unreachable",
+	"EOF",
+}
+
+// SimulatorConfig is configuration for the built-in TheSkyX simulator
+type SimulatorConfig struct {
+	Port                     int     // TCP port to listen on
+	AmbientTemperature       float64 // Camera temperature with cooler off
+	CoolingTimeConstant      float64 // Seconds to cover 63% of a temperature change
+	MaxCoolingDelta          float64 // How far below ambient the cooler can reach
+	FullFrameDownloadSeconds float64 // Download time for an unbinned frame
+	TimeScale                float64 // Simulated seconds per real second
+}
+
+// Ways of keeping the capture state, for StateStore
+const (
+	StateStoreFile   = "file"   // A JSON state file for each cooling temperature
+	StateStoreSQLite = "sqlite" // One SQLite database for all temperatures, which can be queried
+)
+
+// UseSQLiteStateStore is true if the capture state is kept in a SQLite database
+func UseSQLiteStateStore() bool {
+	return strings.EqualFold(viper.GetString(StateStoreSetting), StateStoreSQLite)
+}
+
 // Keys to retrieve settings from viper
 
 const VerbositySetting = "verbosity"
 const DebugSetting = "debug"
 const StateFileSetting = "statefile"
+const StateStoreSetting = "statestore"
+const EventLogSetting = "eventlog"
+const LogFileSetting = "logfile"
+const LogFormatSetting = "logformat"
+const LogFileVerbositySetting = "logfileverbosity"
 const ShowSettingsSetting = "ShowSettings"
 const UseCoolerSetting = "Cooling.UseCooler"
 const CoolToSetting = "Cooling.CoolTo"
+const CoolToListSetting = "Cooling.CoolToList"
 const CoolStartTolSetting = "Cooling.CoolStartTol"
 const StartPollSecondsSetting = "Cooling.StartPollSeconds"
 const CoolWaitMinutesSetting = "Cooling.CoolWaitMinutes"
@@ -69,13 +132,31 @@ const StartDaySetting = "Start.Day"
 const StartTimeSetting = "Start.Time"
 const ServerAddressSetting = "Server.Address"
 const ServerPortSetting = "Server.Port"
+const RetryAttemptsSetting = "Retry.Attempts"
+const RetryBackoffSecondsSetting = "Retry.BackoffSeconds"
+const RetryMaxBackoffSecondsSetting = "Retry.MaxBackoffSeconds"
+const RetryTransientSetting = "Retry.Transient"
+const SimulatorPortSetting = "Simulator.Port"
+const SimulatorAmbientSetting = "Simulator.AmbientTemperature"
+const SimulatorCoolingTimeConstantSetting = "Simulator.CoolingTimeConstant"
+const SimulatorMaxCoolingDeltaSetting = "Simulator.MaxCoolingDelta"
+const SimulatorDownloadSecondsSetting = "Simulator.FullFrameDownloadSeconds"
+const SimulatorTimeScaleSetting = "Simulator.TimeScale"
 const BiasFramesSetting = "BiasFrames"
 const DarkFramesSetting = "DarkFrames"
+const FlatDarkFramesSetting = "FlatDarks"
+const ProfileSetting = "Profile"
+const ProfilesSetting = "Profiles"
 const NoBiasSetting = "NoBias"
 const NoDarkSetting = "NoDark"
+const NoFlatDarkSetting = "NoFlatDark"
 const ClearDoneSetting = "ClearDone"
 const DarkFirstSetting = "DarkFirst"
 const BiasFirstSetting = "BiasFirst"
+const FlatDarkFirstSetting = "FlatDarkFirst"
+const CaptureOrderSetting = "CaptureOrder"
+const EstimateSetting = "Estimate"
+const DryRunSetting = "DryRun"
 
 func ShowAllSettings() {
 	fmt.Println("Validating and displaying all config settings:")
@@ -83,9 +164,18 @@ func ShowAllSettings() {
 	//	Global settings
 	fmt.Println("Global settings")
 	fmt.Printf("   Show Settings: %t\n", viper.GetBool(ShowSettingsSetting))
+	if file := viper.ConfigFileUsed(); file != "" {
+		fmt.Printf("   Config File: %s\n", file)
+	}
+	if profile := viper.GetString(ProfileSetting); profile != "" {
+		fmt.Printf("   Profile: %s (of %s)\n", profile, ProfileNames())
+	}
 	fmt.Printf("   Verbosity: %d\n", viper.GetInt(VerbositySetting))
 	fmt.Printf("   Debug: %t\n", viper.GetBool(DebugSetting))
-	fmt.Printf("   State File Path: %s\n", viper.GetString(StateFileSetting))
+	fmt.Printf("   State File Path: %s (%s)\n", viper.GetString(StateFileSetting), viper.GetString(StateStoreSetting))
+	fmt.Printf("   Event Log Path: %s\n", viper.GetString(EventLogSetting))
+	fmt.Printf("   Log File Path: %s (%s, verbosity %d)\n", viper.GetString(LogFileSetting),
+		viper.GetString(LogFormatSetting), viper.GetInt(LogFileVerbositySetting))
 	fmt.Printf("   Clear \"done\" counts: %t\n", viper.GetBool(ClearDoneSetting))
 
 	//	Server settings
@@ -93,6 +183,13 @@ func ShowAllSettings() {
 	fmt.Printf("   Address: %s\n", viper.GetString(ServerAddressSetting))
 	fmt.Printf("   Port: %d\n", viper.GetInt(ServerPortSetting))
 
+	//	Retry settings
+	fmt.Println("Retry settings")
+	fmt.Printf("   Attempts: %d\n", viper.GetInt(RetryAttemptsSetting))
+	fmt.Printf("   Backoff: %d seconds, up to %d seconds\n", viper.GetInt(RetryBackoffSecondsSetting),
+		viper.GetInt(RetryMaxBackoffSecondsSetting))
+	fmt.Printf("   Transient errors: %q\n", viper.GetStringSlice(RetryTransientSetting))
+
 	//	Start time
 	fmt.Println("Delayed Start settings")
 	fmt.Printf("   Delay: %t\n", viper.GetBool(StartDelaySetting))
@@ -108,6 +205,11 @@ func ShowAllSettings() {
 	fmt.Println("Cooling settings")
 	fmt.Printf("   Use cooler: %t\n", viper.GetBool(UseCoolerSetting))
 	fmt.Printf("   Cool to: %g degrees\n", viper.GetFloat64(CoolToSetting))
+	if temperatures, err := ParseTemperatureList(viper.Get(CoolToListSetting)); err != nil {
+		fmt.Printf("   Error in cool to list: %s\n", err)
+	} else if len(temperatures) > 0 {
+		fmt.Printf("   Cool to each of: %v degrees\n", temperatures)
+	}
 	fmt.Printf("   Start tolerance: %g degrees\n", viper.GetFloat64(CoolStartTolSetting))
 	fmt.Printf("   Wait maximum: %d minutes\n", viper.GetInt(CoolWaitMinutesSetting))
 	fmt.Printf("   Abort if cooling outside tolerance: %t\n", viper.GetBool(AbortOnCoolingSetting))
@@ -116,12 +218,12 @@ func ShowAllSettings() {
 
 	//	Bias Frames
 	fmt.Println("Bias Frames")
-	for _, frameSetString := range viper.GetStringSlice(BiasFramesSetting) {
+	for _, frameSetString := range showFrameSets(BiasFramesSetting) {
 		count, binning, err := ParseBiasSet(frameSetString)
 		if err != nil {
 			fmt.Println("   Syntax error in set:", frameSetString)
 		} else {
-			fmt.Printf("   %d bias frames at %d x %d binning\n", count, binning, binning)
+			fmt.Printf("   %d bias frames at %d x %d binning%s\n", count, binning, binning, cameraSettingsNote(frameSetString))
 		}
 	}
 	fmt.Printf("   Skip bias frames: %t\n", viper.GetBool(NoBiasSetting))
@@ -129,26 +231,51 @@ func ShowAllSettings() {
 
 	//	Dark Frames
 	fmt.Println("Dark Frames")
-	for _, frameSetString := range viper.GetStringSlice(DarkFramesSetting) {
+	for _, frameSetString := range showFrameSets(DarkFramesSetting) {
 		count, exposure, binning, err := ParseDarkSet(frameSetString)
 		if err != nil {
 			fmt.Println("   Syntax error in set:", frameSetString)
 		} else {
-			fmt.Printf("   %d dark frames of %.2f seconds at %d x %d binning\n", count, exposure, binning, binning)
+			fmt.Printf("   %d dark frames of %.2f seconds at %d x %d binning%s\n", count, exposure, binning, binning,
+				cameraSettingsNote(frameSetString))
 		}
 	}
 	fmt.Printf("   Skip dark frames: %t\n", viper.GetBool(NoDarkSetting))
 	fmt.Printf("   Do dark frames first: %t\n", viper.GetBool(DarkFirstSetting))
+
+	//	Flat-Dark Frames
+	fmt.Println("Flat-Dark Frames")
+	for _, frameSetString := range showFrameSets(FlatDarkFramesSetting) {
+		count, exposure, binning, err := ParseFlatDarkSet(frameSetString)
+		if err != nil {
+			fmt.Println("   Syntax error in set:", frameSetString)
+		} else {
+			fmt.Printf("   %d flat-dark frames of %.2f seconds at %d x %d binning%s\n", count, exposure, binning, binning,
+				cameraSettingsNote(frameSetString))
+		}
+	}
+	fmt.Printf("   Skip flat-dark frames: %t\n", viper.GetBool(NoFlatDarkSetting))
+	fmt.Printf("   Do flat-dark frames first: %t\n", viper.GetBool(FlatDarkFirstSetting))
+	if order, err := CaptureOrder(); err != nil {
+		fmt.Printf("   Error in capture order: %s\n", err)
+	} else {
+		fmt.Printf("   Capture order: %s\n", order)
+	}
 }
 
 // ValidateGlobals validates any global settings
 func ValidateGlobals() error {
 	//	Verbosity must be between 0 and 5
+	var problems []error
 	verbosity := viper.GetInt(VerbositySetting)
 	if verbosity < 0 || verbosity > 5 {
-		return errors.New(fmt.Sprintf("invalid verbosity level (%d); must be between 0 and 5", verbosity))
+		problems = append(problems, errors.New(fmt.Sprintf("invalid verbosity level (%d); must be between 0 and 5", verbosity)))
+	}
+	logFileVerbosity := viper.GetInt(LogFileVerbositySetting)
+	if logFileVerbosity < 0 || logFileVerbosity > 5 {
+		problems = append(problems, errors.New(fmt.Sprintf("invalid log file verbosity level (%d); must be between 0 and 5", logFileVerbosity)))
 	}
-	return nil
+	return errors.Join(problems...)
 }
 
 //	ParseStart parses the string start time settings received from the
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//	Without --config, the config file is looked for in the current directory and then in the usual
+//	places for a user's or the system's config, so goskydarks can be run from cron or systemd.
+//	Settings can also be given in the environment, e.g. GOSKYDARKS_VERBOSITY=4 or
+//	GOSKYDARKS_COOLING_COOLTO=-15, overriding the config file but not the command line.
+
+// ConfigFileName is the name of the config file looked for in the search paths, without its extension
+const ConfigFileName = "config"
+
+// ConfigDirName is the directory under the user and system config directories holding the config file
+const ConfigDirName = "goskydarks"
+
+// EnvPrefix starts the names of environment variables that give settings
+const EnvPrefix = "GOSKYDARKS"
+
+// ConfigFileEnv names the environment variable that may give the config file path instead of --config
+const ConfigFileEnv = EnvPrefix + "_CONFIG"
+
+// EnvKeyReplacer turns a nested setting name like cooling.coolto into its environment variable suffix
+var EnvKeyReplacer = strings.NewReplacer(".", "_")
+
+// ConfigSearchPaths returns the directories searched for the config file, in order
+func ConfigSearchPaths() []string {
+	paths := []string{"."}
+	if xdgHome := os.Getenv("XDG_CONFIG_HOME"); xdgHome != "" {
+		paths = append(paths, filepath.Join(xdgHome, ConfigDirName))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		userPath := filepath.Join(home, ".config", ConfigDirName)
+		if paths[len(paths)-1] != userPath {
+			paths = append(paths, userPath)
+		}
+	}
+	return append(paths, filepath.Join("/etc", ConfigDirName))
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestConfigSearchPaths(t *testing.T) {
+
+	t.Run("current directory, then XDG, user and system config", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", "/xdg")
+		t.Setenv("HOME", "/home/observer")
+		require.Equal(t, []string{".", "/xdg/goskydarks", "/home/observer/.config/goskydarks", "/etc/goskydarks"},
+			ConfigSearchPaths())
+	})
+
+	t.Run("XDG default isn't searched twice", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", "/home/observer/.config")
+		t.Setenv("HOME", "/home/observer")
+		require.Equal(t, []string{".", "/home/observer/.config/goskydarks", "/etc/goskydarks"}, ConfigSearchPaths())
+	})
+
+	t.Run("no XDG_CONFIG_HOME", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", "")
+		t.Setenv("HOME", "/home/observer")
+		require.Equal(t, []string{".", "/home/observer/.config/goskydarks", "/etc/goskydarks"}, ConfigSearchPaths())
+	})
+
+	t.Run("environment variable names for nested settings", func(t *testing.T) {
+		require.Equal(t, "COOLING_COOLTO", EnvKeyReplacer.Replace("COOLING.COOLTO"))
+	})
+}
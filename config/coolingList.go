@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+)
+
+// CoolingTemperatures returns the cooler set points a capture should step through, in order.
+// This is the CoolToList setting if it is given, otherwise just the single CoolTo setting.
+func CoolingTemperatures() ([]float64, error) {
+	temperatures, err := ParseTemperatureList(viper.Get(CoolToListSetting))
+	if err != nil {
+		return nil, err
+	}
+	if len(temperatures) == 0 {
+		return []float64{viper.GetFloat64(CoolToSetting)}, nil
+	}
+	return temperatures, nil
+}
+
+// ParseTemperatureList converts a list of temperatures, as it may arrive from the config file
+// (a YAML list) or the command line (a comma-separated string, possibly in brackets), to numbers.
+// Repeated temperatures are an error, since they would share a state file.
+func ParseTemperatureList(value interface{}) ([]float64, error) {
+	var items []string
+	switch list := value.(type) {
+	case nil:
+		return nil, nil
+	case []float64:
+		return checkDuplicateTemperatures(list)
+	case []interface{}:
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+	case []string:
+		items = list
+	case string:
+		trimmed := strings.Trim(strings.TrimSpace(list), "[]")
+		if trimmed != "" {
+			items = strings.Split(trimmed, ",")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid cooling temperature list: %v", value))
+	}
+	temperatures := make([]float64, 0, len(items))
+	for _, item := range items {
+		temperature, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid temperature %q in cooling temperature list", item))
+		}
+		temperatures = append(temperatures, temperature)
+	}
+	return checkDuplicateTemperatures(temperatures)
+}
+
+func checkDuplicateTemperatures(temperatures []float64) ([]float64, error) {
+	seen := make(map[float64]bool)
+	for _, temperature := range temperatures {
+		if seen[temperature] {
+			return nil, errors.New(fmt.Sprintf("temperature %g appears more than once in cooling temperature list", temperature))
+		}
+		seen[temperature] = true
+	}
+	return temperatures, nil
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseTemperatureList(t *testing.T) {
+
+	t.Run("list from config file", func(t *testing.T) {
+		temperatures, err := ParseTemperatureList([]interface{}{-5, -10.5, "-15"})
+		require.Nil(t, err)
+		require.Equal(t, []float64{-5, -10.5, -15}, temperatures)
+	})
+
+	t.Run("list from command line flag", func(t *testing.T) {
+		temperatures, err := ParseTemperatureList("[-5.000000,-10.000000]")
+		require.Nil(t, err)
+		require.Equal(t, []float64{-5, -10}, temperatures)
+	})
+
+	t.Run("empty list", func(t *testing.T) {
+		temperatures, err := ParseTemperatureList("[]")
+		require.Nil(t, err)
+		require.Empty(t, temperatures)
+	})
+
+	t.Run("junk temperature", func(t *testing.T) {
+		_, err := ParseTemperatureList([]interface{}{-5, "cold"})
+		require.NotNil(t, err)
+	})
+
+	t.Run("repeated temperature", func(t *testing.T) {
+		_, err := ParseTemperatureList([]float64{-5, -10, -5})
+		require.NotNil(t, err)
+	})
+}
+
+func TestCoolingTemperatures(t *testing.T) {
+
+	t.Run("single temperature when no list given", func(t *testing.T) {
+		viper.Set(CoolToListSetting, nil)
+		viper.Set(CoolToSetting, -12.0)
+		temperatures, err := CoolingTemperatures()
+		require.Nil(t, err)
+		require.Equal(t, []float64{-12}, temperatures)
+	})
+
+	t.Run("list overrides single temperature", func(t *testing.T) {
+		viper.Set(CoolToListSetting, []interface{}{-5, -10})
+		viper.Set(CoolToSetting, -12.0)
+		temperatures, err := CoolingTemperatures()
+		require.Nil(t, err)
+		require.Equal(t, []float64{-5, -10}, temperatures)
+		viper.Set(CoolToListSetting, nil)
+	})
+}
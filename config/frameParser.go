@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"github.com/spf13/cobra"
 	"strconv"
 	"strings"
@@ -11,8 +12,16 @@ import (
 // a    number of exposures.  An integer > 0
 // b    exposure time, a float > 0
 // c    binning, an integer > 0 (surprising if it wasn't small, like from 1 to 4)
+// Optional camera settings may follow - see ParseCameraSettings.  A series of sets (see ExpandDarkSet)
+// must be expanded first.
 func ParseDarkSet(darkSet string) (int, float64, int, error) {
-	parts := strings.Split(darkSet, ",")
+	if IsSetSeries(darkSet) {
+		return 0, 0.0, 0, errors.New("dark set " + darkSet + " is a series of sets, to be expanded with ExpandDarkSet")
+	}
+	parts, _, err := splitFrameSet(darkSet)
+	if err != nil {
+		return 0, 0.0, 0, err
+	}
 	if len(parts) != 3 {
 		return 0, 0.0, 0, errors.New("dark set must have 3 parts: count,exposure,time")
 	}
@@ -46,11 +55,59 @@ func ParseDarkSet(darkSet string) (int, float64, int, error) {
 	return count, exposure, binning, nil
 }
 
+// Parse a flat-dark set string, "count,exposure,binning", into 3 numbers.  A flat-dark is a dark
+// frame with the short exposure used for a flat field, so the parts are as for a dark set.
+// a    number of exposures.  An integer > 0
+// b    exposure time, a float > 0, matching one of the flat exposure times
+// c    binning, an integer > 0
+// Optional camera settings may follow, as for a dark set.
+func ParseFlatDarkSet(flatDarkSet string) (int, float64, int, error) {
+	if IsSetSeries(flatDarkSet) {
+		return 0, 0.0, 0, errors.New("flat-dark set " + flatDarkSet + " is a series of sets, to be expanded with ExpandDarkSet")
+	}
+	parts, _, err := splitFrameSet(flatDarkSet)
+	if err != nil {
+		return 0, 0.0, 0, err
+	}
+	if len(parts) != 3 {
+		return 0, 0.0, 0, errors.New("flat-dark set must have 3 parts: count,exposure,binning")
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0.0, 0, errors.New("error in flat-dark set count: " + err.Error())
+	}
+	if count < 1 {
+		return 0, 0.0, 0, errors.New("flat-dark set count must be > 0")
+	}
+
+	exposure, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0.0, 0, errors.New("error in flat-dark set exposure time: " + err.Error())
+	}
+	if exposure <= 0 {
+		return 0, 0.0, 0, errors.New("flat-dark set exposure time must be > 0")
+	}
+
+	binning, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return 0, 0.0, 0, errors.New("error in flat-dark set binning: " + err.Error())
+	}
+	if binning < 1 {
+		return 0, 0.0, 0, errors.New("flat-dark set binning must be > 0")
+	}
+
+	return count, exposure, binning, nil
+}
+
 //		Parse string in the form a,b into 2 numbers.
 //		a    number of exposures.  An integer > 0
 //	    b    binning, an integer > 0 (surprising if it wasn't small, like from 1 to 4)
+//		Optional camera settings may follow, as for a dark set.
 func ParseBiasSet(darkSet string) (int, int, error) {
-	parts := strings.Split(darkSet, ",")
+	parts, _, err := splitFrameSet(darkSet)
+	if err != nil {
+		return 0, 0, err
+	}
 	if len(parts) != 2 {
 		return 0, 0, errors.New("Bias set must have 2 parts: count,time")
 	}
@@ -76,6 +133,16 @@ func ParseBiasSet(darkSet string) (int, int, error) {
 	return count, binning, nil
 }
 
+// FrameKey identifies, in the state file, the frames of a set of the given type ("Dark", "FlatDark"
+// or "Bias") captured at a cooling temperature.  Sets with the same key take the same kind of frame,
+// so are counted together.  Bias frames have no exposure.
+func FrameKey(frameType string, exposure float64, binning int, temperature float64, camera CameraSettings) string {
+	if frameType == "Bias" {
+		return fmt.Sprintf("Bias_%d_%.2fC", binning, temperature) + camera.KeySuffix()
+	}
+	return fmt.Sprintf("%s_%.4f_%d_%.2fC", frameType, exposure, binning, temperature) + camera.KeySuffix()
+}
+
 // Determine if the named flag was explicitly set in the command line
 func FlagExplicitlySet(cmd *cobra.Command, flagName string) bool {
 	lookup := cmd.Flags().Lookup(flagName)
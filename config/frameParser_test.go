@@ -111,3 +111,29 @@ func TestDarkStringParser(t *testing.T) {
 	})
 
 }
+
+func TestFlatDarkStringParser(t *testing.T) {
+
+	t.Run("parse valid string", func(t *testing.T) {
+		count, exposure, binning, err := ParseFlatDarkSet("20, 0.5, 1")
+		require.Nil(t, err, "Valid string should not return an error")
+		require.Equal(t, 20, count, "Count should be 20")
+		require.Equal(t, 0.5, exposure, "Exposure should be 0.5")
+		require.Equal(t, 1, binning, "Binning should be 1")
+	})
+
+	t.Run("fail on wrong number of tokens", func(t *testing.T) {
+		_, _, _, err := ParseFlatDarkSet("20,1")
+		require.ErrorContains(t, err, "flat-dark set must have 3 parts")
+	})
+
+	t.Run("fail on exposure out of range", func(t *testing.T) {
+		_, _, _, err := ParseFlatDarkSet("20,0,1")
+		require.ErrorContains(t, err, "exposure time must be > 0")
+	})
+
+	t.Run("fail on garbage binning", func(t *testing.T) {
+		_, _, _, err := ParseFlatDarkSet("20,0.5,x")
+		require.ErrorContains(t, err, "invalid syntax")
+	})
+}
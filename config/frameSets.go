@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//	In the config file, a frame set may be written either as a string, "20,300,1,gain=100", or as a
+//	mapping with named fields, {count: 20, exposure: 300, binning: 1, gain: 100}.  Mappings are turned
+//	into the string form as they are read, so the rest of the program only deals with strings.
+//	Dark and flat-dark series (see ExpandDarkSet) are expanded here too.  In a mapping, a series
+//	is written as an exposure list or range, exposure: [60, 120, 300] or exposure: 30..600x2, and
+//	a binning list, binning: [1, 2].
+
+// frameSetFields are the fields a frame set mapping may have, in the order they appear in the string form
+var frameSetFields = []string{"count", "exposure", "binning", "gain", "offset", "readout"}
+
+// frameTypes gives the type of frame in each frame set list setting
+var frameTypes = map[string]string{
+	BiasFramesSetting:     "Bias",
+	DarkFramesSetting:     "Dark",
+	FlatDarkFramesSetting: "FlatDark",
+}
+
+// FrameSets returns the frame sets in a frame list setting (BiasFramesSetting, DarkFramesSetting
+// or FlatDarkFramesSetting) as ordinary set strings.  Mappings are checked and converted, and
+// series expanded; errors name the list, the position in it, and the field that is wrong.
+func FrameSets(setting string) ([]string, error) {
+	var items []interface{}
+	switch value := viper.Get(setting).(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		for _, item := range value {
+			items = append(items, item)
+		}
+	case string:
+		items = []interface{}{value}
+	case []interface{}:
+		items = value
+	default:
+		return nil, fmt.Errorf("%s must be a list of frame sets, not %v", strings.ToLower(setting), value)
+	}
+
+	sets := make([]string, 0, len(items))
+	for i, item := range items {
+		var set string
+		switch item := item.(type) {
+		case string:
+			set = item
+		case map[string]interface{}:
+			var err error
+			if set, err = frameSetFromMap(frameTypes[setting], item); err != nil {
+				return nil, fmt.Errorf("%s item %d: %w", strings.ToLower(setting), i+1, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s item %d: must be a string or a mapping, not %v", strings.ToLower(setting), i+1, item)
+		}
+		if setting == BiasFramesSetting {
+			sets = append(sets, set)
+			continue
+		}
+		expanded, err := ExpandDarkSet(set)
+		if err != nil {
+			return nil, fmt.Errorf("%s item %d: %w", strings.ToLower(setting), i+1, err)
+		}
+		sets = append(sets, expanded...)
+	}
+	return sets, nil
+}
+
+// showFrameSets returns the frame sets in a list for ShowAllSettings, reporting the list's error if it has one
+func showFrameSets(setting string) []string {
+	sets, err := FrameSets(setting)
+	if err != nil {
+		fmt.Println("   Error in frame sets:", err)
+	}
+	return sets
+}
+
+// FrameSetDecodeHook converts frame set mappings to strings when the config file is unmarshalled
+// into a Config.  The frame type isn't known there, so only the checks common to all types are made;
+// FrameSets makes the rest.
+func FrameSetDecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	fields, ok := data.(map[string]interface{})
+	if !ok || from.Kind() != reflect.Map || to.Kind() != reflect.String {
+		return data, nil
+	}
+	return frameSetFromMap("", fields)
+}
+
+// frameSetFromMap checks a frame set mapping and returns it in the string form.  A frameType of ""
+// accepts an exposure field but doesn't require one.
+func frameSetFromMap(frameType string, fields map[string]interface{}) (string, error) {
+	for name := range fields {
+		if !slices.Contains(frameSetFields, strings.ToLower(name)) {
+			return "", fmt.Errorf("unknown field %q: expected %s", name, strings.Join(frameSetFields, ", "))
+		}
+	}
+	value := func(name string) (interface{}, bool) {
+		for key, fieldValue := range fields {
+			if strings.EqualFold(key, name) {
+				return fieldValue, true
+			}
+		}
+		return nil, false
+	}
+
+	var parts []string
+	count, err := intField(value, "count", 1)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, strconv.Itoa(count))
+
+	exposureValue, hasExposure := value("exposure")
+	switch {
+	case frameType == "Bias" && hasExposure:
+		return "", fmt.Errorf("field \"exposure\": bias frames don't have an exposure")
+	case frameType != "Bias" && (hasExposure || frameType != ""):
+		exposure, err := exposureField(exposureValue, hasExposure)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, exposure)
+	}
+
+	binning, err := binningField(value, frameType)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, binning)
+
+	for _, name := range []string{"gain", "offset"} {
+		if _, present := value(name); present {
+			number, err := intField(value, name, 0)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("%s=%d", name, number))
+		}
+	}
+	if readoutValue, present := value("readout"); present {
+		readout, isString := readoutValue.(string)
+		readout = strings.TrimSpace(readout)
+		if !isString || readout == "" || strings.ContainsAny(readout, ",=") {
+			return "", fieldError("readout", "a readout mode name, without commas or equals signs", readoutValue, true)
+		}
+		parts = append(parts, "readout="+readout)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// exposureField gets the exposure of a mapping as it is written in a set string.  Besides a number,
+// it may be a list of numbers or a range string, making the set a series.
+func exposureField(exposureValue interface{}, present bool) (string, error) {
+	const expected = "a number of seconds > 0, a list of them, or a range like 30..600x2"
+	switch exposures := exposureValue.(type) {
+	case string:
+		if IsSetSeries(exposures) {
+			if _, err := expandExposures(strings.TrimSpace(exposures)); err != nil {
+				return "", fmt.Errorf("field \"exposure\": %w", err)
+			}
+			return strings.TrimSpace(exposures), nil
+		}
+	case []interface{}:
+		var list []string
+		for _, item := range exposures {
+			exposure, err := numberValue(item, true)
+			if err != nil || exposure <= 0 {
+				return "", fieldError("exposure", expected, exposureValue, true)
+			}
+			list = append(list, strconv.FormatFloat(exposure, 'f', -1, 64))
+		}
+		if len(list) == 0 {
+			return "", fieldError("exposure", expected, exposureValue, true)
+		}
+		return "[" + strings.Join(list, ",") + "]", nil
+	}
+	exposure, err := numberValue(exposureValue, present)
+	if err != nil || exposure <= 0 {
+		return "", fieldError("exposure", expected, exposureValue, present)
+	}
+	return strconv.FormatFloat(exposure, 'f', -1, 64), nil
+}
+
+// binningField gets the binning of a mapping as it is written in a set string.  For a dark or
+// flat-dark set, it may be a list of binnings, making the set a series.
+func binningField(value func(string) (interface{}, bool), frameType string) (string, error) {
+	binningValue, _ := value("binning")
+	binnings, isList := binningValue.([]interface{})
+	if !isList {
+		binning, err := intField(value, "binning", 1)
+		return strconv.Itoa(binning), err
+	}
+	const expected = "a whole number >= 1, or a list of them"
+	if frameType == "Bias" || len(binnings) == 0 {
+		return "", fieldError("binning", "a whole number >= 1", binningValue, true)
+	}
+	var list []string
+	for _, item := range binnings {
+		binning, err := numberValue(item, true)
+		if err != nil || binning != math.Trunc(binning) || binning < 1 {
+			return "", fieldError("binning", expected, binningValue, true)
+		}
+		list = append(list, strconv.Itoa(int(binning)))
+	}
+	return strings.Join(list, "|"), nil
+}
+
+// intField gets a whole number field that must be at least the given minimum
+func intField(value func(string) (interface{}, bool), name string, minimum int) (int, error) {
+	fieldValue, present := value(name)
+	number, err := numberValue(fieldValue, present)
+	if err != nil || number != math.Trunc(number) || number < float64(minimum) {
+		return 0, fieldError(name, fmt.Sprintf("a whole number >= %d", minimum), fieldValue, present)
+	}
+	return int(number), nil
+}
+
+// numberValue accepts a YAML number, or a string holding one
+func numberValue(fieldValue interface{}, present bool) (float64, error) {
+	if !present {
+		return 0, fmt.Errorf("missing")
+	}
+	switch number := fieldValue.(type) {
+	case int:
+		return float64(number), nil
+	case int64:
+		return float64(number), nil
+	case uint64:
+		return float64(number), nil
+	case float64:
+		return number, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(number), 64)
+	default:
+		return 0, fmt.Errorf("not a number")
+	}
+}
+
+func fieldError(name string, expected string, fieldValue interface{}, present bool) error {
+	if !present {
+		return fmt.Errorf("missing field %q, which must be %s", name, expected)
+	}
+	return fmt.Errorf("field %q must be %s, not %v", name, expected, fieldValue)
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFrameSets(t *testing.T) {
+
+	//	readFrameSets reads a config file holding the given YAML, then returns the sets in the given list
+	readFrameSets := func(t *testing.T, yaml string, setting string) ([]string, error) {
+		viper.Reset()
+		viper.SetConfigType("yml")
+		require.Nil(t, viper.ReadConfig(bytes.NewBufferString(yaml)))
+		return FrameSets(setting)
+	}
+	t.Cleanup(viper.Reset)
+
+	t.Run("strings are kept as they are", func(t *testing.T) {
+		sets, err := readFrameSets(t, "darkframes:\n  - \"20,300,1\"\n  - \"10, 60, 2\"\n", DarkFramesSetting)
+		require.Nil(t, err)
+		require.Equal(t, []string{"20,300,1", "10, 60, 2"}, sets)
+	})
+
+	t.Run("mappings become strings", func(t *testing.T) {
+		yaml := "darkframes:\n" +
+			"  - {count: 20, exposure: 300, binning: 1, gain: 100}\n" +
+			"  - count: 5\n    exposure: 0.5\n    binning: 2\n    offset: 30\n    readout: High Gain Mode\n" +
+			"  - \"3,10,1\"\n"
+		sets, err := readFrameSets(t, yaml, DarkFramesSetting)
+		require.Nil(t, err)
+		require.Equal(t, []string{"20,300,1,gain=100", "5,0.5,2,offset=30,readout=High Gain Mode", "3,10,1"}, sets)
+		count, exposure, binning, err := ParseDarkSet(sets[1])
+		require.Nil(t, err)
+		require.Equal(t, 5, count)
+		require.Equal(t, 0.5, exposure)
+		require.Equal(t, 2, binning)
+	})
+
+	t.Run("bias mappings have no exposure", func(t *testing.T) {
+		sets, err := readFrameSets(t, "biasframes:\n  - {count: 32, binning: 1}\n", BiasFramesSetting)
+		require.Nil(t, err)
+		require.Equal(t, []string{"32,1"}, sets)
+
+		_, err = readFrameSets(t, "biasframes:\n  - {count: 32, exposure: 1, binning: 1}\n", BiasFramesSetting)
+		require.ErrorContains(t, err, `biasframes item 1: field "exposure": bias frames don't have an exposure`)
+	})
+
+	t.Run("errors name the list, item and field", func(t *testing.T) {
+		_, err := readFrameSets(t, "darkframes:\n  - {count: 20, exposure: 300, binning: 1}\n  - {count: 20, binning: 1}\n",
+			DarkFramesSetting)
+		require.ErrorContains(t, err, `darkframes item 2: missing field "exposure"`)
+
+		_, err = readFrameSets(t, "darkframes:\n  - {count: 0, exposure: 300, binning: 1}\n", DarkFramesSetting)
+		require.ErrorContains(t, err, `field "count" must be a whole number >= 1, not 0`)
+
+		_, err = readFrameSets(t, "darkframes:\n  - {count: 20, exposure: long, binning: 1}\n", DarkFramesSetting)
+		require.ErrorContains(t, err, `field "exposure" must be a number of seconds > 0, a list of them, or a range like 30..600x2, not long`)
+
+		_, err = readFrameSets(t, "flatdarks:\n  - {count: 20, exposure: 0.5, binning: 1.5}\n", FlatDarkFramesSetting)
+		require.ErrorContains(t, err, `flatdarks item 1: field "binning" must be a whole number >= 1`)
+
+		_, err = readFrameSets(t, "darkframes:\n  - {count: 20, exposure: 300, binning: 1, gian: 100}\n", DarkFramesSetting)
+		require.ErrorContains(t, err, `unknown field "gian"`)
+
+		_, err = readFrameSets(t, "darkframes:\n  - {count: 20, exposure: 300, binning: 1, readout: \"a,b\"}\n", DarkFramesSetting)
+		require.ErrorContains(t, err, `field "readout" must be a readout mode name, without commas`)
+	})
+
+	t.Run("flag values are strings", func(t *testing.T) {
+		viper.Reset()
+		viper.Set(DarkFramesSetting, []string{"1,6,1"})
+		sets, err := FrameSets(DarkFramesSetting)
+		require.Nil(t, err)
+		require.Equal(t, []string{"1,6,1"}, sets)
+	})
+
+	t.Run("decode hook unmarshals mappings as strings", func(t *testing.T) {
+		_, err := readFrameSets(t, "darkframes:\n  - {count: 20, exposure: 300, binning: 1}\nbiasframes:\n  - {count: 32, binning: 2}\n",
+			DarkFramesSetting)
+		require.Nil(t, err)
+		var settings SettingsType
+		require.Nil(t, viper.Unmarshal(&settings, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(FrameSetDecodeHook))))
+		require.Equal(t, []string{"20,300,1"}, settings.DarkFrames)
+		require.Equal(t, []string{"32,2"}, settings.BiasFrames)
+	})
+}
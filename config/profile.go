@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"maps"
+	"slices"
+	"strings"
+)
+
+//	The config file may hold named profiles, e.g. for different cameras or seasons.  A profile is a
+//	section under "profiles" with the same layout as the rest of the file; the settings it gives
+//	override the ones in the base of the file, and the rest are kept.  A profile may extend another
+//	profile, naming it in "extends", to start from that profile's settings instead of the base.
+//
+//		profiles:
+//		  winter:
+//		    cooling:
+//		      coolTo: -20
+//		  qhy268-bin2:
+//		    extends: winter
+//		    darkframes: ["20,300,2"]
+
+// extendsKey is the profile setting naming the profile it extends
+const extendsKey = "extends"
+
+// ApplyProfile merges the named profile, and any profiles it extends, over the settings read from
+// the config file.  Command line flags still take precedence over the profile.
+func ApplyProfile(name string) error {
+	profiles := viper.GetStringMap(ProfilesSetting)
+	var chain []map[string]interface{}
+	var names []string
+	for profileName := strings.ToLower(name); profileName != ""; {
+		if slices.Contains(names, profileName) {
+			return fmt.Errorf("profile %q extends itself, through %s", name, strings.Join(append(names, profileName), " -> "))
+		}
+		profile, ok := profiles[profileName].(map[string]interface{})
+		if !ok {
+			if _, exists := profiles[profileName]; exists {
+				return fmt.Errorf("profile %q must be a section of settings", profileName)
+			}
+			return fmt.Errorf("unknown profile %q; the config file has: %s", profileName, ProfileNames())
+		}
+		names = append(names, profileName)
+		chain = append(chain, profile)
+		extends, _ := profile[extendsKey].(string)
+		profileName = strings.ToLower(strings.TrimSpace(extends))
+	}
+
+	//	Merge the base-most profile first, so each profile overrides the one it extends
+	for i := len(chain) - 1; i >= 0; i-- {
+		settings := maps.Clone(chain[i])
+		delete(settings, extendsKey)
+		if err := viper.MergeConfigMap(settings); err != nil {
+			return fmt.Errorf("applying profile %q: %w", names[i], err)
+		}
+	}
+	return nil
+}
+
+// ProfileNames lists the profiles in the config file, for messages
+func ProfileNames() string {
+	var names []string
+	for name := range viper.GetStringMap(ProfilesSetting) {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if len(names) == 0 {
+		return "(no profiles)"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"bytes"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+
+	const yaml = "stateFile: ./stateFile\n" +
+		"cooling:\n  coolTo: -10\n  coolStartTol: 2\n" +
+		"darkframes:\n  - \"20,300,1\"\n" +
+		"profiles:\n" +
+		"  winter:\n    cooling:\n      coolTo: -20\n" +
+		"  Camera2:\n    extends: winter\n    stateFile: ./stateFile2\n    darkframes:\n      - \"10,60,2\"\n" +
+		"  loop1:\n    extends: loop2\n" +
+		"  loop2:\n    extends: loop1\n" +
+		"  notsection: 5\n"
+
+	//	readConfig reads a config file holding the test YAML
+	readConfig := func(t *testing.T) {
+		viper.Reset()
+		viper.SetConfigType("yml")
+		require.Nil(t, viper.ReadConfig(bytes.NewBufferString(yaml)))
+	}
+	t.Cleanup(viper.Reset)
+
+	t.Run("profile overrides the base and keeps the rest", func(t *testing.T) {
+		readConfig(t)
+		require.Nil(t, ApplyProfile("winter"))
+		require.Equal(t, -20.0, viper.GetFloat64(CoolToSetting))
+		require.Equal(t, 2.0, viper.GetFloat64(CoolStartTolSetting))
+		require.Equal(t, "./stateFile", viper.GetString(StateFileSetting))
+	})
+
+	t.Run("profile extends another, case insensitively", func(t *testing.T) {
+		readConfig(t)
+		require.Nil(t, ApplyProfile("camera2"))
+		require.Equal(t, -20.0, viper.GetFloat64(CoolToSetting))
+		require.Equal(t, "./stateFile2", viper.GetString(StateFileSetting))
+		sets, err := FrameSets(DarkFramesSetting)
+		require.Nil(t, err)
+		require.Equal(t, []string{"10,60,2"}, sets)
+	})
+
+	t.Run("flags still take precedence", func(t *testing.T) {
+		readConfig(t)
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.String("stateFile", "", "")
+		require.Nil(t, flags.Parse([]string{"--stateFile=./fromFlag"}))
+		require.Nil(t, viper.BindPFlag(StateFileSetting, flags.Lookup("stateFile")))
+		require.Nil(t, ApplyProfile("camera2"))
+		require.Equal(t, "./fromFlag", viper.GetString(StateFileSetting))
+	})
+
+	t.Run("fail on unknown profile", func(t *testing.T) {
+		readConfig(t)
+		err := ApplyProfile("summer")
+		require.ErrorContains(t, err, `unknown profile "summer"; the config file has: camera2, loop1, loop2, notsection, winter`)
+	})
+
+	t.Run("fail on a profile that extends itself", func(t *testing.T) {
+		readConfig(t)
+		err := ApplyProfile("loop1")
+		require.ErrorContains(t, err, "loop1 -> loop2 -> loop1")
+	})
+
+	t.Run("fail on a profile that isn't a section", func(t *testing.T) {
+		readConfig(t)
+		err := ApplyProfile("notsection")
+		require.ErrorContains(t, err, `profile "notsection" must be a section of settings`)
+	})
+}
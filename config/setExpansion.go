@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//	A dark or flat-dark set may describe a whole series of sets, to save writing out every line of a
+//	dark library.  The exposure may be a geometric series, start..endxfactor, or a list in brackets,
+//	and the binning may be alternatives separated by |.  Each combination becomes an ordinary set:
+//		"20,30..600x2,1"       is 20,30,1  20,60,1  20,120,1  20,240,1  20,480,1
+//		"20,[60,120,300],1|2"  is 20,60,1  20,60,2  20,120,1  20,120,2  20,300,1  20,300,2
+
+// maxExpandedSets guards against a series that would make an unreasonable number of sets
+const maxExpandedSets = 1000
+
+// IsSetSeries is true if a dark set string describes a series of sets rather than a single set
+func IsSetSeries(set string) bool {
+	for _, part := range strings.Split(set, ",") {
+		if !strings.Contains(part, "=") && (strings.ContainsAny(part, "[]|") || strings.Contains(part, "..")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandDarkSet expands a dark set series into ordinary dark sets, in order of exposure and then
+// binning.  Any camera settings apply to every set.  A set that isn't a series is returned as it is.
+func ExpandDarkSet(darkSet string) ([]string, error) {
+	if !IsSetSeries(darkSet) {
+		return []string{darkSet}, nil
+	}
+	parts, err := splitOutsideBrackets(darkSet)
+	if err != nil {
+		return nil, err
+	}
+	var numbers, settings []string
+	for _, part := range parts {
+		if strings.Contains(part, "=") {
+			settings = append(settings, strings.TrimSpace(part))
+		} else {
+			numbers = append(numbers, strings.TrimSpace(part))
+		}
+	}
+	if len(numbers) != 3 {
+		return nil, errors.New("dark set series must have 3 parts: count,exposures,binnings")
+	}
+	exposures, err := expandExposures(numbers[1])
+	if err != nil {
+		return nil, err
+	}
+	binnings := strings.Split(numbers[2], "|")
+	if len(exposures)*len(binnings) > maxExpandedSets {
+		return nil, fmt.Errorf("dark set series makes %d sets, more than the limit of %d", len(exposures)*len(binnings), maxExpandedSets)
+	}
+
+	var sets []string
+	for _, exposure := range exposures {
+		for _, binning := range binnings {
+			set := append([]string{numbers[0], exposure, strings.TrimSpace(binning)}, settings...)
+			sets = append(sets, strings.Join(set, ","))
+		}
+	}
+	return sets, nil
+}
+
+// expandExposures turns the exposure part of a series into the list of exposures it stands for
+func expandExposures(exposures string) ([]string, error) {
+	if strings.HasPrefix(exposures, "[") {
+		if !strings.HasSuffix(exposures, "]") {
+			return nil, errors.New("dark set exposure list must end with ]")
+		}
+		var list []string
+		for _, exposure := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(exposures, "["), "]"), ",") {
+			if exposure = strings.TrimSpace(exposure); exposure == "" {
+				return nil, errors.New("dark set exposure list has an empty entry")
+			}
+			list = append(list, exposure)
+		}
+		return list, nil
+	}
+
+	first, rest, isRange := strings.Cut(exposures, "..")
+	if !isRange {
+		return []string{exposures}, nil
+	}
+	last, factorString, hasFactor := strings.Cut(rest, "x")
+	if !hasFactor {
+		return nil, errors.New("dark set exposure range must give a factor, as in 30..600x2")
+	}
+	start, err := strconv.ParseFloat(strings.TrimSpace(first), 64)
+	if err != nil || start <= 0 {
+		return nil, fmt.Errorf("dark set exposure range start must be a number > 0, not %q", first)
+	}
+	end, err := strconv.ParseFloat(strings.TrimSpace(last), 64)
+	if err != nil || end < start {
+		return nil, fmt.Errorf("dark set exposure range end must be a number >= the start, not %q", last)
+	}
+	factor, err := strconv.ParseFloat(strings.TrimSpace(factorString), 64)
+	if err != nil || factor <= 1 {
+		return nil, fmt.Errorf("dark set exposure range factor must be a number > 1, not %q", factorString)
+	}
+
+	var list []string
+	//	Allow for rounding, so 0.1..0.4x2 includes 0.4
+	for exposure := start; exposure <= end*(1+1e-9) && len(list) <= maxExpandedSets; exposure *= factor {
+		list = append(list, strconv.FormatFloat(math.Round(exposure*1e6)/1e6, 'f', -1, 64))
+	}
+	return list, nil
+}
+
+// splitOutsideBrackets splits a set string at the commas that aren't inside an exposure list
+func splitOutsideBrackets(set string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i, character := range set {
+		switch character {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, set[start:i])
+				start = i + 1
+			}
+		}
+		if depth < 0 || depth > 1 {
+			return nil, errors.New("dark set has mismatched brackets")
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("dark set has mismatched brackets")
+	}
+	return append(parts, set[start:]), nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestExpandDarkSet(t *testing.T) {
+
+	t.Run("ordinary set is left alone", func(t *testing.T) {
+		sets, err := ExpandDarkSet("20,300,1,readout=Fast..Slow")
+		require.Nil(t, err)
+		require.Equal(t, []string{"20,300,1,readout=Fast..Slow"}, sets)
+	})
+
+	t.Run("geometric range of exposures", func(t *testing.T) {
+		sets, err := ExpandDarkSet("20,30..600x2,1")
+		require.Nil(t, err)
+		require.Equal(t, []string{"20,30,1", "20,60,1", "20,120,1", "20,240,1", "20,480,1"}, sets)
+	})
+
+	t.Run("range end is included despite rounding", func(t *testing.T) {
+		sets, err := ExpandDarkSet("10,0.1..0.4x2,1")
+		require.Nil(t, err)
+		require.Equal(t, []string{"10,0.1,1", "10,0.2,1", "10,0.4,1"}, sets)
+	})
+
+	t.Run("list of exposures with alternative binnings", func(t *testing.T) {
+		sets, err := ExpandDarkSet("20,[60,120,300],1|2")
+		require.Nil(t, err)
+		require.Equal(t, []string{"20,60,1", "20,60,2", "20,120,1", "20,120,2", "20,300,1", "20,300,2"}, sets)
+	})
+
+	t.Run("camera settings apply to every set", func(t *testing.T) {
+		sets, err := ExpandDarkSet("5,[60, 120],2,gain=100")
+		require.Nil(t, err)
+		require.Equal(t, []string{"5,60,2,gain=100", "5,120,2,gain=100"}, sets)
+	})
+
+	t.Run("expanded sets parse", func(t *testing.T) {
+		sets, err := ExpandDarkSet("20,30..600x2,1|2")
+		require.Nil(t, err)
+		require.Len(t, sets, 10)
+		for _, set := range sets {
+			_, _, _, err := ParseDarkSet(set)
+			require.Nil(t, err, set)
+		}
+	})
+
+	t.Run("series must be expanded before parsing", func(t *testing.T) {
+		_, _, _, err := ParseDarkSet("20,30..600x2,1")
+		require.ErrorContains(t, err, "is a series of sets")
+	})
+
+	t.Run("fail on bad ranges", func(t *testing.T) {
+		_, err := ExpandDarkSet("20,30..600,1")
+		require.ErrorContains(t, err, "must give a factor")
+		_, err = ExpandDarkSet("20,30..600x1,1")
+		require.ErrorContains(t, err, "factor must be a number > 1")
+		_, err = ExpandDarkSet("20,600..30x2,1")
+		require.ErrorContains(t, err, "end must be a number >= the start")
+		_, err = ExpandDarkSet("20,0..30x2,1")
+		require.ErrorContains(t, err, "start must be a number > 0")
+	})
+
+	t.Run("fail on bad lists", func(t *testing.T) {
+		_, err := ExpandDarkSet("20,[60,120,1")
+		require.ErrorContains(t, err, "mismatched brackets")
+		_, err = ExpandDarkSet("20,[60,,120],1")
+		require.ErrorContains(t, err, "empty entry")
+		_, err = ExpandDarkSet("20,[60,120]")
+		require.ErrorContains(t, err, "must have 3 parts")
+	})
+
+	t.Run("fail on too many sets", func(t *testing.T) {
+		_, err := ExpandDarkSet("1,0.001..100000x1.001,1")
+		require.ErrorContains(t, err, "more than the limit")
+	})
+
+	t.Run("frame lists are expanded, including mappings", func(t *testing.T) {
+		viper.Set(DarkFramesSetting, []interface{}{
+			"20,[60,120],1|2",
+			map[string]interface{}{"count": 10, "exposure": "30..120x2", "binning": 1},
+			map[string]interface{}{"count": 5, "exposure": []interface{}{0.5, 1}, "binning": []interface{}{1, 2}},
+		})
+		defer viper.Set(DarkFramesSetting, nil)
+		sets, err := FrameSets(DarkFramesSetting)
+		require.Nil(t, err)
+		require.Equal(t, []string{
+			"20,60,1", "20,60,2", "20,120,1", "20,120,2",
+			"10,30,1", "10,60,1", "10,120,1",
+			"5,0.5,1", "5,0.5,2", "5,1,1", "5,1,2",
+		}, sets)
+	})
+
+	t.Run("bias sets don't take a binning list", func(t *testing.T) {
+		viper.Set(BiasFramesSetting, []interface{}{map[string]interface{}{"count": 10, "binning": []interface{}{1, 2}}})
+		defer viper.Set(BiasFramesSetting, nil)
+		_, err := FrameSets(BiasFramesSetting)
+		require.ErrorContains(t, err, `biasframes item 1: field "binning" must be a whole number >= 1`)
+	})
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+//	Validate checks the settings as a whole, after the config file, profile, environment and flags
+//	have all been applied.  Rather than stopping at the first problem, it reports them all, so a
+//	config file can be fixed in one go.
+
+// validation collects the problems found while validating
+type validation struct {
+	problems []error
+}
+
+func (v *validation) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Errorf(format, args...))
+}
+
+func (v *validation) add(err error) {
+	if err != nil {
+		v.problems = append(v.problems, err)
+	}
+}
+
+// Validate checks every setting, returning all the problems found joined into one error (see
+// Problems), or nil if there are none
+func Validate() error {
+	var v validation
+	v.add(ValidateGlobals())
+	if format := strings.ToLower(viper.GetString(LogFormatSetting)); format != "" && format != "text" && format != "json" {
+		v.addf("log format must be text or json, not %q", viper.GetString(LogFormatSetting))
+	}
+	if store := strings.ToLower(viper.GetString(StateStoreSetting)); store != "" && store != StateStoreFile && store != StateStoreSQLite {
+		v.addf("state store must be %s or %s, not %q", StateStoreFile, StateStoreSQLite, viper.GetString(StateStoreSetting))
+	}
+	validateServer(&v)
+	validateCooling(&v)
+	validateStart(&v)
+	validateFrames(&v)
+	return errors.Join(v.problems...)
+}
+
+// Problems lists the separate problems in an error returned by Validate
+func Problems(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	if err == nil {
+		return nil
+	}
+	return []error{err}
+}
+
+func validateServer(v *validation) {
+	if strings.TrimSpace(viper.GetString(ServerAddressSetting)) == "" {
+		v.addf("server address is missing")
+	}
+	if port := viper.GetInt(ServerPortSetting); port < 1 || port > 65535 {
+		v.addf("server port (%d) must be between 1 and 65535", port)
+	}
+	if attempts := viper.GetInt(RetryAttemptsSetting); attempts < 0 {
+		v.addf("retry attempts (%d) must not be negative", attempts)
+	}
+	backoff := viper.GetInt(RetryBackoffSecondsSetting)
+	maxBackoff := viper.GetInt(RetryMaxBackoffSecondsSetting)
+	if backoff < 0 {
+		v.addf("retry backoff (%d seconds) must not be negative", backoff)
+	}
+	if maxBackoff < backoff {
+		v.addf("retry maximum backoff (%d seconds) must be at least the backoff (%d seconds)", maxBackoff, backoff)
+	}
+}
+
+func validateCooling(v *validation) {
+	temperatures, err := ParseTemperatureList(viper.Get(CoolToListSetting))
+	if err != nil {
+		v.addf("cool to list: %w", err)
+	}
+	//	Without the cooler, every temperature in the list would be captured at whatever the sensor is
+	if len(temperatures) > 0 && !viper.GetBool(UseCoolerSetting) {
+		v.addf("cool to list %v needs the cooler; set useCooler", temperatures)
+	}
+	startTolerance := viper.GetFloat64(CoolStartTolSetting)
+	abortTolerance := viper.GetFloat64(CoolAbortTolSetting)
+	if startTolerance < 0 {
+		v.addf("cooling start tolerance (%g degrees) must not be negative", startTolerance)
+	}
+	if abortTolerance < 0 {
+		v.addf("cooling abort tolerance (%g degrees) must not be negative", abortTolerance)
+	}
+	//	Otherwise a capture could start at a temperature it would then abort at
+	if viper.GetBool(AbortOnCoolingSetting) && startTolerance > abortTolerance {
+		v.addf("cooling start tolerance (%g degrees) must not be more than the abort tolerance (%g degrees)",
+			startTolerance, abortTolerance)
+	}
+	if minutes := viper.GetInt(CoolWaitMinutesSetting); minutes < 0 {
+		v.addf("cooling wait (%d minutes) must not be negative", minutes)
+	}
+	if seconds := viper.GetInt(StartPollSecondsSetting); seconds <= 0 {
+		v.addf("cooling poll interval (%d seconds) must be more than 0", seconds)
+	}
+}
+
+func validateStart(v *validation) {
+	delay, start, err := ParseStart()
+	if err != nil {
+		v.addf("delayed start: %w", err)
+		return
+	}
+	if delay && start.Before(time.Now()) {
+		v.addf("delayed start time %s has already passed", start.Format("2006-01-02 15:04"))
+	}
+}
+
+// validateFrames checks the frame sets, their order, and that there is something to capture
+func validateFrames(v *validation) {
+	if viper.GetBool(DarkFirstSetting) && viper.GetBool(BiasFirstSetting) {
+		v.addf("darkfirst and biasfirst are both set; choose one")
+	}
+	if _, err := CaptureOrder(); err != nil {
+		v.add(err)
+	}
+
+	frames, problemsBefore := 0, len(v.problems)
+	for _, list := range []struct {
+		setting string
+		skip    string
+	}{
+		{BiasFramesSetting, NoBiasSetting},
+		{DarkFramesSetting, NoDarkSetting},
+		{FlatDarkFramesSetting, NoFlatDarkSetting},
+	} {
+		count := validateFrameSets(v, list.setting)
+		if !viper.GetBool(list.skip) {
+			frames += count
+		}
+	}
+	if frames == 0 && len(v.problems) == problemsBefore {
+		v.addf("nothing to capture: the bias, dark and flat-dark lists are empty or skipped")
+	}
+}
+
+// validateFrameSets checks each set in a frame list, and that no two sets would take the same
+// frames.  It returns the number of frames in the valid sets.
+func validateFrameSets(v *validation, setting string) int {
+	name := strings.ToLower(setting)
+	sets, err := FrameSets(setting)
+	if err != nil {
+		v.add(err)
+		return 0
+	}
+	frameType := "Dark"
+	if setting == BiasFramesSetting {
+		frameType = "Bias"
+	} else if setting == FlatDarkFramesSetting {
+		frameType = "FlatDark"
+	}
+	frames := 0
+	seen := make(map[string]string)
+	for i, set := range sets {
+		var count, binning int
+		var exposure float64
+		if setting == BiasFramesSetting {
+			count, binning, err = ParseBiasSet(set)
+		} else if setting == FlatDarkFramesSetting {
+			count, exposure, binning, err = ParseFlatDarkSet(set)
+		} else {
+			count, exposure, binning, err = ParseDarkSet(set)
+		}
+		if err != nil {
+			v.addf("%s set %d (%q): %w", name, i+1, set, err)
+			continue
+		}
+		camera, err := ParseCameraSettings(set)
+		if err != nil {
+			v.addf("%s set %d (%q): %w", name, i+1, set, err)
+			continue
+		}
+		//	Sets with the same state file key would share their done count; the temperature is the same for all
+		identity := FrameKey(frameType, exposure, binning, 0, camera)
+		if earlier, duplicate := seen[identity]; duplicate {
+			v.addf("%s set %d (%q) duplicates %q; combine them into one set", name, i+1, set, earlier)
+			continue
+		}
+		seen[identity] = set
+		frames += count
+	}
+	return frames
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+
+	const goodConfig = "verbosity: 3\nlogFileVerbosity: 5\nlogFormat: text\n" +
+		"cooling:\n  coolStartTol: 2\n  coolAbortTol: 2\n  abortOnCooling: true\n  coolWaitMinutes: 30\n  startPollSeconds: 10\n" +
+		"start:\n  delay: false\n" +
+		"server:\n  address: localhost\n  port: 3040\n" +
+		"retry:\n  attempts: 3\n  backoffSeconds: 10\n  maxBackoffSeconds: 300\n" +
+		"darkFirst: true\nbiasFirst: false\n" +
+		"biasframes:\n  - \"32,1\"\n" +
+		"darkframes:\n  - \"20,300,1\"\n  - \"20,300,2\"\n"
+
+	//	readConfig reads a good config file, then applies the given changes to it
+	readConfig := func(t *testing.T, changes map[string]interface{}) {
+		viper.Reset()
+		viper.SetConfigType("yml")
+		require.Nil(t, viper.ReadConfig(bytes.NewBufferString(goodConfig)))
+		for key, value := range changes {
+			viper.Set(key, value)
+		}
+	}
+	t.Cleanup(viper.Reset)
+
+	t.Run("good config has no problems", func(t *testing.T) {
+		readConfig(t, nil)
+		require.Nil(t, Validate())
+	})
+
+	t.Run("every problem is reported", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{
+			CoolWaitMinutesSetting: -1,
+			CoolStartTolSetting:    3,
+			ServerPortSetting:      0,
+			StartDelaySetting:      true,
+			StartDaySetting:        "2020-01-01",
+			StartTimeSetting:       "10:00",
+			BiasFirstSetting:       true,
+		})
+		err := Validate()
+		require.NotNil(t, err)
+		problems := Problems(err)
+		require.Len(t, problems, 5)
+		require.ErrorContains(t, err, "cooling wait (-1 minutes) must not be negative")
+		require.ErrorContains(t, err, "cooling start tolerance (3 degrees) must not be more than the abort tolerance (2 degrees)")
+		require.ErrorContains(t, err, "server port (0) must be between 1 and 65535")
+		require.ErrorContains(t, err, "delayed start time 2020-01-01 10:00 has already passed")
+		require.ErrorContains(t, err, "darkfirst and biasfirst are both set")
+	})
+
+	t.Run("start tolerance only matters when aborting on cooling", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{CoolStartTolSetting: 3, AbortOnCoolingSetting: false})
+		require.Nil(t, Validate())
+	})
+
+	t.Run("state store", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{StateStoreSetting: "SQLite"})
+		require.Nil(t, Validate())
+		readConfig(t, map[string]interface{}{StateStoreSetting: "postgres"})
+		require.ErrorContains(t, Validate(), `state store must be file or sqlite, not "postgres"`)
+	})
+
+	t.Run("cool to list needs the cooler", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{CoolToListSetting: "-5,-10"})
+		require.ErrorContains(t, Validate(), "cool to list [-5 -10] needs the cooler; set useCooler")
+		readConfig(t, map[string]interface{}{CoolToListSetting: "-5,-10", UseCoolerSetting: true})
+		require.Nil(t, Validate())
+	})
+
+	t.Run("duplicate frame sets", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{DarkFramesSetting: []string{"20,300,1", "10,300,1", "10,300,1,gain=100"}})
+		err := Validate()
+		require.Len(t, Problems(err), 1)
+		require.ErrorContains(t, err, `darkframes set 2 ("10,300,1") duplicates "20,300,1"`)
+
+		//	Exposures the state file key can't tell apart are duplicates too
+		readConfig(t, map[string]interface{}{DarkFramesSetting: []string{"20,300,1", "10,300.00001,1"}})
+		require.ErrorContains(t, Validate(), `darkframes set 2 ("10,300.00001,1") duplicates "20,300,1"`)
+	})
+
+	t.Run("bad frame sets are all reported", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{
+			DarkFramesSetting: []string{"20,300", "20,300,1", "x,300,1"},
+			BiasFramesSetting: []string{"0,1"},
+		})
+		err := Validate()
+		require.Len(t, Problems(err), 3)
+		require.ErrorContains(t, err, `darkframes set 1 ("20,300")`)
+		require.ErrorContains(t, err, `darkframes set 3 ("x,300,1")`)
+		require.ErrorContains(t, err, `biasframes set 1 ("0,1")`)
+	})
+
+	t.Run("nothing to capture", func(t *testing.T) {
+		readConfig(t, map[string]interface{}{DarkFramesSetting: []string{}, BiasFramesSetting: []string{}})
+		require.ErrorContains(t, Validate(), "nothing to capture")
+
+		readConfig(t, map[string]interface{}{NoDarkSetting: true, NoBiasSetting: true})
+		require.ErrorContains(t, Validate(), "nothing to capture")
+	})
+
+	t.Run("no problems, no list", func(t *testing.T) {
+		require.Nil(t, Problems(nil))
+	})
+}
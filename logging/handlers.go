@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// consoleHandler writes just the message of each record, one per line, the way
+// goskydarks has always written progress to the console
+type consoleHandler struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	level  slog.Level
+}
+
+func newConsoleHandler(writer io.Writer, level slog.Level) *consoleHandler {
+	return &consoleHandler{mutex: &sync.Mutex{}, writer: writer, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := io.WriteString(h.writer, record.Message+"\n")
+	return err
+}
+
+// Attributes and groups are only of interest in the log file, so the console ignores them
+func (h *consoleHandler) WithAttrs(_ []slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *consoleHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
+
+// fanOutHandler passes each record to every handler that wants it
+type fanOutHandler []slog.Handler
+
+func (h fanOutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanOutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, handler := range h {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := handler.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (h fanOutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanOutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h fanOutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanOutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+//	Leveled logging for goskydarks, built on log/slog.
+//	The levels correspond to the verbosity settings described in verbosities.md:
+//
+//		0 Silent       errors only
+//		1 Minimal      essential output
+//		2 Informative  production-oriented detail
+//		3 Verbose      internal behaviour
+//		4 Debug        function entry and exit
+//		5 Trace        internal variables and progress
+//
+//	Console output is the plain message, as it always has been.  A log file, if wanted, gets
+//	every message at or above its own verbosity, with time and level, in text or JSON form.
+
+// Log levels, from most to least important.  Gaps leave room between the slog built-ins.
+const (
+	LevelError       = slog.LevelError
+	LevelMinimal     = slog.Level(4)
+	LevelInformative = slog.LevelInfo
+	LevelVerbose     = slog.Level(-2)
+	LevelDebug       = slog.LevelDebug
+	LevelTrace       = slog.Level(-8)
+)
+
+var levelNames = map[slog.Level]string{
+	LevelError:       "ERROR",
+	LevelMinimal:     "MINIMAL",
+	LevelInformative: "INFO",
+	LevelVerbose:     "VERBOSE",
+	LevelDebug:       "DEBUG",
+	LevelTrace:       "TRACE",
+}
+
+// LevelForVerbosity returns the lowest level shown at the given verbosity (0 to 5).
+// The debug setting shows at least debug-level messages, as it always has.
+func LevelForVerbosity(verbosity int, debug bool) slog.Level {
+	levels := []slog.Level{LevelError, LevelMinimal, LevelInformative, LevelVerbose, LevelDebug, LevelTrace}
+	if verbosity < 0 {
+		verbosity = 0
+	}
+	if verbosity >= len(levels) {
+		verbosity = len(levels) - 1
+	}
+	level := levels[verbosity]
+	if debug && level > LevelDebug {
+		level = LevelDebug
+	}
+	return level
+}
+
+// Options describes where log output goes
+type Options struct {
+	Verbosity     int       // Console verbosity, 0 to 5
+	Debug         bool      // Show debug output on the console regardless of verbosity
+	Console       io.Writer // Defaults to standard output
+	LogFile       string    // Path of log file, appended to; "" for none
+	LogFormat     string    // "text" (default) or "json"
+	FileVerbosity int       // Log file verbosity, 0 to 5
+}
+
+// Logger writes leveled messages to the console and, optionally, a log file
+type Logger struct {
+	logger *slog.Logger
+	file   *os.File
+}
+
+// New creates a logger as described by the options
+func New(options Options) (*Logger, error) {
+	console := options.Console
+	if console == nil {
+		console = os.Stdout
+	}
+	handlers := []slog.Handler{newConsoleHandler(console, LevelForVerbosity(options.Verbosity, options.Debug))}
+
+	var file *os.File
+	if options.LogFile != "" {
+		var err error
+		file, err = os.OpenFile(options.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening log file: %w", err)
+		}
+		fileOptions := &slog.HandlerOptions{
+			Level:       LevelForVerbosity(options.FileVerbosity, false),
+			ReplaceAttr: replaceLevelName,
+		}
+		switch strings.ToLower(options.LogFormat) {
+		case "", "text":
+			handlers = append(handlers, slog.NewTextHandler(file, fileOptions))
+		case "json":
+			handlers = append(handlers, slog.NewJSONHandler(file, fileOptions))
+		default:
+			_ = file.Close()
+			return nil, errors.New(fmt.Sprintf("invalid log format %q; must be text or json", options.LogFormat))
+		}
+	}
+	return &Logger{logger: slog.New(fanOutHandler(handlers)), file: file}, nil
+}
+
+// NewConsole creates a logger that writes only to the given console writer
+func NewConsole(console io.Writer, verbosity int, debug bool) *Logger {
+	logger, _ := New(Options{Verbosity: verbosity, Debug: debug, Console: console})
+	return logger
+}
+
+// Close closes the log file, if there is one
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Enabled reports whether messages at the given level go anywhere, for callers that
+// want to avoid expensive formatting
+func (l *Logger) Enabled(level slog.Level) bool {
+	return l.logger.Enabled(context.Background(), level)
+}
+
+func (l *Logger) logf(level slog.Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	//	Callers may or may not end messages with a newline; handlers add their own
+	l.logger.Log(context.Background(), level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// Errorf logs an error, shown at every verbosity
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LevelError, format, args...)
+}
+
+// Minimalf logs essential output, shown at verbosity 1 and above
+func (l *Logger) Minimalf(format string, args ...interface{}) {
+	l.logf(LevelMinimal, format, args...)
+}
+
+// Informativef logs production-oriented detail, shown at verbosity 2 and above
+func (l *Logger) Informativef(format string, args ...interface{}) {
+	l.logf(LevelInformative, format, args...)
+}
+
+// Verbosef logs internal behaviour, shown at verbosity 3 and above
+func (l *Logger) Verbosef(format string, args ...interface{}) {
+	l.logf(LevelVerbose, format, args...)
+}
+
+// Debugf logs debugging detail, shown at verbosity 4 and above or with the debug setting
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LevelDebug, format, args...)
+}
+
+// Tracef logs internal variables and progress, shown at verbosity 5
+func (l *Logger) Tracef(format string, args ...interface{}) {
+	l.logf(LevelTrace, format, args...)
+}
+
+//	The default logger is used by anything not given one explicitly.  Until the command line
+//	has been processed it shows only errors.
+
+var defaultMutex sync.Mutex
+var defaultLogger = NewConsole(os.Stdout, 0, false)
+
+// Default returns the logger for code that hasn't been given one
+func Default() *Logger {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger
+func SetDefault(logger *Logger) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultLogger = logger
+}
+
+func replaceLevelName(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := attr.Value.Any().(slog.Level); ok {
+			if name, found := levelNames[level]; found {
+				attr.Value = slog.StringValue(name)
+			}
+		}
+	}
+	return attr
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLevelForVerbosity(t *testing.T) {
+	require.Equal(t, LevelError, LevelForVerbosity(0, false))
+	require.Equal(t, LevelMinimal, LevelForVerbosity(1, false))
+	require.Equal(t, LevelInformative, LevelForVerbosity(2, false))
+	require.Equal(t, LevelVerbose, LevelForVerbosity(3, false))
+	require.Equal(t, LevelDebug, LevelForVerbosity(4, false))
+	require.Equal(t, LevelTrace, LevelForVerbosity(5, false))
+	require.Equal(t, LevelTrace, LevelForVerbosity(9, false), "Out of range verbosity is clamped")
+	require.Equal(t, LevelDebug, LevelForVerbosity(1, true), "Debug shows debug messages")
+	require.Equal(t, LevelTrace, LevelForVerbosity(5, true), "Debug doesn't hide trace messages")
+}
+
+func TestConsole(t *testing.T) {
+
+	t.Run("shows plain messages at or above verbosity", func(t *testing.T) {
+		var console bytes.Buffer
+		logger := NewConsole(&console, 2, false)
+		logger.Errorf("error %d", 1)
+		logger.Minimalf("minimal")
+		logger.Informativef("informative\n")
+		logger.Verbosef("verbose")
+		logger.Debugf("debug")
+		require.Equal(t, "error 1\nminimal\ninformative\n", console.String())
+	})
+
+	t.Run("verbosity 0 shows only errors", func(t *testing.T) {
+		var console bytes.Buffer
+		logger := NewConsole(&console, 0, false)
+		logger.Minimalf("minimal")
+		logger.Errorf("error")
+		require.Equal(t, "error\n", console.String())
+	})
+}
+
+func TestLogFile(t *testing.T) {
+
+	t.Run("text log file gets more detail than a quiet console", func(t *testing.T) {
+		var console bytes.Buffer
+		path := filepath.Join(t.TempDir(), "log.txt")
+		logger, err := New(Options{Verbosity: 0, Console: &console, LogFile: path, FileVerbosity: 5})
+		require.Nil(t, err)
+		logger.Tracef("trace detail")
+		logger.Minimalf("minimal")
+		require.Nil(t, logger.Close())
+
+		require.Empty(t, console.String())
+		contents, err := os.ReadFile(path)
+		require.Nil(t, err)
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		require.Len(t, lines, 2)
+		require.Contains(t, lines[0], "level=TRACE")
+		require.Contains(t, lines[0], `msg="trace detail"`)
+		require.Contains(t, lines[1], "level=MINIMAL")
+	})
+
+	t.Run("json log file has one object per message", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "log.json")
+		logger, err := New(Options{Console: &bytes.Buffer{}, LogFile: path, LogFormat: "json", FileVerbosity: 2})
+		require.Nil(t, err)
+		logger.Informativef("capturing frame %d", 3)
+		logger.Verbosef("not wanted in the file")
+		require.Nil(t, logger.Close())
+
+		contents, err := os.ReadFile(path)
+		require.Nil(t, err)
+		var record map[string]interface{}
+		require.Nil(t, json.Unmarshal(bytes.TrimSpace(contents), &record), "File should hold exactly one JSON record")
+		require.Equal(t, "INFO", record["level"])
+		require.Equal(t, "capturing frame 3", record["msg"])
+	})
+
+	t.Run("unknown format is an error", func(t *testing.T) {
+		_, err := New(Options{LogFile: filepath.Join(t.TempDir(), "log"), LogFormat: "xml"})
+		require.NotNil(t, err)
+	})
+}
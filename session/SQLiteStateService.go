@@ -0,0 +1,530 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"goskydarks/config"
+	"goskydarks/logging"
+	_ "modernc.org/sqlite"
+	"os"
+	"time"
+)
+
+//	With the sqlite state store, the capture state for every cooling temperature is kept in one SQLite
+//	database, the state file path plus ".db", rather than a JSON file for each temperature.  Its tables:
+//		plans           one row for each cooling temperature with a plan, and when it was last saved
+//		sets            the sets required at each temperature, with their keys, in the order given
+//		done            frames done, by temperature, frame type and set key
+//		download_times  measured download time, by temperature and binning
+//		sessions        each capture run that recorded frames, at any temperature, and when it started
+//		frames          the history of every frame attempted, and the session it was part of
+//	The set_progress view joins the sets to their done counts, so questions like "which sets at -10
+//	are incomplete" can be answered with the sqlite3 tool:
+//		SELECT key, required, done, remaining FROM set_progress WHERE temperature = -10 AND remaining > 0
+//	The database uses write-ahead logging, and each save or read is one transaction, so the status
+//	and history commands can read it while a capture is writing, and always see a consistent state.
+//	Reads are read-only transactions, so they neither wait for a capture's writes nor hold them up.
+//	The driver is modernc.org/sqlite, a translation of SQLite to Go, so no C compiler is needed.
+
+// sqliteSuffix is added to the state file path to name the database
+const sqliteSuffix = ".db"
+
+// sqliteSchemaVersion is the layout of the database this program writes, kept as its user_version.
+// To change the layout, increase it and add the statements that upgrade the previous version.
+const sqliteSchemaVersion = 1
+
+// sqliteBusyTimeoutMilliseconds is how long to wait for another program's transaction to finish
+const sqliteBusyTimeoutMilliseconds = 10000
+
+// sqliteTimeLayout stores frame times in UTC at a fixed width, so they sort as text
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteSchema creates the database, as sqliteSchemaVersion
+var sqliteSchema = []string{
+	`CREATE TABLE plans (
+		temperature REAL PRIMARY KEY,
+		updated     TEXT NOT NULL
+	)`,
+	`CREATE TABLE sets (
+		temperature REAL NOT NULL,
+		frame_type  TEXT NOT NULL,
+		position    INTEGER NOT NULL,
+		spec        TEXT NOT NULL,
+		key         TEXT NOT NULL,
+		exposure    REAL NOT NULL,
+		binning     INTEGER NOT NULL,
+		camera      TEXT NOT NULL,
+		required    INTEGER NOT NULL,
+		PRIMARY KEY (temperature, frame_type, position)
+	)`,
+	`CREATE TABLE done (
+		temperature REAL NOT NULL,
+		frame_type  TEXT NOT NULL,
+		key         TEXT NOT NULL,
+		count       INTEGER NOT NULL,
+		PRIMARY KEY (temperature, frame_type, key)
+	)`,
+	`CREATE TABLE download_times (
+		temperature REAL NOT NULL,
+		binning     INTEGER NOT NULL,
+		seconds     REAL NOT NULL,
+		PRIMARY KEY (temperature, binning)
+	)`,
+	`CREATE TABLE sessions (
+		id      INTEGER PRIMARY KEY,
+		started TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE frames (
+		temperature        REAL NOT NULL,
+		session_id         INTEGER REFERENCES sessions (id),
+		time               TEXT NOT NULL,
+		frame_type         TEXT NOT NULL,
+		key                TEXT NOT NULL,
+		exposure           REAL NOT NULL,
+		binning            INTEGER NOT NULL,
+		camera             TEXT NOT NULL,
+		temperature_before REAL,
+		temperature_after  REAL,
+		download_seconds   REAL NOT NULL,
+		result             TEXT NOT NULL,
+		UNIQUE (temperature, time, frame_type, key)
+	)`,
+	`CREATE VIEW set_progress AS
+		SELECT sets.temperature, sets.frame_type, sets.position, sets.key, sets.spec, sets.exposure,
+		       sets.binning, sets.camera, sets.required, COALESCE(done.count, 0) AS done,
+		       MAX(sets.required - COALESCE(done.count, 0), 0) AS remaining
+		FROM sets LEFT JOIN done
+		  ON done.temperature = sets.temperature AND done.frame_type = sets.frame_type AND done.key = sets.key`,
+}
+
+// SQLiteStateService keeps the capture plan for one cooling temperature in the SQLite database
+type SQLiteStateService struct {
+	DatabasePath string
+	Temperature  float64   // cooling temperature whose plan this reads and writes
+	runStarted   time.Time // start of the capture run whose frames this records, if known
+	sessionID    int64     // sessions row of the frames this service has recorded, once there are any
+	savedHistory int       // leading records of the plan's history known to be in the database
+	logger       *logging.Logger
+}
+
+func NewSQLiteStateService(stateFilePath string, temperature float64) StateFileService {
+	return &SQLiteStateService{
+		DatabasePath: stateFilePath + sqliteSuffix,
+		Temperature:  temperature,
+		logger:       logging.Default(),
+	}
+}
+
+// SetLogger replaces the logger used for diagnostic output
+func (ss *SQLiteStateService) SetLogger(logger *logging.Logger) {
+	ss.logger = logger
+}
+
+// SetRunStarted groups the frames recorded from now on into the session of the capture run that
+// started at the given time, which the services for the run's other temperatures share
+func (ss *SQLiteStateService) SetRunStarted(started time.Time) {
+	if !started.Equal(ss.runStarted) {
+		ss.runStarted = started
+		ss.sessionID = 0
+	}
+}
+
+// open opens the database, creating it if need be
+func (ss *SQLiteStateService) open() (*sql.DB, error) {
+	//	Immediate transactions take the write lock at the start, so a transaction never has to be
+	//	abandoned because another program wrote in the middle of it.  Read-only transactions are
+	//	still deferred, taking no lock.  The pragmas are run in order as each connection opens, so the
+	//	busy timeout comes first, to cover setting the journal mode while another program writes.
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate",
+		ss.DatabasePath, sqliteBusyTimeoutMilliseconds)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := ss.createSchema(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%s: %w", ss.DatabasePath, err)
+	}
+	return db, nil
+}
+
+// schemaVersion returns the layout version of the database, refusing one from a newer goskydarks
+func schemaVersion(query interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (int, error) {
+	var version int
+	if err := query.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > sqliteSchemaVersion {
+		return 0, fmt.Errorf("%w: it has version %d, and this goskydarks reads up to version %d",
+			ErrStateFileTooNew, version, sqliteSchemaVersion)
+	}
+	return version, nil
+}
+
+// createSchema creates the tables of a new database, and refuses one from a newer goskydarks
+func (ss *SQLiteStateService) createSchema(db *sql.DB) error {
+	//	Checked first without a transaction, so a database that is already set up isn't locked
+	if version, err := schemaVersion(db); err != nil || version == sqliteSchemaVersion {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	//	Another program may have created it meanwhile
+	version, err := schemaVersion(tx)
+	if err != nil || version == sqliteSchemaVersion {
+		return err
+	}
+	ss.logger.Informativef("Creating state database %s", ss.DatabasePath)
+	for _, statement := range sqliteSchema {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ss *SQLiteStateService) SavePlanToFile(capturePlan *CapturePlan) error {
+	ss.logger.Debugf("SQLiteStateService/SavePlanToFile()")
+	ss.logger.Debugf("  Plan: %#v", capturePlan)
+	db, err := ss.open()
+	if err != nil {
+		ss.logger.Errorf("Unable to open state database: %v", err)
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	sessionID, err := ss.savePlan(tx, capturePlan)
+	if err != nil {
+		ss.logger.Errorf("Unable to save plan to state database: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		ss.logger.Errorf("Unable to save plan to state database: %v", err)
+		return err
+	}
+	ss.sessionID = sessionID
+	ss.savedHistory = len(capturePlan.History)
+
+	ss.logger.Tracef("SavePlanToFile exits")
+	return nil
+}
+
+// savePlan replaces the plan for the service's temperature, and adds any frame records not already
+// saved, returning the session they were added to
+func (ss *SQLiteStateService) savePlan(tx *sql.Tx, plan *CapturePlan) (int64, error) {
+	if _, err := tx.Exec(`INSERT INTO plans (temperature, updated) VALUES (?, ?)
+		ON CONFLICT (temperature) DO UPDATE SET updated = excluded.updated`,
+		ss.Temperature, time.Now().UTC().Format(sqliteTimeLayout)); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"sets", "done", "download_times"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE temperature = ?", ss.Temperature); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, frameType := range []string{"Dark", "Bias", "FlatDark"} {
+		for position, spec := range plan.setsRequired(frameType) {
+			set, err := plan.parseFrameSet(frameType, spec)
+			if err != nil {
+				return 0, err
+			}
+			exposure := set.Exposure
+			if frameType == "Bias" {
+				exposure = 0
+			}
+			if _, err := tx.Exec(`INSERT INTO sets (temperature, frame_type, position, spec, key, exposure, binning, camera, required)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				ss.Temperature, frameType, position, spec, set.Key, exposure, set.Binning, cameraText(set.Camera), set.Count); err != nil {
+				return 0, err
+			}
+		}
+		for key, count := range plan.doneCounts(frameType) {
+			if _, err := tx.Exec("INSERT INTO done (temperature, frame_type, key, count) VALUES (?, ?, ?, ?)",
+				ss.Temperature, frameType, key, count); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for binning, seconds := range plan.DownloadTimes {
+		if _, err := tx.Exec("INSERT INTO download_times (temperature, binning, seconds) VALUES (?, ?, ?)",
+			ss.Temperature, binning, seconds); err != nil {
+			return 0, err
+		}
+	}
+
+	//	The history only grows, and starts with the records read from or saved to the database, so
+	//	only the records after those are added.  A record that is there anyway is left alone.
+	newRecords := plan.History
+	if ss.savedHistory <= len(plan.History) {
+		newRecords = plan.History[ss.savedHistory:]
+	}
+	if len(newRecords) == 0 {
+		return ss.sessionID, nil
+	}
+	sessionID, err := ss.captureSession(tx, newRecords[0].Time)
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range newRecords {
+		if _, err := tx.Exec(`INSERT INTO frames (temperature, session_id, time, frame_type, key, exposure, binning, camera,
+			temperature_before, temperature_after, download_seconds, result) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (temperature, time, frame_type, key) DO NOTHING`,
+			ss.Temperature, sessionID, record.Time.UTC().Format(sqliteTimeLayout), record.FrameType, record.Key,
+			record.Exposure, record.Binning, record.Camera, nullableTemperature(record.TemperatureBefore),
+			nullableTemperature(record.TemperatureAfter), record.DownloadSeconds, record.Result); err != nil {
+			return 0, err
+		}
+	}
+	return sessionID, nil
+}
+
+// captureSession returns the sessions row for the frames being recorded, adding it if need be.
+// The row is found by the run's start time, so every temperature of a run shares it; without
+// one, the service's frames get a session of their own, starting with the first of them.
+func (ss *SQLiteStateService) captureSession(tx *sql.Tx, firstFrame time.Time) (int64, error) {
+	if ss.sessionID != 0 {
+		return ss.sessionID, nil
+	}
+	started := ss.runStarted
+	if started.IsZero() {
+		started = firstFrame
+	}
+	startedText := started.UTC().Format(sqliteTimeLayout)
+	if _, err := tx.Exec("INSERT INTO sessions (started) VALUES (?) ON CONFLICT (started) DO NOTHING", startedText); err != nil {
+		return 0, err
+	}
+	var sessionID int64
+	err := tx.QueryRow("SELECT id FROM sessions WHERE started = ?", startedText).Scan(&sessionID)
+	return sessionID, err
+}
+
+func (ss *SQLiteStateService) UpdatePlanFromFile(capturePlan *CapturePlan) error {
+	ss.logger.Debugf("SQLiteStateService/UpdatePlanFromFile()")
+	ss.logger.Debugf("   Plan: %v", capturePlan)
+	savedPlan, err := ss.ReadStateFile()
+	if err != nil {
+		ss.logger.Errorf("Error in Session updatePlanFromStateFile, reading state database: %v", err)
+		return err
+	}
+	if savedPlan == nil {
+		//	Nothing saved at this temperature, so nothing to update
+		return nil
+	}
+	ss.logger.Verbosef("  Plan read from state database: %v", savedPlan)
+	mergeSavedPlan(ss.logger, capturePlan, savedPlan)
+
+	ss.logger.Debugf("UpdatePlanFromFile exits")
+	return nil
+}
+
+func (ss *SQLiteStateService) ReadStateFile() (*CapturePlan, error) {
+	ss.logger.Debugf("ReadStateFile.  Database: %s, temperature %g", ss.DatabasePath, ss.Temperature)
+
+	//	Reading doesn't create the database
+	if _, err := os.Stat(ss.DatabasePath); errors.Is(err, os.ErrNotExist) {
+		ss.logger.Debugf("State database does not exist")
+		return nil, nil
+	}
+	db, err := ss.open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	plan, err := ss.readPlan(tx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ss.DatabasePath, err)
+	}
+	if plan != nil {
+		//	A plan saved after this one, with history added, need only add to what was read
+		ss.savedHistory = len(plan.History)
+	}
+
+	ss.logger.Debugf("ReadStateFile exits")
+	return plan, nil
+}
+
+// readPlan reads the plan for the service's temperature, or nil if there isn't one
+func (ss *SQLiteStateService) readPlan(tx *sql.Tx) (*CapturePlan, error) {
+	var updated string
+	err := tx.QueryRow("SELECT updated FROM plans WHERE temperature = ?", ss.Temperature).Scan(&updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		ss.logger.Debugf("No plan saved for this temperature")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan := &CapturePlan{
+		Version:           StateFileVersion,
+		Temperature:       ss.Temperature,
+		DarksRequired:     []string{},
+		BiasRequired:      []string{},
+		FlatDarksRequired: []string{},
+		DarksDone:         map[string]int{},
+		BiasDone:          map[string]int{},
+		FlatDarksDone:     map[string]int{},
+		DownloadTimes:     map[int]float64{},
+		History:           []FrameRecord{},
+	}
+
+	if err := queryRows(tx, "SELECT frame_type, spec FROM sets WHERE temperature = ? ORDER BY frame_type, position",
+		[]interface{}{ss.Temperature}, func(rows *sql.Rows) error {
+			var frameType, spec string
+			if err := rows.Scan(&frameType, &spec); err != nil {
+				return err
+			}
+			switch frameType {
+			case "Dark":
+				plan.DarksRequired = append(plan.DarksRequired, spec)
+			case "FlatDark":
+				plan.FlatDarksRequired = append(plan.FlatDarksRequired, spec)
+			default:
+				plan.BiasRequired = append(plan.BiasRequired, spec)
+			}
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+
+	if err := queryRows(tx, "SELECT frame_type, key, count FROM done WHERE temperature = ?",
+		[]interface{}{ss.Temperature}, func(rows *sql.Rows) error {
+			var frameType, key string
+			var count int
+			if err := rows.Scan(&frameType, &key, &count); err != nil {
+				return err
+			}
+			plan.doneCounts(frameType)[key] = count
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+
+	if err := queryRows(tx, "SELECT binning, seconds FROM download_times WHERE temperature = ?",
+		[]interface{}{ss.Temperature}, func(rows *sql.Rows) error {
+			var binning int
+			var seconds float64
+			if err := rows.Scan(&binning, &seconds); err != nil {
+				return err
+			}
+			plan.DownloadTimes[binning] = seconds
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+
+	if err := queryRows(tx, `SELECT time, frame_type, key, exposure, binning, camera, temperature_before,
+		temperature_after, download_seconds, result FROM frames WHERE temperature = ? ORDER BY time, rowid`,
+		[]interface{}{ss.Temperature}, func(rows *sql.Rows) error {
+			var record FrameRecord
+			var recordTime string
+			var before, after sql.NullFloat64
+			if err := rows.Scan(&recordTime, &record.FrameType, &record.Key, &record.Exposure, &record.Binning,
+				&record.Camera, &before, &after, &record.DownloadSeconds, &record.Result); err != nil {
+				return err
+			}
+			parsed, err := time.Parse(sqliteTimeLayout, recordTime)
+			if err != nil {
+				return err
+			}
+			record.Time = parsed
+			if before.Valid {
+				record.TemperatureBefore = &before.Float64
+			}
+			if after.Valid {
+				record.TemperatureAfter = &after.Float64
+			}
+			plan.History = append(plan.History, record)
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// queryRows runs a query, passing each row it returns to the given function
+func queryRows(tx *sql.Tx, query string, args []interface{}, eachRow func(rows *sql.Rows) error) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		if err := eachRow(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteStateFile removes everything recorded at the service's temperature, including its history,
+// so the next capture starts from scratch.  Other temperatures are kept.
+func (ss *SQLiteStateService) DeleteStateFile() error {
+	ss.logger.Debugf("DeleteStateFile.  Database: %s, temperature %g", ss.DatabasePath, ss.Temperature)
+	if _, err := os.Stat(ss.DatabasePath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := ss.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, table := range []string{"frames", "sets", "done", "download_times", "plans"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE temperature = ?", ss.Temperature); err != nil {
+			return err
+		}
+	}
+	//	A session may include frames at other temperatures, so it goes only once it has none
+	if _, err := tx.Exec("DELETE FROM sessions WHERE id NOT IN (SELECT session_id FROM frames WHERE session_id IS NOT NULL)"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	ss.sessionID = 0
+	ss.savedHistory = 0
+	return nil
+}
+
+// cameraText is the camera settings of a set as stored, or "" if there are none
+func cameraText(camera config.CameraSettings) string {
+	if camera.IsEmpty() {
+		return ""
+	}
+	return camera.String()
+}
+
+// nullableTemperature stores a temperature that may not have been readable
+func nullableTemperature(temperature *float64) sql.NullFloat64 {
+	if temperature == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *temperature, Valid: true}
+}
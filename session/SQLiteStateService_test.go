@@ -0,0 +1,253 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStateService(t *testing.T) {
+
+	//	newService returns a service for the given temperature, in a new database unless one is given
+	newService := func(t *testing.T, stateFilePath string, temperature float64) *SQLiteStateService {
+		if stateFilePath == "" {
+			stateFilePath = filepath.Join(t.TempDir(), "library")
+		}
+		return NewSQLiteStateService(stateFilePath, temperature).(*SQLiteStateService)
+	}
+	//	query runs a query directly on a service's database, returning the single value it selects
+	query := func(t *testing.T, service *SQLiteStateService, sqlQuery string, result interface{}) {
+		db, err := sql.Open("sqlite", service.DatabasePath)
+		require.Nil(t, err)
+		defer func() { _ = db.Close() }()
+		require.Nil(t, db.QueryRow(sqlQuery).Scan(result))
+	}
+	exec := func(t *testing.T, service *SQLiteStateService, statement string) {
+		db, err := sql.Open("sqlite", service.DatabasePath)
+		require.Nil(t, err)
+		defer func() { _ = db.Close() }()
+		_, err = db.Exec(statement)
+		require.Nil(t, err)
+	}
+	before, after := -10.2, -9.9
+	samplePlan := func(temperature float64) *CapturePlan {
+		return &CapturePlan{
+			Version:           StateFileVersion,
+			Temperature:       temperature,
+			DarksRequired:     []string{"20,300,1", "10,60,2,gain=100"},
+			BiasRequired:      []string{"32,1"},
+			FlatDarksRequired: []string{},
+			DarksDone:         map[string]int{MakeDarkKey(300, 1, temperature, config.CameraSettings{}): 20},
+			BiasDone:          map[string]int{MakeBiasKey(1, temperature, config.CameraSettings{}): 5},
+			FlatDarksDone:     map[string]int{},
+			DownloadTimes:     map[int]float64{1: 6.5, 2: 0},
+			History: []FrameRecord{
+				{Time: time.Date(2026, 3, 1, 22, 0, 0, 123, time.UTC), FrameType: "Dark",
+					Key: MakeDarkKey(300, 1, temperature, config.CameraSettings{}), Exposure: 300, Binning: 1,
+					TemperatureBefore: &before, TemperatureAfter: &after, DownloadSeconds: 6.5, Result: FrameResultOK},
+				{Time: time.Date(2026, 3, 1, 22, 5, 0, 0, time.UTC), FrameType: "Bias",
+					Key: MakeBiasKey(1, temperature, config.CameraSettings{}), Binning: 1,
+					DownloadSeconds: 6.5, Result: "camera error 206"},
+			},
+		}
+	}
+
+	t.Run("no database is no plan, and isn't created by reading", func(t *testing.T) {
+		service := newService(t, "", -10)
+		plan, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Nil(t, plan)
+		require.Nil(t, service.DeleteStateFile())
+		_, err = os.Stat(service.DatabasePath)
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("plan is read back as saved", func(t *testing.T) {
+		service := newService(t, "", -10)
+		require.Nil(t, service.SavePlanToFile(samplePlan(-10)))
+		plan, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, samplePlan(-10), plan)
+	})
+
+	t.Run("each temperature is kept separately, in one database", func(t *testing.T) {
+		warm := newService(t, "", -5)
+		cold := newService(t, filepath.Join(filepath.Dir(warm.DatabasePath), "library"), -10)
+		require.Equal(t, warm.DatabasePath, cold.DatabasePath)
+		require.Nil(t, warm.SavePlanToFile(samplePlan(-5)))
+		require.Nil(t, cold.SavePlanToFile(samplePlan(-10)))
+
+		plan, err := warm.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, samplePlan(-5), plan)
+		require.Nil(t, cold.DeleteStateFile())
+		plan, err = cold.ReadStateFile()
+		require.Nil(t, err)
+		require.Nil(t, plan, "Deleted temperature should have no plan")
+		plan, err = warm.ReadStateFile()
+		require.Nil(t, err)
+		require.NotNil(t, plan, "Other temperature should be kept")
+		require.Len(t, plan.History, 2)
+	})
+
+	t.Run("saving replaces the counts and adds only new history", func(t *testing.T) {
+		service := newService(t, "", -10)
+		plan := samplePlan(-10)
+		require.Nil(t, service.SavePlanToFile(plan))
+		plan.ClearDoneCounts()
+		plan.History = append(plan.History, FrameRecord{Time: time.Now(), FrameType: "Dark",
+			Key: MakeDarkKey(300, 1, -10, config.CameraSettings{}), Exposure: 300, Binning: 1, Result: FrameResultOK})
+		require.Nil(t, service.SavePlanToFile(plan))
+
+		saved, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 0, saved.DarksDone[MakeDarkKey(300, 1, -10, config.CameraSettings{})])
+		require.Len(t, saved.History, 3)
+		var sessions int
+		query(t, service, "SELECT COUNT(*) FROM sessions", &sessions)
+		require.Equal(t, 1, sessions, "One service's frames should all be in one session")
+
+		//	A later run, with its own service, is a new session
+		laterRun := newService(t, filepath.Join(filepath.Dir(service.DatabasePath), "library"), -10)
+		laterPlan := samplePlan(-10)
+		laterPlan.History = nil
+		require.Nil(t, laterRun.UpdatePlanFromFile(laterPlan))
+		require.Len(t, laterPlan.History, 3)
+		laterPlan.History = append(laterPlan.History, FrameRecord{Time: time.Now(), FrameType: "Bias",
+			Key: MakeBiasKey(1, -10, config.CameraSettings{}), Binning: 1, Result: FrameResultOK})
+		require.Nil(t, laterRun.SavePlanToFile(laterPlan))
+		query(t, service, "SELECT COUNT(*) FROM sessions", &sessions)
+		require.Equal(t, 2, sessions)
+		var frames int
+		query(t, service, "SELECT COUNT(*) FROM frames", &frames)
+		require.Equal(t, 4, frames)
+	})
+
+	t.Run("one session for each capture run, whatever its temperatures", func(t *testing.T) {
+		runStarted := time.Now()
+		warm := newService(t, "", -5)
+		cold := newService(t, filepath.Join(filepath.Dir(warm.DatabasePath), "library"), -10)
+		for _, service := range []*SQLiteStateService{warm, cold} {
+			service.SetRunStarted(runStarted)
+			require.Nil(t, service.SavePlanToFile(samplePlan(service.Temperature)))
+		}
+		var sessions, frames int
+		query(t, warm, "SELECT COUNT(*) FROM sessions", &sessions)
+		require.Equal(t, 1, sessions)
+		query(t, warm, "SELECT COUNT(DISTINCT session_id) FROM frames", &frames)
+		require.Equal(t, 1, frames)
+
+		//	The session stays while another temperature still has frames in it
+		require.Nil(t, warm.DeleteStateFile())
+		query(t, cold, "SELECT COUNT(*) FROM sessions", &sessions)
+		require.Equal(t, 1, sessions)
+		require.Nil(t, cold.DeleteStateFile())
+		query(t, cold, "SELECT COUNT(*) FROM sessions", &sessions)
+		require.Equal(t, 0, sessions)
+	})
+
+	t.Run("reading doesn't wait for a capture's write", func(t *testing.T) {
+		service := newService(t, "", -10)
+		require.Nil(t, service.SavePlanToFile(samplePlan(-10)))
+		db, err := sql.Open("sqlite", service.DatabasePath)
+		require.Nil(t, err)
+		defer func() { _ = db.Close() }()
+		conn, err := db.Conn(context.Background())
+		require.Nil(t, err)
+		defer func() { _ = conn.Close() }()
+		_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+		require.Nil(t, err)
+		defer func() { _, _ = conn.ExecContext(context.Background(), "ROLLBACK") }()
+
+		started := time.Now()
+		plan, err := newService(t, service.DatabasePath[:len(service.DatabasePath)-len(sqliteSuffix)], -10).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, samplePlan(-10), plan)
+		require.Less(t, time.Since(started), time.Second, "Reading shouldn't wait for the write lock")
+	})
+
+	t.Run("incomplete sets can be queried", func(t *testing.T) {
+		service := newService(t, "", -10)
+		require.Nil(t, service.SavePlanToFile(samplePlan(-10)))
+		var incomplete, remaining int
+		query(t, service, "SELECT COUNT(*) FROM set_progress WHERE temperature = -10 AND remaining > 0", &incomplete)
+		require.Equal(t, 2, incomplete)
+		query(t, service, "SELECT remaining FROM set_progress WHERE temperature = -10 AND key = '"+
+			MakeBiasKey(1, -10, config.CameraSettings{})+"'", &remaining)
+		require.Equal(t, 27, remaining)
+	})
+
+	t.Run("database from a newer version is refused", func(t *testing.T) {
+		service := newService(t, "", -10)
+		require.Nil(t, service.SavePlanToFile(samplePlan(-10)))
+		exec(t, service, "PRAGMA user_version = 99")
+		_, err := service.ReadStateFile()
+		require.ErrorIs(t, err, ErrStateFileTooNew)
+	})
+
+	t.Run("readers see consistent plans while a capture writes", func(t *testing.T) {
+		writer := newService(t, "", -10)
+		reader := newService(t, filepath.Join(filepath.Dir(writer.DatabasePath), "library"), -10)
+		plan := samplePlan(-10)
+		plan.History = nil
+		key := MakeBiasKey(1, -10, config.CameraSettings{})
+		plan.BiasDone[key] = 0
+		require.Nil(t, writer.SavePlanToFile(plan))
+
+		var wait sync.WaitGroup
+		var writeErr error
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for done := 1; done <= 30 && writeErr == nil; done++ {
+				plan.BiasDone[key] = done
+				plan.History = append(plan.History, FrameRecord{Time: time.Now(), FrameType: "Bias", Key: key,
+					Binning: 1, Result: FrameResultOK})
+				writeErr = writer.SavePlanToFile(plan)
+			}
+		}()
+		for read := 0; read < 30; read++ {
+			saved, err := reader.ReadStateFile()
+			require.Nil(t, err)
+			require.Equal(t, saved.BiasDone[key], len(saved.History), "Counts and history should come from the same save")
+		}
+		wait.Wait()
+		require.Nil(t, writeErr)
+	})
+}
+
+func TestCaptureWithSQLiteStateStore(t *testing.T) {
+	viper.Set(config.CoolWaitMinutesSetting, 30)
+	viper.Set(config.StateStoreSetting, config.StateStoreSQLite)
+	t.Cleanup(func() { viper.Set(config.StateStoreSetting, config.StateStoreFile) })
+
+	session, stateFilePath := newTemperatureTestSession(t)
+	session.SetStateFileServiceFactory(NewStateStore)
+	require.Nil(t, session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-5, -10}, []string{"2,1"}, []string{"3,60,1"}, nil))
+
+	entries, err := os.ReadDir(filepath.Dir(stateFilePath))
+	require.Nil(t, err)
+	for _, entry := range entries {
+		require.Contains(t, entry.Name(), "library.db", "All temperatures should be kept in the database")
+	}
+	for _, temperature := range []float64{-5, -10} {
+		plan, err := NewStateStore(stateFilePath, temperature).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 3, plan.DarksDone[MakeDarkKey(60, 1, temperature, config.CameraSettings{})])
+		require.Equal(t, 2, plan.BiasDone[MakeBiasKey(1, temperature, config.CameraSettings{})])
+		require.Len(t, plan.History, 5)
+	}
+	db, err := sql.Open("sqlite", stateFilePath+sqliteSuffix)
+	require.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	var sessions int
+	require.Nil(t, db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&sessions))
+	require.Equal(t, 1, sessions, "Both temperatures were captured in one run")
+}
@@ -4,16 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/spf13/viper"
 	"goskydarks/config"
+	"goskydarks/logging"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // StateFileService abstracts reading and writing capture plan information to a state file.
 // It is packaged as a separate service, so it can be mocked for testing
+//
+// The state file is written to a temporary file first, then renamed into place, so a crash or power
+// cut mid-write leaves the previous state file intact.  The last good state file is also kept as a
+// backup copy (the state file path plus ".bak"), which is read instead if the state file is damaged.
 
 var mutex sync.Mutex
 
@@ -21,56 +27,133 @@ type StateFileService interface {
 	SavePlanToFile(plan *CapturePlan) error
 	UpdatePlanFromFile(plan *CapturePlan) error
 	ReadStateFile() (*CapturePlan, error)
+	DeleteStateFile() error
 }
 
+// runRecorder is a state service that groups the frames it records by capture run, which may
+// span several cooling temperatures, each with its own service
+type runRecorder interface {
+	SetRunStarted(started time.Time)
+}
+
+// backupSuffix is added to the state file path to name its backup copy
+const backupSuffix = ".bak"
+
 type StateFileServiceInstance struct {
 	StateFilePathInput string
-	StateFilePath      string // includes temperature
+	StateFilePath      string  // includes temperature
+	Temperature        float64 // cooling temperature the state file is for
+	savedHistory       int     // leading records of the plan's history known to be in the history log
+	logger             *logging.Logger
 }
 
 func NewStateFileService(stateFilePath string, temperature float64) StateFileService {
-	service := &StateFileServiceInstance{}
+	service := &StateFileServiceInstance{Temperature: temperature, logger: logging.Default()}
 	service.StateFilePathInput = stateFilePath
 	tempAsString := strconv.FormatFloat(temperature, 'f', 3, 64)
 	service.StateFilePath = stateFilePath + "_" + strings.ReplaceAll(tempAsString, ".", "_") + ".state"
 	return service
 }
 
+// NewStateStore makes the state service chosen by the state store setting: a state file for the
+// temperature, or the temperature's part of the SQLite database
+func NewStateStore(stateFilePath string, temperature float64) StateFileService {
+	if config.UseSQLiteStateStore() {
+		return NewSQLiteStateService(stateFilePath, temperature)
+	}
+	return NewStateFileService(stateFilePath, temperature)
+}
+
+// SetLogger replaces the logger used for diagnostic output
+func (sfs *StateFileServiceInstance) SetLogger(logger *logging.Logger) {
+	sfs.logger = logger
+}
+
 func (sfs *StateFileServiceInstance) SavePlanToFile(capturePlan *CapturePlan) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("StateFileService/SavePlanToFile()")
-		fmt.Printf("  Plan: %#v\n", capturePlan)
+	sfs.logger.Debugf("StateFileService/SavePlanToFile()")
+	sfs.logger.Debugf("  Plan: %#v", capturePlan)
+
+	//	The history starts with the records read from or saved to the log, so only those after them
+	//	are added.  They are added first, as a crash before the state file is written then leaves
+	//	them logged twice at worst, rather than lost.
+	newRecords := capturePlan.History
+	if sfs.savedHistory <= len(capturePlan.History) {
+		newRecords = capturePlan.History[sfs.savedHistory:]
 	}
-	jsonBytes, err := json.MarshalIndent(capturePlan, "", "   ")
+	if err := appendHistoryLog(sfs.HistoryFilePath(), newRecords); err != nil {
+		sfs.logger.Errorf("Unable to add to history log: %v", err)
+		return err
+	}
+	sfs.savedHistory = len(capturePlan.History)
+
+	versionedPlan := *capturePlan
+	versionedPlan.Version = StateFileVersion
+	versionedPlan.History = nil
+	jsonBytes, err := json.MarshalIndent(&versionedPlan, "", "   ")
 	if err != nil {
-		fmt.Println("Error in Session saveCapturePlan, marshalling plan:", err)
+		sfs.logger.Errorf("Error in Session saveCapturePlan, marshalling plan: %v", err)
 		return err
 	}
 	//fmt.Println("\n\n***\n\nJSON to save to file:", string(jsonBytes))
 
-	file, err := os.OpenFile(sfs.StateFilePath, os.O_CREATE|os.O_WRONLY, 0644)
+	//	Keep the state file being replaced as the backup, if it is good.  Failing to is only a warning,
+	//	as the new state matters more than the old
+	if previous, err := os.ReadFile(sfs.StateFilePath); err == nil && json.Valid(previous) {
+		if err := writeFileAtomically(sfs.BackupFilePath(), previous); err != nil {
+			sfs.logger.Minimalf("Warning: could not save backup of state file: %v", err)
+		}
+	}
+	if err := writeFileAtomically(sfs.StateFilePath, jsonBytes); err != nil {
+		sfs.logger.Errorf("Unable to write new state data file: %v", err)
+		return err
+	}
+
+	sfs.logger.Tracef("SavePlanToFile exits")
+	return nil
+}
+
+// BackupFilePath is the path of the copy of the last good state file
+func (sfs *StateFileServiceInstance) BackupFilePath() string {
+	return sfs.StateFilePath + backupSuffix
+}
+
+// writeFileAtomically replaces a file's contents so that, whatever happens, the file holds either
+// its old contents or the new ones: the data is written to a temporary file in the same directory,
+// flushed to disk, then renamed over the file.
+func writeFileAtomically(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
-		fmt.Println("Could not open file to write data:", err)
 		return err
 	}
-	defer func(file *os.File) {
+	tempPath := file.Name()
+	defer func() {
+		//	Once renamed, the temporary file no longer exists and this does nothing
+		_ = os.Remove(tempPath)
+	}()
+	if _, err := file.Write(data); err != nil {
 		_ = file.Close()
-	}(file)
-	numWritten, err := file.Write(jsonBytes)
-	if err != nil {
-		fmt.Println("Unable to write new state data file")
 		return err
 	}
-	if numWritten != len(jsonBytes) {
-		fmt.Printf("Expected to write %d bytes to state file, but actually wrote %d bytes.\n",
-			len(jsonBytes), numWritten)
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
 	}
-
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 5 {
-		fmt.Println("SavePlanToFile exits")
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+	//	Flush the rename too, where the system allows syncing a directory
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
 	}
 	return nil
 }
@@ -78,92 +161,148 @@ func (sfs *StateFileServiceInstance) SavePlanToFile(capturePlan *CapturePlan) er
 func (sfs *StateFileServiceInstance) UpdatePlanFromFile(capturePlan *CapturePlan) error {
 	mutex.Lock()
 	defer mutex.Unlock()
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("StateFileServiceInstance/UpdatePlanFromFile()")
-		fmt.Println("   Plan:", capturePlan)
-	}
+	sfs.logger.Debugf("StateFileServiceInstance/UpdatePlanFromFile()")
+	sfs.logger.Debugf("   Plan: %v", capturePlan)
 
 	//	Read state file into a separate plan on the side.
 	//  Note that "file not found" is not an error and results in a nil stateFilePlan
 	stateFilePlan, err := sfs.ReadStateFile()
 	if err != nil {
-		fmt.Println("Error in Session updatePlanFromStateFile, reading state file:", err)
+		sfs.logger.Errorf("Error in Session updatePlanFromStateFile, reading state file: %v", err)
 		return err
 	}
 	if stateFilePlan == nil {
 		//	No state file, so nothing to update
 		return nil
 	}
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 3 {
-		fmt.Println("  Plan read from state file:", stateFilePlan)
-	}
+	sfs.logger.Verbosef("  Plan read from state file: %v", stateFilePlan)
+	mergeSavedPlan(sfs.logger, capturePlan, stateFilePlan)
+
+	sfs.logger.Debugf("UpdatePlanFromFile exits")
+	return nil
+}
 
+// mergeSavedPlan updates a plan with the progress recorded in a saved one: the larger of each
+// done count and download time, and the saved history before the plan's own
+func mergeSavedPlan(logger *logging.Logger, capturePlan *CapturePlan, savedPlan *CapturePlan) {
 	//	Update counts of what is already done
 	for key, count := range capturePlan.BiasDone {
-		stateFileCount := stateFilePlan.BiasDone[key]
+		stateFileCount := savedPlan.BiasDone[key]
 		if stateFileCount > count {
-			if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 3 {
-				fmt.Printf("  Replacing BiasDone[%s] %d with %d\n", key, count, stateFileCount)
-			}
+			logger.Verbosef("  Replacing BiasDone[%s] %d with %d", key, count, stateFileCount)
 			capturePlan.BiasDone[key] = stateFileCount
 		}
 	}
 	for key, count := range capturePlan.DarksDone {
-		stateFileCount := stateFilePlan.DarksDone[key]
+		stateFileCount := savedPlan.DarksDone[key]
 		if stateFileCount > count {
-			if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 3 {
-				fmt.Printf("  Replacing DarksDone[%s] %d with %d\n", key, count, stateFileCount)
-			}
+			logger.Verbosef("  Replacing DarksDone[%s] %d with %d", key, count, stateFileCount)
 			capturePlan.DarksDone[key] = stateFileCount
 		}
 	}
+	for key, count := range capturePlan.FlatDarksDone {
+		stateFileCount := savedPlan.FlatDarksDone[key]
+		if stateFileCount > count {
+			logger.Verbosef("  Replacing FlatDarksDone[%s] %d with %d", key, count, stateFileCount)
+			capturePlan.FlatDarksDone[key] = stateFileCount
+		}
+	}
+
+	//	Keep the history of earlier runs
+	capturePlan.History = append(savedPlan.History, capturePlan.History...)
 
 	//	Update download times
 	for binning, downloadTime := range capturePlan.DownloadTimes {
-		stateFileTime := stateFilePlan.DownloadTimes[binning]
+		stateFileTime := savedPlan.DownloadTimes[binning]
 		if stateFileTime > downloadTime {
-			if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 3 {
-				fmt.Printf("  Replacing DownloadTime[%d] %g with %g\n", binning, downloadTime, stateFileTime)
-			}
+			logger.Verbosef("  Replacing DownloadTime[%d] %g with %g", binning, downloadTime, stateFileTime)
 			capturePlan.DownloadTimes[binning] = stateFileTime
 		}
 	}
-
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("UpdatePlanFromFile exits")
-	}
-	return nil
 }
 
 func (sfs *StateFileServiceInstance) ReadStateFile() (*CapturePlan, error) {
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Printf("ReadStateFile.  Path: %s\n", sfs.StateFilePath)
-	}
+	sfs.logger.Debugf("ReadStateFile.  Path: %s", sfs.StateFilePath)
 
 	//	See if file exists
 	_, err := os.Stat(sfs.StateFilePath)
 	if errors.Is(err, os.ErrNotExist) {
-		if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-			fmt.Println("State file does not exist")
-		}
+		sfs.logger.Debugf("State file does not exist")
 		return nil, nil
 	}
 
+	stateFilePlan, err := sfs.readPlan(sfs.StateFilePath)
+	if errors.Is(err, ErrStateFileTooNew) {
+		//	The backup may be older, but using it would lose progress the newer program recorded
+		return nil, fmt.Errorf("%s: %w", sfs.StateFilePath, err)
+	}
+	if err != nil {
+		//	A damaged state file, e.g. from a crash before writes were atomic, falls back to the backup
+		backupPlan, backupErr := sfs.readPlan(sfs.BackupFilePath())
+		if backupErr != nil {
+			return nil, fmt.Errorf("state file %s: %w (backup: %v)", sfs.StateFilePath, err, backupErr)
+		}
+		sfs.logger.Minimalf("Warning: state file %s is damaged (%v); using the last good copy, %s",
+			sfs.StateFilePath, err, sfs.BackupFilePath())
+		stateFilePlan = backupPlan
+	}
+
+	//	A state file from before the history log has its history in it, which moves to the log when
+	//	the plan is next saved.  If the log has records, that save was under way, and they include it.
+	loggedRecords, unreadable, err := readHistoryLog(sfs.HistoryFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", sfs.HistoryFilePath(), err)
+	}
+	if unreadable > 0 {
+		sfs.logger.Minimalf("Warning: skipped %d damaged line(s) of history log %s", unreadable, sfs.HistoryFilePath())
+	}
+	sfs.savedHistory = len(loggedRecords)
+	if len(loggedRecords) > 0 {
+		stateFilePlan.History = loggedRecords
+	} else if stateFilePlan.History == nil {
+		stateFilePlan.History = []FrameRecord{}
+	}
+
+	sfs.logger.Debugf("ReadStateFile exits")
+	return stateFilePlan, nil
+}
+
+// readPlan reads and unmarshals one state file, upgrading it if it is from an older version
+func (sfs *StateFileServiceInstance) readPlan(path string) (*CapturePlan, error) {
 	//	Read file into json string
-	fileContentsBytes, err := os.ReadFile(sfs.StateFilePath)
+	fileContentsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 	fileContents := string(fileContentsBytes)
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Printf("  Read %d bytes: %s", len(fileContents), fileContents)
+	sfs.logger.Debugf("  Read %d bytes: %s", len(fileContents), fileContents)
+	fileContentsBytes, version, err := migrateStateFile(fileContentsBytes, sfs.Temperature)
+	if err != nil {
+		return nil, err
+	}
+	if version != StateFileVersion {
+		sfs.logger.Informativef("Upgrading state file %s from version %d to %d", path, version, StateFileVersion)
 	}
 	//	Unmarshall JSON to data structure
-	var stateFilePlan = &CapturePlan{}
-	err = json.Unmarshal([]byte(fileContents), stateFilePlan)
-	if err != nil {
-		return nil, errors.New("error unmarshalling state file")
+	var plan = &CapturePlan{}
+	if err := json.Unmarshal(fileContentsBytes, plan); err != nil {
+		return nil, err
 	}
+	return plan, nil
+}
 
-	if viper.GetBool(config.DebugSetting) || viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("ReadStateFile exits")
+// DeleteStateFile removes the state file, its backup and its history log, so the next capture
+// starts from scratch.  A state file that doesn't exist is not an error.
+func (sfs *StateFileServiceInstance) DeleteStateFile() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sfs.logger.Debugf("DeleteStateFile.  Path: %s", sfs.StateFilePath)
+	for _, path := range []string{sfs.StateFilePath, sfs.BackupFilePath(), sfs.HistoryFilePath()} {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
 	}
-	return stateFilePlan, nil
+	sfs.savedHistory = 0
+	return nil
 }
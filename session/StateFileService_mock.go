@@ -33,6 +33,20 @@ func (m *MockStateFileService) EXPECT() *MockStateFileServiceMockRecorder {
 	return m.recorder
 }
 
+// DeleteStateFile mocks base method.
+func (m *MockStateFileService) DeleteStateFile() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteStateFile")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteStateFile indicates an expected call of DeleteStateFile.
+func (mr *MockStateFileServiceMockRecorder) DeleteStateFile() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteStateFile", reflect.TypeOf((*MockStateFileService)(nil).DeleteStateFile))
+}
+
 // ReadStateFile mocks base method.
 func (m *MockStateFileService) ReadStateFile() (*CapturePlan, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateFileWrites(t *testing.T) {
+
+	//	newService returns a state file service for a state file in a new directory
+	newService := func(t *testing.T) *StateFileServiceInstance {
+		return NewStateFileService(filepath.Join(t.TempDir(), "state"), -10).(*StateFileServiceInstance)
+	}
+	planWithDarks := func(done int) *CapturePlan {
+		return &CapturePlan{DarksDone: map[string]int{"d1": done}}
+	}
+
+	t.Run("shorter plan replaces a longer one completely", func(t *testing.T) {
+		service := newService(t)
+		long := planWithDarks(1)
+		long.BiasDone = map[string]int{"a very long bias key to make the file longer": 5}
+		require.Nil(t, service.SavePlanToFile(long))
+		require.Nil(t, service.SavePlanToFile(planWithDarks(2)))
+		plan, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 2, plan.DarksDone["d1"])
+		require.Empty(t, plan.BiasDone)
+	})
+
+	t.Run("previous state is kept as the backup, and no temporary files are left", func(t *testing.T) {
+		service := newService(t)
+		require.Nil(t, service.SavePlanToFile(planWithDarks(1)))
+		_, err := os.Stat(service.BackupFilePath())
+		require.True(t, os.IsNotExist(err), "First save has nothing to back up")
+
+		require.Nil(t, service.SavePlanToFile(planWithDarks(2)))
+		backup, err := service.readPlan(service.BackupFilePath())
+		require.Nil(t, err)
+		require.Equal(t, 1, backup.DarksDone["d1"])
+
+		entries, err := os.ReadDir(filepath.Dir(service.StateFilePath))
+		require.Nil(t, err)
+		require.Len(t, entries, 2, "Only the state file and its backup should remain")
+	})
+
+	t.Run("damaged state file falls back to the backup", func(t *testing.T) {
+		service := newService(t)
+		require.Nil(t, service.SavePlanToFile(planWithDarks(1)))
+		require.Nil(t, service.SavePlanToFile(planWithDarks(2)))
+		require.Nil(t, os.WriteFile(service.StateFilePath, []byte(`{"DarksDone": {"d1": 3`), 0644))
+		plan, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 1, plan.DarksDone["d1"])
+	})
+
+	t.Run("damaged state file is not used as the backup", func(t *testing.T) {
+		service := newService(t)
+		require.Nil(t, service.SavePlanToFile(planWithDarks(1)))
+		require.Nil(t, service.SavePlanToFile(planWithDarks(2)))
+		require.Nil(t, os.WriteFile(service.StateFilePath, []byte("garbage"), 0644))
+		require.Nil(t, service.SavePlanToFile(planWithDarks(3)))
+		backup, err := service.readPlan(service.BackupFilePath())
+		require.Nil(t, err)
+		require.Equal(t, 1, backup.DarksDone["d1"])
+	})
+
+	t.Run("damaged state file with no good backup is an error", func(t *testing.T) {
+		service := newService(t)
+		require.Nil(t, os.WriteFile(service.StateFilePath, []byte("garbage"), 0644))
+		_, err := service.ReadStateFile()
+		var syntaxErr *json.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr, "The state file's own error should be kept")
+		require.ErrorContains(t, err, service.StateFilePath)
+		require.ErrorContains(t, err, "(backup: open "+service.BackupFilePath())
+	})
+
+	t.Run("history is added to its log, not the state file", func(t *testing.T) {
+		service := newService(t)
+		plan := planWithDarks(1)
+		plan.History = []FrameRecord{{Time: time.Now(), FrameType: "Dark", Key: "d1", Result: FrameResultOK}}
+		require.Nil(t, service.SavePlanToFile(plan))
+		plan.History = append(plan.History, FrameRecord{Time: time.Now(), FrameType: "Dark", Key: "d1", Result: "failed"})
+		require.Nil(t, service.SavePlanToFile(plan))
+
+		contents, err := os.ReadFile(service.StateFilePath)
+		require.Nil(t, err)
+		require.NotContains(t, string(contents), "History")
+		logged, err := os.ReadFile(service.HistoryFilePath())
+		require.Nil(t, err)
+		require.Equal(t, 2, strings.Count(string(logged), "\n"), "Each record should be logged once")
+
+		//	A later run adds to what it read
+		laterRun := newService(t)
+		laterRun.StateFilePath = service.StateFilePath
+		later := planWithDarks(0)
+		require.Nil(t, laterRun.UpdatePlanFromFile(later))
+		later.History = append(later.History, FrameRecord{Time: time.Now(), FrameType: "Dark", Key: "d1", Result: FrameResultOK})
+		require.Nil(t, laterRun.SavePlanToFile(later))
+		saved, err := laterRun.ReadStateFile()
+		require.Nil(t, err)
+		require.Len(t, saved.History, 3)
+		require.Equal(t, "failed", saved.History[1].Result)
+	})
+
+	t.Run("history in an older state file moves to the log", func(t *testing.T) {
+		service := newService(t)
+		require.Nil(t, os.WriteFile(service.StateFilePath, []byte(`{"Version": 4, "DarksDone": {"d1": 1},
+			"History": [{"Time": "2026-01-02T03:04:05Z", "FrameType": "Dark", "Key": "d1", "Result": "ok"}]}`), 0644))
+		plan, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Len(t, plan.History, 1)
+		require.Nil(t, service.SavePlanToFile(plan))
+
+		contents, err := os.ReadFile(service.StateFilePath)
+		require.Nil(t, err)
+		require.NotContains(t, string(contents), "History")
+		plan, err = service.ReadStateFile()
+		require.Nil(t, err)
+		require.Len(t, plan.History, 1)
+	})
+
+	t.Run("history log line cut short by a crash is skipped", func(t *testing.T) {
+		service := newService(t)
+		plan := planWithDarks(1)
+		plan.History = []FrameRecord{{Time: time.Now(), FrameType: "Dark", Key: "d1", Result: FrameResultOK}}
+		require.Nil(t, service.SavePlanToFile(plan))
+		log, err := os.OpenFile(service.HistoryFilePath(), os.O_WRONLY|os.O_APPEND, 0644)
+		require.Nil(t, err)
+		_, err = log.WriteString(`{"Time": "2026-01-02T03:04:05Z", "Fra`)
+		require.Nil(t, err)
+		require.Nil(t, log.Close())
+		plan.History = append(plan.History, FrameRecord{Time: time.Now(), FrameType: "Dark", Key: "d1", Result: "failed"})
+		require.Nil(t, service.SavePlanToFile(plan))
+
+		saved, err := service.ReadStateFile()
+		require.Nil(t, err)
+		require.Len(t, saved.History, 2)
+		require.Equal(t, "failed", saved.History[1].Result)
+	})
+
+	t.Run("delete removes the backup and history too", func(t *testing.T) {
+		service := newService(t)
+		require.Nil(t, service.SavePlanToFile(planWithDarks(1)))
+		plan := planWithDarks(2)
+		plan.History = []FrameRecord{{Time: time.Now(), FrameType: "Dark", Key: "d1", Result: FrameResultOK}}
+		require.Nil(t, service.SavePlanToFile(plan))
+		require.Nil(t, service.DeleteStateFile())
+		entries, err := os.ReadDir(filepath.Dir(service.StateFilePath))
+		require.Nil(t, err)
+		require.Empty(t, entries)
+	})
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goskydarks/config"
+)
+
+//	Frame sets may ask for camera gain, offset and readout mode (see config.CameraSettings).  These
+//	are set on the camera before the first frame of the set, and again whenever a frame from a set
+//	with different settings follows, as in round-robin order.  Sets that don't ask for settings
+//	leave the camera as it is.
+
+// CameraSettingsService is implemented by a server service that can set the camera's gain, offset
+// and readout mode.  goTheSkyX's service doesn't offer these, so NewSession adds them to it (see
+// cameraSettingsTheSkyService).
+type CameraSettingsService interface {
+	SetCameraGain(gain int) error
+	SetCameraOffset(offset int) error
+	SetCameraReadoutMode(mode string) error
+}
+
+// ErrCameraSettingsUnsupported is returned when frame sets ask for camera settings the server
+// service can't set.  It is found before any frames are captured.
+var ErrCameraSettingsUnsupported = errors.New("camera gain, offset and readout mode can't be set with this server")
+
+// EventCameraSettings is written to the event log when camera settings are changed
+const EventCameraSettings = "camera_settings"
+
+// cameraNote describes a set's camera settings for progress output, e.g. ", gain 100, offset 30"
+func cameraNote(camera config.CameraSettings) string {
+	if camera.IsEmpty() {
+		return ""
+	}
+	return ", " + camera.String()
+}
+
+// checkCameraSettingsSupported fails if any set in the plan asks for camera settings and the
+// server service can't set them
+func (s *Session) checkCameraSettingsSupported(plan *CapturePlan) error {
+	if _, ok := s.theSkyService.(CameraSettingsService); ok {
+		return nil
+	}
+	progress, err := PlanProgress(plan)
+	if err != nil {
+		return err
+	}
+	for _, set := range progress {
+		if !set.Camera.IsEmpty() && set.Remaining > 0 {
+			return fmt.Errorf("%w (set %s asks for %s)", ErrCameraSettingsUnsupported, set.Key, set.Camera)
+		}
+	}
+	return nil
+}
+
+// applyCameraSettings sets the camera's gain, offset and readout mode for a set, unless it has
+// none or they are already what the camera was last set to
+func (s *Session) applyCameraSettings(ctx context.Context, camera config.CameraSettings) error {
+	if camera.IsEmpty() || (s.cameraSettingsSet && s.cameraSettings.String() == camera.String()) {
+		return nil
+	}
+	settingsService, ok := s.theSkyService.(CameraSettingsService)
+	if !ok {
+		return fmt.Errorf("%w (asked for %s)", ErrCameraSettingsUnsupported, camera)
+	}
+	s.logger.Minimalf("Setting camera: %s", camera)
+	if camera.Gain != nil {
+		if err := s.withRetry(ctx, "setting camera gain", func() error {
+			return settingsService.SetCameraGain(*camera.Gain)
+		}); err != nil {
+			return err
+		}
+	}
+	if camera.Offset != nil {
+		if err := s.withRetry(ctx, "setting camera offset", func() error {
+			return settingsService.SetCameraOffset(*camera.Offset)
+		}); err != nil {
+			return err
+		}
+	}
+	if camera.ReadoutMode != "" {
+		if err := s.withRetry(ctx, "setting camera readout mode", func() error {
+			return settingsService.SetCameraReadoutMode(camera.ReadoutMode)
+		}); err != nil {
+			return err
+		}
+	}
+	s.cameraSettings = camera
+	s.cameraSettingsSet = true
+	s.eventLog.Record(EventCameraSettings, map[string]interface{}{
+		"camera": camera.String(),
+	})
+	return nil
+}
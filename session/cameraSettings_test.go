@@ -0,0 +1,107 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/RMcDOttawa/goTheSkyX"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// plainTheSkyService hides the dry-run service's camera settings methods, like a server service without them
+type plainTheSkyService struct {
+	goTheSkyX.TheSkyService
+}
+
+func TestCameraSettings(t *testing.T) {
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, false)
+	viper.Set(config.CoolToSetting, 0.0)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+	t.Cleanup(func() {
+		viper.Set(config.CaptureOrderSetting, "")
+	})
+
+	gain := 100
+	offset := 30
+	highGain := config.CameraSettings{Gain: &gain, Offset: &offset}
+
+	newCameraSession := func(t *testing.T, output *bytes.Buffer, events *bytes.Buffer) (*Session, StateFileService) {
+		session, err := newDryRunSession(output)
+		require.Nil(t, err)
+		stateFileService := NewStateFileService(filepath.Join(t.TempDir(), "camera"), 0)
+		session.SetStateFileService(stateFileService)
+		session.SetEventLog(NewEventLogWriter(events))
+		require.Nil(t, session.ConnectToServer())
+		return session, stateFileService
+	}
+
+	t.Run("settings are made before a set, and only when they change", func(t *testing.T) {
+		viper.Set(config.CaptureOrderSetting, config.CaptureOrderRoundRobin)
+		var output, events bytes.Buffer
+		session, stateFileService := newCameraSession(t, &output, &events)
+		err := session.CaptureFrames(context.Background(), true, []string{"2,1,gain=100,offset=30"},
+			[]string{"2,60,1,gain=100,offset=30", "1,60,1,gain=0,readout=Low Noise"}, nil)
+		require.Nil(t, err)
+
+		report := output.String()
+		require.Equal(t, 2, strings.Count(report, "Set camera gain 100"),
+			"Round robin goes back to the first settings after the second set, then keeps them:\n"+report)
+		require.Equal(t, 1, strings.Count(report, "Set camera gain 0"))
+		require.Equal(t, 1, strings.Count(report, `Set camera readout mode "Low Noise"`))
+
+		plan, err := stateFileService.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 2, plan.DarksDone[MakeDarkKey(60, 1, 0, highGain)])
+		require.Equal(t, 2, plan.BiasDone[MakeBiasKey(1, 0, highGain)])
+		require.Equal(t, 1, plan.DarksDone["Dark_60.0000_1_0.00C_g0_rLow Noise"])
+
+		var settingsChanges int
+		for _, event := range readEvents(t, &events) {
+			if event["event"] == EventCameraSettings {
+				settingsChanges++
+			}
+			if event["event"] == EventFrame && event["key"] == MakeDarkKey(60, 1, 0, highGain) {
+				require.Equal(t, "gain 100, offset 30", event["camera"])
+			}
+		}
+		require.Equal(t, 3, settingsChanges)
+	})
+
+	t.Run("sets in one order are set once each", func(t *testing.T) {
+		viper.Set(config.CaptureOrderSetting, "")
+		var output, events bytes.Buffer
+		session, _ := newCameraSession(t, &output, &events)
+		err := session.CaptureFrames(context.Background(), true, []string{"2,1,gain=100,offset=30"},
+			[]string{"2,60,1,gain=100,offset=30"}, nil)
+		require.Nil(t, err)
+		require.Equal(t, 1, strings.Count(output.String(), "Set camera gain 100"))
+	})
+
+	t.Run("server that can't make settings fails before capturing", func(t *testing.T) {
+		var output, events bytes.Buffer
+		session, _ := newCameraSession(t, &output, &events)
+		session.SetTheSkyService(&plainTheSkyService{session.theSkyService})
+		err := session.CaptureFrames(context.Background(), true, nil, []string{"2,60,1,gain=100"}, nil)
+		require.True(t, errors.Is(err, ErrCameraSettingsUnsupported), "Expected unsupported settings, got %v", err)
+		require.ErrorContains(t, err, "gain 100")
+		require.Equal(t, 0, session.framesCaptured)
+		require.NotContains(t, output.String(), "Measure download time")
+	})
+
+	t.Run("sets without settings don't need them", func(t *testing.T) {
+		var output, events bytes.Buffer
+		session, _ := newCameraSession(t, &output, &events)
+		session.SetTheSkyService(&plainTheSkyService{session.theSkyService})
+		require.Nil(t, session.CaptureFrames(context.Background(), true, []string{"1,1"}, []string{"1,60,1"}, nil))
+		require.Equal(t, 2, session.framesCaptured)
+	})
+}
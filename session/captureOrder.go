@@ -0,0 +1,222 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"slices"
+	"strings"
+)
+
+//	Other than the original by-type order (all darks, then all bias frames, or the reverse), the
+//	frame sets can be captured in several orders - see config.CaptureOrders.  Whatever the order,
+//	each frame is counted in the plan's done counts as it is captured, so a resumed run carries on
+//	where the last one stopped.
+
+// frameTypeExcluded tells whether frames of the given type are not to be captured, according to
+// the NoDark, NoBias and NoFlatDark settings
+func frameTypeExcluded(frameType string) bool {
+	switch frameType {
+	case "Dark":
+		return viper.GetBool(config.NoDarkSetting)
+	case "Bias":
+		return viper.GetBool(config.NoBiasSetting)
+	case "FlatDark":
+		return viper.GetBool(config.NoFlatDarkSetting)
+	}
+	return false
+}
+
+// orderFrameSets lists the sets in the plan in the order they should be captured.  Sets excluded
+// by the NoDark, NoBias and NoFlatDark settings are left out.  Sorting is stable, so sets that tie
+// keep the by-type order, with darks or bias frames first, and flat-darks first or last, as requested.
+func orderFrameSets(plan *CapturePlan, areDarksFirst bool, order string) ([]SetProgress, error) {
+	progress, err := PlanProgress(plan)
+	if err != nil {
+		return nil, err
+	}
+	var darks, bias, flatDarks []SetProgress
+	for _, set := range progress {
+		if frameTypeExcluded(set.FrameType) {
+			continue
+		}
+		switch set.FrameType {
+		case "Dark":
+			darks = append(darks, set)
+		case "Bias":
+			bias = append(bias, set)
+		case "FlatDark":
+			flatDarks = append(flatDarks, set)
+		}
+	}
+	sets := slices.Concat(darks, bias)
+	if !areDarksFirst {
+		sets = slices.Concat(bias, darks)
+	}
+	if viper.GetBool(config.FlatDarkFirstSetting) {
+		sets = slices.Concat(flatDarks, sets)
+	} else {
+		sets = slices.Concat(sets, flatDarks)
+	}
+
+	switch order {
+	case config.CaptureOrderShortestFirst:
+		slices.SortStableFunc(sets, func(a, b SetProgress) int {
+			return compareFloats(a.Exposure, b.Exposure)
+		})
+	case config.CaptureOrderLongestFirst:
+		slices.SortStableFunc(sets, func(a, b SetProgress) int {
+			return compareFloats(b.Exposure, a.Exposure)
+		})
+	case config.CaptureOrderByBinning:
+		slices.SortStableFunc(sets, func(a, b SetProgress) int {
+			return a.Binning - b.Binning
+		})
+	}
+	return sets, nil
+}
+
+// frameTypeName is the frame type as written in messages, e.g. "flat-dark" for "FlatDark"
+func frameTypeName(frameType string) string {
+	if frameType == "FlatDark" {
+		return "flat-dark"
+	}
+	return strings.ToLower(frameType)
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// captureFramesInOrder captures the frames still needed in the plan, in the given order.  Round-robin
+// takes one frame from each unfinished set in turn; the other orders finish each set before the next.
+func (s *Session) captureFramesInOrder(ctx context.Context, areDarksFirst bool, order string, plan *CapturePlan) error {
+	sets, err := orderFrameSets(plan, areDarksFirst, order)
+	if err != nil {
+		return err
+	}
+	s.logger.Informativef("Capturing frame sets in %s order", order)
+
+	if order == config.CaptureOrderRoundRobin {
+		for round := 1; ; round++ {
+			capturedThisRound := false
+			for _, set := range sets {
+				if plan.doneCounts(set.FrameType)[set.Key] >= set.Count {
+					continue
+				}
+				if !capturedThisRound {
+					s.logger.Minimalf("Round %d: one frame from each unfinished set", round)
+				}
+				if err := s.captureSetFrame(ctx, plan, set); err != nil {
+					return err
+				}
+				capturedThisRound = true
+			}
+			if !capturedThisRound {
+				return nil
+			}
+		}
+	}
+
+	for _, set := range sets {
+		done := plan.doneCounts(set.FrameType)
+		if done[set.Key] < set.Count {
+			s.logger.Minimalf("Handling %s frames set %s: %d of %d still needed%s",
+				frameTypeName(set.FrameType), set.Key, set.Count-done[set.Key], set.Count, cameraNote(set.Camera))
+		}
+		for done[set.Key] < set.Count {
+			if err := s.captureSetFrame(ctx, plan, set); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// doneCounts returns the map of frames done, by set key, for the given type of frame
+func (p *CapturePlan) doneCounts(frameType string) map[string]int {
+	switch frameType {
+	case "Dark":
+		return p.DarksDone
+	case "FlatDark":
+		return p.FlatDarksDone
+	default:
+		return p.BiasDone
+	}
+}
+
+// captureSetFrame captures the next frame of a set, unless the session is being stopped or the camera
+// is no longer at temperature.  The frame is then counted in the plan, and the plan saved.
+func (s *Session) captureSetFrame(ctx context.Context, plan *CapturePlan, set SetProgress) error {
+	if err := s.checkStop(ctx); err != nil {
+		return err
+	}
+	frameType := frameTypeName(set.FrameType)
+	abandon, err := s.CheckAbandonForCooling(ctx)
+	if err != nil {
+		s.logger.Errorf("Error in Session captureSetFrame, checking for cooling abandon: %v", err)
+		return err
+	}
+	if abandon {
+		s.logger.Errorf("abandoning %s frame capture due to temperature exceeding cooling tolerance", frameType)
+		return fmt.Errorf("abandoning %s frame capture due to %w", frameType, ErrCoolingAbort)
+	}
+	if err := s.applyCameraSettings(ctx, set.Camera); err != nil {
+		s.logger.Errorf("Error in Session captureSetFrame, setting camera: %v", err)
+		return err
+	}
+	if err := s.applyFlatDarkTag(ctx, set.FrameType); err != nil {
+		s.logger.Errorf("Error in Session captureSetFrame, setting save prefix: %v", err)
+		return err
+	}
+
+	//	The temperature read for the cooling check, or after the previous frame, serves for the history
+	temperatureBefore := s.lastTemperature
+	if temperatureBefore == nil {
+		temperatureBefore = s.sensorTemperature(ctx)
+	}
+	done := plan.doneCounts(set.FrameType)
+	downloadTime := plan.DownloadTimes[set.Binning]
+	if set.FrameType == "Bias" {
+		s.logger.Informativef("    Capturing bias frame %d of %d, binned %d", done[set.Key]+1, set.Count, set.Binning)
+		err = s.withRetry(ctx, "capturing bias frame", func() error {
+			return s.theSkyService.CaptureBiasFrame(set.Binning, downloadTime)
+		})
+	} else {
+		//	A flat-dark is, as far as the camera is concerned, just a short dark frame
+		s.logger.Informativef("    Capturing %s frame %d of %d:  %.2f seconds binned %d",
+			frameType, done[set.Key]+1, set.Count, set.Exposure, set.Binning)
+		err = s.withRetry(ctx, "capturing "+frameType+" frame", func() error {
+			return s.theSkyService.CaptureDarkFrame(set.Binning, set.Exposure, downloadTime)
+		})
+	}
+	var temperatureAfter *float64
+	if err == nil {
+		temperatureAfter = s.sensorTemperature(ctx)
+		s.lastTemperature = temperatureAfter
+	}
+	s.recordHistory(plan, set, downloadTime, temperatureBefore, temperatureAfter, err)
+	if err != nil {
+		s.logger.Errorf("Error in Session captureSetFrame, capturing %s frame: %v", frameType, err)
+		//	Keep the failed attempt in the history, though the capture stops
+		if saveErr := s.stateFileService.SavePlanToFile(plan); saveErr != nil {
+			s.logger.Errorf("Error in Session captureSetFrame, saving plan: %v", saveErr)
+		}
+		return err
+	}
+	done[set.Key]++
+	s.recordFrame(set.FrameType, set.Key, done[set.Key], set.Exposure, set.Binning, set.Camera, temperatureAfter)
+	if err := s.stateFileService.SavePlanToFile(plan); err != nil {
+		s.logger.Errorf("Error in Session captureSetFrame, saving plan: %v", err)
+		return err
+	}
+	return nil
+}
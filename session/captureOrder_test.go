@@ -0,0 +1,87 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureOrderStrategies(t *testing.T) {
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, false)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+	t.Cleanup(func() {
+		viper.Set(config.CaptureOrderSetting, "")
+	})
+
+	darkShort := MakeDarkKey(10, 2, 0, config.CameraSettings{})
+	darkLong := MakeDarkKey(300, 1, 0, config.CameraSettings{})
+	bias := MakeBiasKey(1, 0, config.CameraSettings{})
+
+	//	captureOrder runs a capture in the given order, starting from the given done counts, and
+	//	returns the keys of the frames captured, in the order they were taken
+	captureOrder := func(t *testing.T, order string, darksFirst bool, done map[string]int) []string {
+		viper.Set(config.CaptureOrderSetting, order)
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		stateFileService := NewStateFileService(filepath.Join(t.TempDir(), "order"), 0)
+		session.SetStateFileService(stateFileService)
+		var events bytes.Buffer
+		session.SetEventLog(NewEventLogWriter(&events))
+		require.Nil(t, session.ConnectToServer())
+
+		plan, err := session.createPlanFromConfig([]string{"2,1"}, []string{"2,10,2", "1,300,1"}, nil)
+		require.Nil(t, err)
+		for key, count := range done {
+			plan.doneCounts(key[:4])[key] = count
+		}
+		require.Nil(t, session.captureFrames(context.Background(), darksFirst, plan))
+
+		var keys []string
+		for _, event := range readEvents(t, &events) {
+			if event["event"] == EventFrame {
+				keys = append(keys, event["key"].(string))
+			}
+		}
+		saved, err := stateFileService.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 2, saved.DarksDone[darkShort], "Every order finishes every set")
+		require.Equal(t, 1, saved.DarksDone[darkLong])
+		require.Equal(t, 2, saved.BiasDone[bias])
+		return keys
+	}
+
+	t.Run("by type does all darks then all bias frames", func(t *testing.T) {
+		require.Equal(t, []string{darkShort, darkShort, darkLong, bias, bias}, captureOrder(t, "", true, nil))
+		require.Equal(t, []string{bias, bias, darkShort, darkShort, darkLong}, captureOrder(t, "bytype", false, nil))
+	})
+
+	t.Run("round robin takes one frame from each set in turn", func(t *testing.T) {
+		require.Equal(t, []string{darkShort, darkLong, bias, darkShort, bias}, captureOrder(t, "roundrobin", true, nil))
+	})
+
+	t.Run("round robin skips sets already done", func(t *testing.T) {
+		require.Equal(t, []string{darkShort, bias, bias},
+			captureOrder(t, "roundrobin", true, map[string]int{darkShort: 1, darkLong: 1}))
+	})
+
+	t.Run("shortest exposure first", func(t *testing.T) {
+		require.Equal(t, []string{bias, bias, darkShort, darkShort, darkLong}, captureOrder(t, "shortestfirst", true, nil))
+	})
+
+	t.Run("longest exposure first, resuming part way", func(t *testing.T) {
+		require.Equal(t, []string{darkLong, darkShort, bias, bias},
+			captureOrder(t, "longestfirst", true, map[string]int{darkShort: 1}))
+	})
+
+	t.Run("grouped by binning", func(t *testing.T) {
+		require.Equal(t, []string{darkLong, bias, bias, darkShort, darkShort}, captureOrder(t, "bybinning", true, nil))
+	})
+}
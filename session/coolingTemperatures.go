@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"time"
+)
+
+// ErrCoolingTimeout means the camera did not reach the target temperature in the time allowed
+var ErrCoolingTimeout = errors.New("timed out waiting for target temperature")
+
+// ErrCoolingAbort means capture was abandoned because the camera drifted out of the cooling tolerance
+var ErrCoolingAbort = errors.New("temperature exceeding cooling tolerance")
+
+// UseCoolingTemperature makes the given temperature the cooling target for the rest of the
+// session, and switches to the state file that records progress at that temperature
+func (s *Session) UseCoolingTemperature(temperature float64) {
+	viper.Set(config.CoolToSetting, temperature)
+	s.lastTemperature = nil
+	s.stateFileService = s.newStateFileService(viper.GetString(config.StateFileSetting), temperature)
+	if recorder, ok := s.stateFileService.(runRecorder); ok && !s.runStarted.IsZero() {
+		recorder.SetRunStarted(s.runStarted)
+	}
+}
+
+// CaptureFramesAtTemperatures runs the whole capture plan at each cooling temperature in turn,
+// each with its progress in its own state file - e.g. to build a dark library at several set points.
+// A temperature the cooler can't reach in the time allowed is skipped, and reported in the returned
+// error once the others are done.  Any other error stops the run.
+func (s *Session) CaptureFramesAtTemperatures(
+	ctx context.Context,
+	areDarksFirst bool,
+	temperatures []float64,
+	biasFrames []string,
+	darkFrames []string,
+	flatDarkFrames []string) error {
+	var unreached []float64
+	s.runStarted = time.Now()
+	for index, temperature := range temperatures {
+		if err := s.checkStop(ctx); err != nil {
+			return err
+		}
+		if len(temperatures) > 1 {
+			s.logger.Minimalf("Capturing at cooling temperature %g (%d of %d)", temperature, index+1, len(temperatures))
+		}
+		s.UseCoolingTemperature(temperature)
+		s.eventLog.Record(EventSetpoint, map[string]interface{}{
+			"target": temperature,
+			"index":  index + 1,
+			"count":  len(temperatures),
+		})
+		err := s.CaptureFrames(ctx, areDarksFirst, biasFrames, darkFrames, flatDarkFrames)
+		if errors.Is(err, ErrCoolingTimeout) {
+			s.logger.Errorf("Could not reach cooling temperature %g, skipping it", temperature)
+			unreached = append(unreached, temperature)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(unreached) > 0 {
+		return fmt.Errorf("%w: could not reach %v", ErrCoolingTimeout, unreached)
+	}
+	return nil
+}
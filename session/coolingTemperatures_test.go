@@ -0,0 +1,81 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// newTemperatureTestSession makes a dry-run session that writes real state files, so the
+// progress recorded at each temperature can be checked
+func newTemperatureTestSession(t *testing.T) (*Session, string) {
+	stateFilePath := filepath.Join(t.TempDir(), "library")
+	viper.Set(config.StateFileSetting, stateFilePath)
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, true)
+	viper.Set(config.CoolStartTolSetting, 1.0)
+	viper.Set(config.StartPollSecondsSetting, 60)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+	viper.Set(config.NoFlatDarkSetting, false)
+
+	session, err := newDryRunSession(io.Discard)
+	require.Nil(t, err)
+	session.SetStateFileServiceFactory(NewStateFileService)
+	require.Nil(t, session.ConnectToServer())
+	return session, stateFilePath
+}
+
+func TestCaptureFramesAtTemperatures(t *testing.T) {
+
+	t.Run("full plan captured at each temperature, in its own state file", func(t *testing.T) {
+		viper.Set(config.CoolWaitMinutesSetting, 30)
+		session, stateFilePath := newTemperatureTestSession(t)
+		err := session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-5, -10}, []string{"2,1"}, []string{"3,60,1"}, nil)
+		require.Nil(t, err)
+		require.Equal(t, 10, session.framesCaptured)
+
+		for _, temperature := range []float64{-5, -10} {
+			plan, err := NewStateFileService(stateFilePath, temperature).ReadStateFile()
+			require.Nil(t, err)
+			require.NotNil(t, plan, "Each temperature should have its own state file")
+			require.Equal(t, 3, plan.DarksDone[MakeDarkKey(60, 1, temperature, config.CameraSettings{})])
+			require.Equal(t, 2, plan.BiasDone[MakeBiasKey(1, temperature, config.CameraSettings{})])
+		}
+	})
+
+	t.Run("unreachable temperature is skipped and reported", func(t *testing.T) {
+		//	The simulated camera needs several minutes to reach either temperature from the other
+		viper.Set(config.CoolWaitMinutesSetting, 5)
+		session, stateFilePath := newTemperatureTestSession(t)
+		err := session.CaptureFramesAtTemperatures(context.Background(), true, []float64{15, -30}, nil, []string{"1,10,1"}, nil)
+		require.True(t, errors.Is(err, ErrCoolingTimeout), "Expected cooling timeout, got %v", err)
+
+		plan, err := NewStateFileService(stateFilePath, 15).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 1, plan.DarksDone[MakeDarkKey(10, 1, 15, config.CameraSettings{})], "Reachable temperature should be captured")
+		plan, err = NewStateFileService(stateFilePath, -30).ReadStateFile()
+		require.Nil(t, err)
+		require.Nil(t, plan, "Nothing should be recorded at the unreachable temperature")
+	})
+
+	t.Run("raising a set's count captures only the extra frames", func(t *testing.T) {
+		viper.Set(config.CoolWaitMinutesSetting, 30)
+		session, stateFilePath := newTemperatureTestSession(t)
+		require.Nil(t, session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-10}, nil, []string{"2,60,1"}, nil))
+		require.Equal(t, 2, session.framesCaptured)
+
+		require.Nil(t, session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-10}, nil, []string{"5,60,1"}, nil))
+		require.Equal(t, 5, session.framesCaptured, "Only the 3 extra frames should be captured")
+		plan, err := NewStateFileService(stateFilePath, -10).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 5, plan.DarksDone[MakeDarkKey(60, 1, -10, config.CameraSettings{})])
+	})
+}
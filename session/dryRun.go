@@ -0,0 +1,237 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RMcDOttawa/goTheSkyX"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+//	A dry run goes through the normal capture control flow, but with services that only
+//	report what they would do.  TheSkyX is never contacted, the state file is read but not
+//	written, and delays advance a simulated clock instantly instead of waiting.
+
+// Assumptions used to make a dry run look like a real session
+const dryRunAmbientTemperature = 20.0
+const dryRunCoolingTimeConstant = 120.0 // seconds
+const dryRunFullFrameDownload = 8.0     // seconds, for binning 1
+
+// dryRunClock is the simulated time shared by the dry-run services
+type dryRunClock struct {
+	now    time.Time
+	output io.Writer
+}
+
+func (c *dryRunClock) advance(seconds float64) {
+	c.now = c.now.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+// report prints one dry-run action, stamped with the simulated time
+func (c *dryRunClock) report(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(c.output, "[dry run %s] %s\n", c.now.Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+}
+
+// NewDryRunSession creates a session whose server, delay and state file services are
+// dry-run stand-ins.  Actions are reported to standard output.
+func NewDryRunSession() (*Session, error) {
+	return newDryRunSession(os.Stdout)
+}
+
+func newDryRunSession(output io.Writer) (*Session, error) {
+	session, err := NewSession()
+	if err != nil {
+		return nil, err
+	}
+	clock := &dryRunClock{now: time.Now(), output: output}
+	session.SetDelayService(&dryRunDelayService{clock: clock})
+	session.SetTheSkyService(&dryRunTheSkyService{clock: clock, temperature: dryRunAmbientTemperature})
+	session.SetStateFileService(&dryRunStateFileService{clock: clock, stateFileService: session.stateFileService})
+	session.SetStateFileServiceFactory(func(stateFilePath string, temperature float64) StateFileService {
+		return &dryRunStateFileService{clock: clock, stateFileService: NewStateStore(stateFilePath, temperature)}
+	})
+	return session, nil
+}
+
+// dryRunDelayService advances the simulated clock rather than waiting
+type dryRunDelayService struct {
+	clock *dryRunClock
+}
+
+func (d *dryRunDelayService) DelayDuration(seconds int) (int, error) {
+	if seconds <= 0 {
+		return 0, nil
+	}
+	d.clock.report("Wait %d seconds", seconds)
+	d.clock.advance(float64(seconds))
+	return seconds, nil
+}
+
+func (d *dryRunDelayService) DelayUntil(target time.Time) error {
+	if target.After(d.clock.now) {
+		d.clock.report("Wait until %s", target.Format("2006-01-02 15:04"))
+		d.clock.now = target
+	}
+	return nil
+}
+
+func (d *dryRunDelayService) SetDebug(_ bool) {}
+
+func (d *dryRunDelayService) SetVerbosity(_ int) {}
+
+// dryRunTheSkyService reports the commands that would be sent to TheSkyX.  It models the
+// camera cooling toward its set point and the time taken by exposures and downloads.
+type dryRunTheSkyService struct {
+	clock           *dryRunClock
+	connected       bool
+	cooling         bool
+	setPoint        float64
+	temperature     float64 // at temperatureTime
+	temperatureTime time.Time
+	savePrefix      string
+}
+
+// currentTemperature brings the modelled temperature up to the current simulated time
+func (t *dryRunTheSkyService) currentTemperature() float64 {
+	goal := dryRunAmbientTemperature
+	if t.cooling {
+		goal = t.setPoint
+	}
+	if !t.temperatureTime.IsZero() {
+		elapsed := t.clock.now.Sub(t.temperatureTime).Seconds()
+		t.temperature = goal + (t.temperature-goal)*math.Exp(-elapsed/dryRunCoolingTimeConstant)
+	}
+	t.temperatureTime = t.clock.now
+	return t.temperature
+}
+
+func (t *dryRunTheSkyService) Connect(server string, port int) error {
+	t.clock.report("Connect to TheSkyX at %s:%d", server, port)
+	t.connected = true
+	return nil
+}
+
+func (t *dryRunTheSkyService) ConnectCamera() error {
+	t.clock.report("Connect camera")
+	return nil
+}
+
+func (t *dryRunTheSkyService) Close() error {
+	t.clock.report("Close connection to TheSkyX")
+	t.connected = false
+	return nil
+}
+
+func (t *dryRunTheSkyService) SetDriver(_ goTheSkyX.TheSkyDriver) {}
+
+func (t *dryRunTheSkyService) StartCooling(targetTemp float64) error {
+	t.currentTemperature()
+	t.clock.report("Start cooler with set point %.2f", targetTemp)
+	t.cooling = true
+	t.setPoint = targetTemp
+	return nil
+}
+
+func (t *dryRunTheSkyService) GetCameraTemperature() (float64, error) {
+	temperature := math.Round(t.currentTemperature()*10) / 10
+	t.clock.report("Read camera temperature: %.1f (simulated)", temperature)
+	return temperature, nil
+}
+
+func (t *dryRunTheSkyService) StopCooling() error {
+	t.currentTemperature()
+	t.clock.report("Turn cooler off")
+	t.cooling = false
+	return nil
+}
+
+func (t *dryRunTheSkyService) MeasureDownloadTime(binning int) (float64, error) {
+	downloadTime := dryRunFullFrameDownload / float64(binning*binning)
+	t.clock.report("Measure download time for binning %d (assuming %.2f seconds)", binning, downloadTime)
+	t.clock.advance(downloadTime)
+	return downloadTime, nil
+}
+
+func (t *dryRunTheSkyService) CaptureDarkFrame(binning int, seconds float64, downloadTime float64) error {
+	t.clock.report("Capture dark frame: %.2f seconds, binned %d, download %.2f seconds", seconds, binning, downloadTime)
+	t.clock.advance(seconds + downloadTime)
+	return nil
+}
+
+func (t *dryRunTheSkyService) CaptureBiasFrame(binning int, downloadTime float64) error {
+	t.clock.report("Capture bias frame: binned %d, download %.2f seconds", binning, downloadTime)
+	t.clock.advance(biasExposureSeconds + downloadTime)
+	return nil
+}
+
+func (t *dryRunTheSkyService) SetCameraGain(gain int) error {
+	t.clock.report("Set camera gain %d", gain)
+	return nil
+}
+
+func (t *dryRunTheSkyService) SetCameraOffset(offset int) error {
+	t.clock.report("Set camera offset %d", offset)
+	return nil
+}
+
+func (t *dryRunTheSkyService) SetCameraReadoutMode(mode string) error {
+	t.clock.report("Set camera readout mode %q", mode)
+	return nil
+}
+
+func (t *dryRunTheSkyService) GetSavePrefix() (string, error) {
+	t.clock.report("Read save prefix: %q", t.savePrefix)
+	return t.savePrefix, nil
+}
+
+func (t *dryRunTheSkyService) SetSavePrefix(prefix string) error {
+	t.clock.report("Set save prefix %q", prefix)
+	t.savePrefix = prefix
+	return nil
+}
+
+func (t *dryRunTheSkyService) SetDebug(_ bool) {}
+
+func (t *dryRunTheSkyService) SetVerbosity(_ int) {}
+
+func (t *dryRunTheSkyService) CaptureAndMeasureFlatFrame(_ float64, _ int, _ int, _ float64, _ bool) (int64, error) {
+	return 0, errors.New("flat frames are not used by goskydarks")
+}
+
+func (t *dryRunTheSkyService) SetSimulateFlatCapture(_ bool) {}
+
+func (t *dryRunTheSkyService) WaitForCameraInactive(_ int, _ int) error {
+	return nil
+}
+
+// dryRunStateFileService reads the real state file, so the dry run resumes where a real
+// capture would, but never writes it
+type dryRunStateFileService struct {
+	clock            *dryRunClock
+	stateFileService StateFileService
+}
+
+func (sf *dryRunStateFileService) SavePlanToFile(_ *CapturePlan) error {
+	if viper.GetInt(config.VerbositySetting) >= 3 {
+		sf.clock.report("Save progress to state file")
+	}
+	return nil
+}
+
+func (sf *dryRunStateFileService) UpdatePlanFromFile(plan *CapturePlan) error {
+	sf.clock.report("Merge progress from state file")
+	return sf.stateFileService.UpdatePlanFromFile(plan)
+}
+
+func (sf *dryRunStateFileService) ReadStateFile() (*CapturePlan, error) {
+	return sf.stateFileService.ReadStateFile()
+}
+
+func (sf *dryRunStateFileService) DeleteStateFile() error {
+	sf.clock.report("Delete state file")
+	return nil
+}
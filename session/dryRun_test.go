@@ -0,0 +1,55 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	stateFilePath := filepath.Join(t.TempDir(), "dryrun")
+	viper.Set(config.StateFileSetting, stateFilePath)
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.ServerAddressSetting, "localhost")
+	viper.Set(config.ServerPortSetting, 3040)
+	viper.Set(config.UseCoolerSetting, true)
+	viper.Set(config.CoolToSetting, -10.0)
+	viper.Set(config.CoolStartTolSetting, 1.0)
+	viper.Set(config.CoolWaitMinutesSetting, 30)
+	viper.Set(config.StartPollSecondsSetting, 60)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+
+	var output bytes.Buffer
+	session, err := newDryRunSession(&output)
+	require.Nil(t, err, "Can't create dry run session")
+	require.Nil(t, session.ConnectToServer())
+	err = session.CaptureFrames(context.Background(), true, []string{"2,1"}, []string{"3,60,1"}, nil)
+	require.Nil(t, err, "Dry run capture should succeed")
+	report := output.String()
+
+	t.Run("reports actions in capture order", func(t *testing.T) {
+		cooler := strings.Index(report, "Start cooler with set point -10.00")
+		download := strings.Index(report, "Measure download time for binning 1")
+		waiting := strings.Index(report, "Wait 60 seconds")
+		dark := strings.Index(report, "Capture dark frame: 60.00 seconds, binned 1")
+		bias := strings.Index(report, "Capture bias frame: binned 1")
+		require.True(t, cooler >= 0 && download > cooler && waiting > download && dark > waiting && bias > dark,
+			"Actions out of order:\n"+report)
+		require.Equal(t, 3, strings.Count(report, "Capture dark frame"))
+		require.Equal(t, 2, strings.Count(report, "Capture bias frame"))
+	})
+
+	t.Run("does not write the state file", func(t *testing.T) {
+		_, err := os.Stat(NewStateFileService(stateFilePath, -10.0).(*StateFileServiceInstance).StateFilePath)
+		require.True(t, os.IsNotExist(err), "Dry run should not create a state file")
+	})
+}
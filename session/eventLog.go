@@ -0,0 +1,86 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//	The event log is a machine-readable record of what happened during a capture session,
+//	written as JSON Lines: one JSON object per line, each with a timestamp and event name
+//	plus fields specific to that event.  It is independent of the verbosity setting.
+
+// Event names written to the event log
+const (
+	EventSessionStart = "session_start"
+	EventConnect      = "connect"
+	EventReconnect    = "reconnect"
+	EventCoolerStart  = "cooler_start"
+	EventSetpoint     = "setpoint"
+	EventTemperature  = "temperature"
+	EventDownloadTime = "download_time"
+	EventFrame        = "frame"
+	EventAbort        = "abort"
+	EventCoolerStop   = "cooler_stop"
+	EventSessionEnd   = "session_end"
+)
+
+// EventLog writes session events to a JSON Lines stream.  A nil *EventLog is valid and
+// records nothing, so callers don't need to check whether logging is turned on.
+type EventLog struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	file   *os.File
+	now    func() time.Time
+}
+
+// NewEventLog opens the given file for appending events, creating it if necessary
+func NewEventLog(path string) (*EventLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening event log: %w", err)
+	}
+	eventLog := NewEventLogWriter(file)
+	eventLog.file = file
+	return eventLog, nil
+}
+
+// NewEventLogWriter records events to an already-open writer
+func NewEventLogWriter(writer io.Writer) *EventLog {
+	return &EventLog{writer: writer, now: time.Now}
+}
+
+// Record writes one event with the given fields.  Failures to write are reported but
+// otherwise ignored - losing the log shouldn't stop a capture.
+func (l *EventLog) Record(event string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	line := make(map[string]interface{}, len(fields)+2)
+	for name, value := range fields {
+		line[name] = value
+	}
+	line["time"] = l.now().Format(time.RFC3339Nano)
+	line["event"] = event
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		fmt.Println("Error in EventLog Record, encoding event:", err)
+		return
+	}
+	if _, err := l.writer.Write(append(encoded, '\n')); err != nil {
+		fmt.Println("Error in EventLog Record, writing event:", err)
+	}
+}
+
+// Close closes the event log file, if we opened one
+func (l *EventLog) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(buffer)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &event), "Each line should be a JSON object: "+scanner.Text())
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventLog(t *testing.T) {
+
+	t.Run("writes one JSON object per line with time and event name", func(t *testing.T) {
+		var buffer bytes.Buffer
+		eventLog := NewEventLogWriter(&buffer)
+		eventLog.now = func() time.Time { return time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC) }
+		eventLog.Record(EventDownloadTime, map[string]interface{}{"binning": 2, "seconds": 1.5})
+		eventLog.Record(EventCoolerStop, nil)
+
+		events := readEvents(t, &buffer)
+		require.Len(t, events, 2)
+		require.Equal(t, "2024-06-01T22:00:00Z", events[0]["time"])
+		require.Equal(t, EventDownloadTime, events[0]["event"])
+		require.Equal(t, 2.0, events[0]["binning"])
+		require.Equal(t, 1.5, events[0]["seconds"])
+		require.Equal(t, EventCoolerStop, events[1]["event"])
+	})
+
+	t.Run("nil event log records nothing", func(t *testing.T) {
+		var eventLog *EventLog
+		eventLog.Record(EventFrame, map[string]interface{}{"index": 1})
+		require.Nil(t, eventLog.Close())
+	})
+}
+
+func TestSessionEvents(t *testing.T) {
+	viper.Set(config.StateFileSetting, filepath.Join(t.TempDir(), "events"))
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, true)
+	viper.Set(config.CoolToSetting, -10.0)
+	viper.Set(config.CoolStartTolSetting, 1.0)
+	viper.Set(config.CoolWaitMinutesSetting, 30)
+	viper.Set(config.StartPollSecondsSetting, 60)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+
+	var buffer bytes.Buffer
+	session, err := newDryRunSession(io.Discard)
+	require.Nil(t, err)
+	session.SetEventLog(NewEventLogWriter(&buffer))
+	session.RecordSessionStart([]string{"1,2"}, []string{"2,30,1"}, nil)
+	require.Nil(t, session.ConnectToServer())
+	require.Nil(t, session.CaptureFrames(context.Background(), false, []string{"1,2"}, []string{"2,30,1"}, nil))
+	session.RecordSessionEnd(errors.New("test ending"))
+
+	events := readEvents(t, &buffer)
+	var names []string
+	var frames []map[string]interface{}
+	for _, event := range events {
+		names = append(names, event["event"].(string))
+		if event["event"] == EventFrame {
+			frames = append(frames, event)
+		}
+	}
+
+	t.Run("session lifecycle events are recorded", func(t *testing.T) {
+		require.Equal(t, EventSessionStart, names[0])
+		require.Equal(t, EventConnect, names[1])
+		require.Equal(t, EventCoolerStart, names[2])
+		require.Contains(t, names, EventDownloadTime)
+		require.Contains(t, names, EventTemperature)
+		require.Equal(t, EventSessionEnd, names[len(names)-1])
+		end := events[len(events)-1]
+		require.Equal(t, 3.0, end["frames_captured"])
+		require.Equal(t, false, end["completed"])
+		require.Equal(t, "test ending", end["error"])
+	})
+
+	t.Run("each frame is recorded with its details", func(t *testing.T) {
+		require.Len(t, frames, 3)
+		require.Equal(t, "Bias", frames[0]["frame_type"])
+		require.Equal(t, MakeBiasKey(2, -10, config.CameraSettings{}), frames[0]["key"])
+		require.Equal(t, "Dark", frames[1]["frame_type"])
+		require.Equal(t, MakeDarkKey(30, 1, -10, config.CameraSettings{}), frames[1]["key"])
+		require.Equal(t, 1.0, frames[1]["index"])
+		require.Equal(t, 2.0, frames[2]["index"])
+		require.Equal(t, 30.0, frames[2]["exposure"])
+		require.Equal(t, 1.0, frames[2]["binning"])
+		require.Contains(t, frames[2], "temperature")
+	})
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//	As far as the camera is concerned a flat-dark is just a short dark frame, and TheSkyX saves it
+//	as one.  So that they can be told apart afterwards, flat-darks are saved with FlatDarkSavePrefix
+//	added to the start of TheSkyX's autosave prefix.  The prefix is set before the first frame of a
+//	flat-dark set, and the one that was there before put back before a frame of any other type and
+//	when the capture ends, as in round-robin order, where the types take turns.
+
+// FlatDarkSavePrefix is added to the start of TheSkyX's autosave prefix while flat-darks are captured
+const FlatDarkSavePrefix = "FlatDark_"
+
+// SavePrefixService is implemented by a server service that can read and change the prefix TheSkyX
+// puts on the names of the frames it saves.  goTheSkyX's service doesn't offer this, so NewSession
+// adds it (see cameraSettingsTheSkyService).
+type SavePrefixService interface {
+	GetSavePrefix() (string, error)
+	SetSavePrefix(prefix string) error
+}
+
+// ErrFlatDarkTagUnsupported is returned when there are flat-darks to capture and the server service
+// can't tag them.  It is found before any frames are captured.
+var ErrFlatDarkTagUnsupported = errors.New("flat-darks can't be tagged with a save prefix with this server")
+
+// EventSavePrefix is written to the event log when the save prefix is changed
+const EventSavePrefix = "save_prefix"
+
+// checkFlatDarkTagSupported fails if there are flat-darks in the plan still to be captured and the
+// server service can't tag them
+func (s *Session) checkFlatDarkTagSupported(plan *CapturePlan) error {
+	if _, ok := s.theSkyService.(SavePrefixService); ok {
+		return nil
+	}
+	progress, err := PlanProgress(plan)
+	if err != nil {
+		return err
+	}
+	for _, set := range progress {
+		if set.FrameType == "FlatDark" && !frameTypeExcluded(set.FrameType) && set.Remaining > 0 {
+			return fmt.Errorf("%w (set %s)", ErrFlatDarkTagUnsupported, set.Key)
+		}
+	}
+	return nil
+}
+
+// applyFlatDarkTag sets the save prefix for a frame of the given type: tagged for a flat-dark, and
+// as it was before for any other.  Nothing is sent if the prefix is already right.
+func (s *Session) applyFlatDarkTag(ctx context.Context, frameType string) error {
+	tagged := frameType == "FlatDark"
+	if tagged == s.flatDarkTagged {
+		return nil
+	}
+	prefixService, ok := s.theSkyService.(SavePrefixService)
+	if !ok {
+		return ErrFlatDarkTagUnsupported
+	}
+	if tagged {
+		if err := s.withRetry(ctx, "reading save prefix", func() error {
+			prefix, err := prefixService.GetSavePrefix()
+			s.untaggedSavePrefix = prefix
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	prefix := s.untaggedSavePrefix
+	if tagged {
+		prefix = FlatDarkSavePrefix + prefix
+	}
+	if err := s.setSavePrefix(ctx, prefixService, prefix); err != nil {
+		return err
+	}
+	s.flatDarkTagged = tagged
+	return nil
+}
+
+// restoreSavePrefix puts back the save prefix that was there before flat-darks were captured, if
+// it was changed.  The capture is over, so failing is reported but not retried.
+func (s *Session) restoreSavePrefix() {
+	if !s.flatDarkTagged {
+		return
+	}
+	prefixService, ok := s.theSkyService.(SavePrefixService)
+	if !ok {
+		return
+	}
+	s.logger.Informativef("Putting back save prefix: %q", s.untaggedSavePrefix)
+	if err := prefixService.SetSavePrefix(s.untaggedSavePrefix); err != nil {
+		s.logger.Errorf("Error in Session restoreSavePrefix, putting back save prefix %q: %v", s.untaggedSavePrefix, err)
+		return
+	}
+	s.flatDarkTagged = false
+	s.eventLog.Record(EventSavePrefix, map[string]interface{}{
+		"prefix": s.untaggedSavePrefix,
+	})
+}
+
+// setSavePrefix changes the save prefix, retrying as for any other request to the server
+func (s *Session) setSavePrefix(ctx context.Context, prefixService SavePrefixService, prefix string) error {
+	s.logger.Informativef("Setting save prefix: %q", prefix)
+	if err := s.withRetry(ctx, "setting save prefix", func() error {
+		return prefixService.SetSavePrefix(prefix)
+	}); err != nil {
+		return err
+	}
+	s.eventLog.Record(EventSavePrefix, map[string]interface{}{
+		"prefix": prefix,
+	})
+	return nil
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// savePrefixRecorder notes the save prefix in force as each frame is captured
+type savePrefixRecorder struct {
+	*dryRunTheSkyService
+	captured []string // Frame type, from the exposure, and the save prefix, e.g. "FlatDark FlatDark_Night1_"
+}
+
+func (r *savePrefixRecorder) CaptureDarkFrame(binning int, seconds float64, downloadTime float64) error {
+	frameType := "Dark"
+	if seconds < 1 {
+		frameType = "FlatDark"
+	}
+	r.captured = append(r.captured, frameType+" "+r.savePrefix)
+	return r.dryRunTheSkyService.CaptureDarkFrame(binning, seconds, downloadTime)
+}
+
+func (r *savePrefixRecorder) CaptureBiasFrame(binning int, downloadTime float64) error {
+	r.captured = append(r.captured, "Bias "+r.savePrefix)
+	return r.dryRunTheSkyService.CaptureBiasFrame(binning, downloadTime)
+}
+
+func TestFlatDarks(t *testing.T) {
+	stateFilePath := filepath.Join(t.TempDir(), "flatdarks")
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, false)
+	viper.Set(config.CoolToSetting, 0.0)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+	t.Cleanup(func() {
+		viper.Set(config.NoFlatDarkSetting, false)
+		viper.Set(config.FlatDarkFirstSetting, false)
+	})
+
+	dark := MakeDarkKey(60, 1, 0, config.CameraSettings{})
+	bias := MakeBiasKey(1, 0, config.CameraSettings{})
+	flatDark := MakeFlatDarkKey(0.5, 1, 0, config.CameraSettings{})
+
+	//	captureWithFlatDarks runs a by-type capture with one set of each kind, starting from the state
+	//	file left by any earlier run, and returns the frame types captured, in order
+	captureWithFlatDarks := func(t *testing.T) []string {
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		session.SetStateFileService(NewStateFileService(stateFilePath, 0))
+		var events bytes.Buffer
+		session.SetEventLog(NewEventLogWriter(&events))
+		require.Nil(t, session.ConnectToServer())
+		require.Nil(t, session.CaptureFrames(context.Background(), true, []string{"1,1"}, []string{"1,60,1"}, []string{"2,0.5,1"}))
+
+		var frameTypes []string
+		for _, event := range readEvents(t, &events) {
+			if event["event"] == EventFrame {
+				frameTypes = append(frameTypes, event["frame_type"].(string))
+			}
+		}
+		return frameTypes
+	}
+
+	t.Run("flat-darks are done last, with their own counts", func(t *testing.T) {
+		require.Equal(t, []string{"Dark", "Bias", "FlatDark", "FlatDark"}, captureWithFlatDarks(t))
+		plan, err := NewStateFileService(stateFilePath, 0).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 2, plan.FlatDarksDone[flatDark])
+		require.Equal(t, 1, plan.DarksDone[dark])
+		require.Equal(t, 1, plan.BiasDone[bias])
+		require.NotContains(t, plan.DarksDone, flatDark, "Flat-darks are not counted as darks")
+	})
+
+	t.Run("resumed run leaves finished flat-darks alone", func(t *testing.T) {
+		require.Empty(t, captureWithFlatDarks(t))
+	})
+
+	t.Run("flat-darks first when asked", func(t *testing.T) {
+		require.Nil(t, NewStateFileService(stateFilePath, 0).DeleteStateFile())
+		viper.Set(config.FlatDarkFirstSetting, true)
+		require.Equal(t, []string{"FlatDark", "FlatDark", "Dark", "Bias"}, captureWithFlatDarks(t))
+		viper.Set(config.FlatDarkFirstSetting, false)
+	})
+
+	t.Run("noflatdark skips them", func(t *testing.T) {
+		require.Nil(t, NewStateFileService(stateFilePath, 0).DeleteStateFile())
+		viper.Set(config.NoFlatDarkSetting, true)
+		require.Equal(t, []string{"Dark", "Bias"}, captureWithFlatDarks(t))
+		viper.Set(config.NoFlatDarkSetting, false)
+	})
+
+	t.Run("flat-darks are saved with their own prefix, and the prefix put back afterwards", func(t *testing.T) {
+		require.Nil(t, NewStateFileService(stateFilePath, 0).DeleteStateFile())
+		viper.Set(config.CaptureOrderSetting, config.CaptureOrderRoundRobin)
+		defer viper.Set(config.CaptureOrderSetting, "")
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		session.SetStateFileService(NewStateFileService(stateFilePath, 0))
+		recorder := &savePrefixRecorder{dryRunTheSkyService: session.theSkyService.(*dryRunTheSkyService)}
+		recorder.savePrefix = "Night1_"
+		session.SetTheSkyService(recorder)
+		require.Nil(t, session.ConnectToServer())
+		require.Nil(t, session.CaptureFrames(context.Background(), true, []string{"1,1"}, []string{"2,60,1"}, []string{"2,0.5,1"}))
+
+		require.Equal(t, []string{"Dark Night1_", "Bias Night1_", "FlatDark FlatDark_Night1_",
+			"Dark Night1_", "FlatDark FlatDark_Night1_"}, recorder.captured,
+			"Only flat-darks should be tagged, with the prefix put back for the dark between them")
+		require.Equal(t, "Night1_", recorder.savePrefix, "The prefix should be put back at the end")
+	})
+
+	t.Run("server that can't tag flat-darks fails before capturing", func(t *testing.T) {
+		require.Nil(t, NewStateFileService(stateFilePath, 0).DeleteStateFile())
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		session.SetStateFileService(NewStateFileService(stateFilePath, 0))
+		session.SetTheSkyService(&plainTheSkyService{session.theSkyService})
+		require.Nil(t, session.ConnectToServer())
+		err = session.CaptureFrames(context.Background(), true, nil, []string{"1,60,1"}, []string{"2,0.5,1"})
+		require.True(t, errors.Is(err, ErrFlatDarkTagUnsupported), "Expected untaggable flat-darks, got %v", err)
+		require.Equal(t, 0, session.framesCaptured)
+
+		viper.Set(config.NoFlatDarkSetting, true)
+		defer viper.Set(config.NoFlatDarkSetting, false)
+		require.Nil(t, session.CaptureFrames(context.Background(), true, nil, []string{"1,60,1"}, []string{"2,0.5,1"}),
+			"Flat-darks that won't be captured don't need tagging")
+	})
+
+	t.Run("other capture orders place flat-darks too", func(t *testing.T) {
+		require.Nil(t, NewStateFileService(stateFilePath, 0).DeleteStateFile())
+		viper.Set(config.CaptureOrderSetting, config.CaptureOrderShortestFirst)
+		defer viper.Set(config.CaptureOrderSetting, "")
+		require.Equal(t, []string{"Bias", "FlatDark", "FlatDark", "Dark"}, captureWithFlatDarks(t))
+	})
+}
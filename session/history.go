@@ -0,0 +1,101 @@
+package session
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"time"
+)
+
+//	Besides the done counts, the state file keeps a record of every frame attempted, so the quality
+//	of a dark library can be judged long after it was captured: when each frame was taken, how far the
+//	sensor temperature moved while it was, and whether TheSkyX reported a problem.
+
+// FrameResultOK is the result recorded for a frame TheSkyX captured without error
+const FrameResultOK = "ok"
+
+// FrameRecord is the history of one frame capture attempt
+type FrameRecord struct {
+	Time              time.Time // When the capture finished
+	FrameType         string    // "Dark", "Bias" or "FlatDark"
+	Key               string    // State file key of the set
+	Exposure          float64   // Seconds; 0 for bias frames
+	Binning           int
+	Camera            string   `json:",omitempty"` // Gain, offset and readout mode set, if any
+	TemperatureBefore *float64 `json:",omitempty"` // Sensor temperature, if it could be read
+	TemperatureAfter  *float64 `json:",omitempty"`
+	DownloadSeconds   float64  // Download time allowed for the frame
+	Result            string   // FrameResultOK, or the error TheSkyX reported
+}
+
+// Succeeded is true if TheSkyX captured the frame without error
+func (r FrameRecord) Succeeded() bool {
+	return r.Result == FrameResultOK
+}
+
+// HistoryFilter selects frame records; zero fields don't restrict the selection
+type HistoryFilter struct {
+	From time.Time // Records at or after this time
+	To   time.Time // Records before this time
+	Key  string    // Records of this set
+}
+
+// FrameHistory returns the plan's frame records that pass the filter, oldest first
+func (p *CapturePlan) FrameHistory(filter HistoryFilter) []FrameRecord {
+	var records []FrameRecord
+	for _, record := range p.History {
+		if !filter.From.IsZero() && record.Time.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !record.Time.Before(filter.To) {
+			continue
+		}
+		if filter.Key != "" && record.Key != filter.Key {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// recordHistory adds a capture attempt to the plan's history
+func (s *Session) recordHistory(plan *CapturePlan, set SetProgress, downloadSeconds float64,
+	temperatureBefore *float64, temperatureAfter *float64, captureErr error) {
+	record := FrameRecord{
+		Time:              time.Now(),
+		FrameType:         set.FrameType,
+		Key:               set.Key,
+		Binning:           set.Binning,
+		TemperatureBefore: temperatureBefore,
+		TemperatureAfter:  temperatureAfter,
+		DownloadSeconds:   downloadSeconds,
+		Result:            FrameResultOK,
+	}
+	if set.FrameType != "Bias" {
+		record.Exposure = set.Exposure
+	}
+	record.Camera = cameraText(set.Camera)
+	if captureErr != nil {
+		record.Result = captureErr.Error()
+	}
+	plan.History = append(plan.History, record)
+}
+
+// sensorTemperature reads the camera temperature for the history, or nil if it can't be read.
+// Without the cooler the temperature isn't regulated, so it isn't read.
+func (s *Session) sensorTemperature(ctx context.Context) *float64 {
+	if !viper.GetBool(config.UseCoolerSetting) {
+		return nil
+	}
+	var temperature float64
+	err := s.withRetry(ctx, "reading camera temperature", func() error {
+		var err error
+		temperature, err = s.theSkyService.GetCameraTemperature()
+		return err
+	})
+	if err != nil {
+		s.logger.Errorf("Error in Session sensorTemperature, getting camera temperature for the history: %v", err)
+		return nil
+	}
+	return &temperature
+}
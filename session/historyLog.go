@@ -0,0 +1,85 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+//	With the file state store, the frame history is kept in a log beside the state file, the state
+//	file path plus ".history", with one frame record per line, as JSON.  Records are only ever added
+//	to the end, so saving after a frame writes just that frame, however long the history grows,
+//	rather than rewriting all of it into the state file (and its backup) every time.
+
+// historySuffix is added to the state file path to name its history log
+const historySuffix = ".history"
+
+// HistoryFilePath is the path of the log of frames attempted at the state file's temperature
+func (sfs *StateFileServiceInstance) HistoryFilePath() string {
+	return sfs.StateFilePath + historySuffix
+}
+
+// readHistoryLog reads every frame record in a history log, oldest first, and the number of lines
+// that couldn't be read, such as one cut short by a crash mid-write.  A log that doesn't exist has
+// no records.
+func readHistoryLog(path string) ([]FrameRecord, int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var records []FrameRecord
+	unreadable := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		var record FrameRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			unreadable++
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, unreadable, scanner.Err()
+}
+
+// appendHistoryLog adds frame records to the end of a history log, creating it if need be, and
+// flushes them to disk
+func appendHistoryLog(path string, records []FrameRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var lines []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	//	Start on a line of its own, after a last line cut short
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			lines = append([]byte{'\n'}, lines...)
+		}
+	}
+	if _, err := file.Write(lines); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
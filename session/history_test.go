@@ -0,0 +1,120 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"testing"
+	"time"
+)
+
+// failingTheSkyService is a dry-run server whose dark frames fail after the first few
+type failingTheSkyService struct {
+	*dryRunTheSkyService
+	failAfter  int
+	darkFrames int
+}
+
+func (t *failingTheSkyService) CaptureDarkFrame(binning int, seconds float64, downloadTime float64) error {
+	t.darkFrames++
+	if t.darkFrames > t.failAfter {
+		return errors.New("camera error 206: exposure failed")
+	}
+	return t.dryRunTheSkyService.CaptureDarkFrame(binning, seconds, downloadTime)
+}
+
+// countingTheSkyService is a dry-run server that counts the camera temperature reads
+type countingTheSkyService struct {
+	*dryRunTheSkyService
+	temperatureReads int
+}
+
+func (t *countingTheSkyService) GetCameraTemperature() (float64, error) {
+	t.temperatureReads++
+	return t.dryRunTheSkyService.GetCameraTemperature()
+}
+
+func TestFrameHistory(t *testing.T) {
+	viper.Set(config.CoolWaitMinutesSetting, 30)
+
+	t.Run("every frame is recorded, and kept across runs", func(t *testing.T) {
+		session, stateFilePath := newTemperatureTestSession(t)
+		require.Nil(t, session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-10}, []string{"1,2"}, []string{"2,60,1"}, nil))
+		require.Nil(t, session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-10}, nil, []string{"3,60,1"}, nil))
+
+		plan, err := NewStateFileService(stateFilePath, -10).ReadStateFile()
+		require.Nil(t, err)
+		require.Len(t, plan.History, 4)
+		darkKey := MakeDarkKey(60, 1, -10, config.CameraSettings{})
+		darks := plan.FrameHistory(HistoryFilter{Key: darkKey})
+		require.Len(t, darks, 3, "Frames of the later run should follow those of the earlier")
+		for _, record := range darks {
+			require.Equal(t, "Dark", record.FrameType)
+			require.Equal(t, 60.0, record.Exposure)
+			require.Equal(t, 1, record.Binning)
+			require.True(t, record.Succeeded())
+			require.NotNil(t, record.TemperatureBefore)
+			require.NotNil(t, record.TemperatureAfter)
+			require.InDelta(t, -10.0, *record.TemperatureAfter, 1.0)
+		}
+		bias := plan.FrameHistory(HistoryFilter{Key: MakeBiasKey(2, -10, config.CameraSettings{})})
+		require.Len(t, bias, 1)
+		require.Equal(t, 0.0, bias[0].Exposure)
+	})
+
+	t.Run("failed frame is recorded with TheSkyX's error", func(t *testing.T) {
+		session, stateFilePath := newTemperatureTestSession(t)
+		dryRun := session.theSkyService.(*dryRunTheSkyService)
+		session.SetTheSkyService(&failingTheSkyService{dryRunTheSkyService: dryRun, failAfter: 1})
+		err := session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-10}, nil, []string{"3,60,1"}, nil)
+		require.ErrorContains(t, err, "exposure failed")
+
+		plan, err := NewStateFileService(stateFilePath, -10).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 1, plan.DarksDone[MakeDarkKey(60, 1, -10, config.CameraSettings{})])
+		require.Len(t, plan.History, 2)
+		require.True(t, plan.History[0].Succeeded())
+		require.False(t, plan.History[1].Succeeded())
+		require.Equal(t, "camera error 206: exposure failed", plan.History[1].Result)
+	})
+
+	t.Run("temperature is read once a frame, and not at all without the cooler", func(t *testing.T) {
+		for _, useCooler := range []bool{true, false} {
+			session, _ := newTemperatureTestSession(t)
+			counting := &countingTheSkyService{dryRunTheSkyService: session.theSkyService.(*dryRunTheSkyService)}
+			session.SetTheSkyService(counting)
+			session.UseCoolingTemperature(-10)
+			viper.Set(config.UseCoolerSetting, useCooler)
+			plan := &CapturePlan{Temperature: -10, DarksRequired: []string{"3,60,1"}, DarksDone: map[string]int{},
+				DownloadTimes: map[int]float64{1: 1}}
+			require.Nil(t, session.captureDarkFrames(context.Background(), plan))
+
+			require.Len(t, plan.History, 3)
+			if useCooler {
+				require.Equal(t, 4, counting.temperatureReads, "One before the first frame, then one after each")
+				require.Equal(t, plan.History[0].TemperatureAfter, plan.History[1].TemperatureBefore)
+			} else {
+				require.Zero(t, counting.temperatureReads)
+				require.Nil(t, plan.History[0].TemperatureBefore)
+			}
+		}
+	})
+
+	t.Run("filter by time and key", func(t *testing.T) {
+		day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		plan := &CapturePlan{History: []FrameRecord{
+			{Time: day.Add(22 * time.Hour), Key: "a"},
+			{Time: day.Add(23 * time.Hour), Key: "b"},
+			{Time: day.Add(25 * time.Hour), Key: "a"},
+		}}
+		require.Len(t, plan.FrameHistory(HistoryFilter{}), 3)
+		require.Len(t, plan.FrameHistory(HistoryFilter{Key: "a"}), 2)
+		require.Len(t, plan.FrameHistory(HistoryFilter{From: day.Add(23 * time.Hour)}), 2, "From includes its own time")
+		require.Len(t, plan.FrameHistory(HistoryFilter{To: day.Add(23 * time.Hour)}), 1, "To excludes its own time")
+		require.Equal(t, []FrameRecord{plan.History[2]},
+			plan.FrameHistory(HistoryFilter{From: day.Add(24 * time.Hour), Key: "a"}))
+		require.Empty(t, plan.FrameHistory(HistoryFilter{Key: "c"}))
+	})
+}
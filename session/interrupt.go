@@ -0,0 +1,137 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RMcDOttawa/goMockableDelay"
+	"goskydarks/logging"
+	"time"
+)
+
+//	A capture is stopped part way through by cancelling the context passed to the session's methods,
+//	as the capture command does on Ctrl-C or SIGTERM.  The stop is noticed between frames, and
+//	interrupts waits for a delayed start, for the cooler, or before reconnecting, so the frame being
+//	captured finishes and is recorded in the state file before the session stops.
+
+// ErrInterrupted means the session stopped early because its context was cancelled.
+// The returned error also wraps the context's error.
+var ErrInterrupted = errors.New("capture interrupted")
+
+// contextDelayService is a delay service whose waits end early when a context is done.  Delay
+// services without these methods, such as the dry run's and test mocks, are expected not to block.
+type contextDelayService interface {
+	DelayDurationContext(ctx context.Context, seconds int) (int, error)
+	DelayUntilContext(ctx context.Context, target time.Time) error
+}
+
+// timerDelayService is the delay service for real waits, cut short when the context is done
+type timerDelayService struct {
+	logger *logging.Logger
+}
+
+func newTimerDelayService(logger *logging.Logger) goMockableDelay.DelayService {
+	return &timerDelayService{logger: logger}
+}
+
+func (d *timerDelayService) DelayDuration(seconds int) (int, error) {
+	return d.DelayDurationContext(context.Background(), seconds)
+}
+
+func (d *timerDelayService) DelayUntil(target time.Time) error {
+	return d.DelayUntilContext(context.Background(), target)
+}
+
+// DelayDurationContext waits the given number of seconds, returning the seconds waited, or the
+// context's error if it is done first
+func (d *timerDelayService) DelayDurationContext(ctx context.Context, seconds int) (int, error) {
+	if seconds <= 0 {
+		return 0, nil
+	}
+	d.logger.Tracef("Delay %d seconds", seconds)
+	if err := waitTimer(ctx, time.Duration(seconds)*time.Second); err != nil {
+		return 0, err
+	}
+	return seconds, nil
+}
+
+// DelayUntilContext waits until the given time, or returns the context's error if it is done first
+func (d *timerDelayService) DelayUntilContext(ctx context.Context, target time.Time) error {
+	d.logger.Tracef("Delay until %v", target)
+	return waitTimer(ctx, time.Until(target))
+}
+
+func (d *timerDelayService) SetDebug(_ bool) {}
+
+func (d *timerDelayService) SetVerbosity(_ int) {}
+
+// waitTimer waits for the given duration, or until the context is done
+func waitTimer(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkStop returns an ErrInterrupted error if the context is done, so loops can leave at a safe point
+func (s *Session) checkStop(ctx context.Context) error {
+	if ctx.Err() != nil {
+		s.logger.Minimalf("Stopping capture: %v", ctx.Err())
+		return fmt.Errorf("%w: %w", ErrInterrupted, ctx.Err())
+	}
+	return nil
+}
+
+// delayDuration waits the given number of seconds, as the delay service's DelayDuration does,
+// but returns early if the context is cancelled
+func (s *Session) delayDuration(ctx context.Context, seconds int) (int, error) {
+	delayService, cancellable := s.delayService.(contextDelayService)
+	if !cancellable {
+		if err := s.checkStop(ctx); err != nil {
+			return 0, err
+		}
+		return s.delayService.DelayDuration(seconds)
+	}
+	waited, err := delayService.DelayDurationContext(ctx, seconds)
+	if err != nil && ctx.Err() != nil {
+		return waited, s.checkStop(ctx)
+	}
+	return waited, err
+}
+
+// delayUntil waits until the given time, as the delay service's DelayUntil does, but returns
+// early if the context is cancelled
+func (s *Session) delayUntil(ctx context.Context, target time.Time) error {
+	delayService, cancellable := s.delayService.(contextDelayService)
+	if !cancellable {
+		if err := s.checkStop(ctx); err != nil {
+			return err
+		}
+		return s.delayService.DelayUntil(target)
+	}
+	if err := delayService.DelayUntilContext(ctx, target); err != nil {
+		if ctx.Err() != nil {
+			return s.checkStop(ctx)
+		}
+		return err
+	}
+	return nil
+}
+
+// saveInterruptedPlan saves the plan, with whatever progress was made, if the error says the
+// session was interrupted.  Errors saving are reported but don't replace the interruption.
+func (s *Session) saveInterruptedPlan(plan *CapturePlan, err error) {
+	if !errors.Is(err, ErrInterrupted) {
+		return
+	}
+	if saveErr := s.stateFileService.SavePlanToFile(plan); saveErr != nil {
+		s.logger.Errorf("Error saving capture plan after interruption: %v", saveErr)
+	}
+}
@@ -0,0 +1,174 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"goskydarks/logging"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingDelayService never finishes a delay until released, like a very long real wait
+type blockingDelayService struct {
+	release chan struct{}
+}
+
+func (d *blockingDelayService) DelayDuration(seconds int) (int, error) {
+	<-d.release
+	return seconds, nil
+}
+
+func (d *blockingDelayService) DelayUntil(_ time.Time) error {
+	<-d.release
+	return nil
+}
+
+func (d *blockingDelayService) DelayDurationContext(ctx context.Context, seconds int) (int, error) {
+	select {
+	case <-d.release:
+		return seconds, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (d *blockingDelayService) DelayUntilContext(ctx context.Context, _ time.Time) error {
+	select {
+	case <-d.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *blockingDelayService) SetDebug(_ bool) {}
+
+func (d *blockingDelayService) SetVerbosity(_ int) {}
+
+// stoppingTheSkyService is a dry-run server that cancels the capture's context after a number of
+// dark frames, as the capture command's signal handler would
+type stoppingTheSkyService struct {
+	*dryRunTheSkyService
+	cancel     context.CancelFunc
+	stopAfter  int
+	darkFrames int
+}
+
+func (t *stoppingTheSkyService) CaptureDarkFrame(binning int, seconds float64, downloadTime float64) error {
+	t.darkFrames++
+	if t.darkFrames == t.stopAfter {
+		t.cancel()
+	}
+	return t.dryRunTheSkyService.CaptureDarkFrame(binning, seconds, downloadTime)
+}
+
+func TestStopBetweenFrames(t *testing.T) {
+	stateFilePath := filepath.Join(t.TempDir(), "interrupt")
+	viper.Set(config.StateFileSetting, stateFilePath)
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, false)
+	viper.Set(config.CoolToSetting, -10.0)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+
+	t.Run("stops after the current frame and saves progress", func(t *testing.T) {
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		stateFileService := NewStateFileService(stateFilePath, -10.0)
+		session.SetStateFileService(stateFileService)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		session.SetTheSkyService(&stoppingTheSkyService{
+			dryRunTheSkyService: session.theSkyService.(*dryRunTheSkyService),
+			cancel:              cancel,
+			stopAfter:           2,
+		})
+		require.Nil(t, session.ConnectToServer())
+
+		err = session.CaptureFrames(ctx, true, []string{"5,1"}, []string{"5,60,1"}, nil)
+		require.True(t, errors.Is(err, ErrInterrupted), "Expected interruption, got %v", err)
+
+		plan, err := stateFileService.ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 2, plan.DarksDone[MakeDarkKey(60, 1, -10, config.CameraSettings{})], "Frame in progress should be finished and saved")
+		require.Equal(t, 0, plan.BiasDone[MakeBiasKey(1, -10, config.CameraSettings{})], "No frames after the stop request")
+		require.Greater(t, plan.DownloadTimes[1], 0.0, "Download times measured so far should be saved")
+	})
+}
+
+func TestContextCancellation(t *testing.T) {
+	viper.Set(config.StateFileSetting, filepath.Join(t.TempDir(), "cancel"))
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, true)
+	viper.Set(config.CoolToSetting, -10.0)
+	viper.Set(config.CoolStartTolSetting, 1.0)
+	viper.Set(config.CoolWaitMinutesSetting, 600)
+	viper.Set(config.StartPollSecondsSetting, 3600)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+
+	newBlockedSession := func(t *testing.T) (*Session, *blockingDelayService) {
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		delayService := &blockingDelayService{release: make(chan struct{})}
+		t.Cleanup(func() { close(delayService.release) })
+		session.SetDelayService(delayService)
+		return session, delayService
+	}
+
+	t.Run("delayed start is cancelled", func(t *testing.T) {
+		session, _ := newBlockedSession(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := session.DelayStart(ctx, time.Now().Add(6*time.Hour))
+		require.True(t, errors.Is(err, ErrInterrupted), "Expected interruption, got %v", err)
+		require.True(t, errors.Is(err, context.DeadlineExceeded), "Context's error should be wrapped")
+	})
+
+	t.Run("cooling wait is cancelled", func(t *testing.T) {
+		session, _ := newBlockedSession(t)
+		require.Nil(t, session.ConnectToServer())
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+		err := session.CaptureFrames(ctx, true, nil, []string{"1,60,1"}, nil)
+		require.True(t, errors.Is(err, ErrInterrupted), "Expected interruption, got %v", err)
+		require.True(t, errors.Is(err, context.Canceled))
+		require.Equal(t, 0, session.framesCaptured)
+	})
+
+	t.Run("real wait ends when cancelled", func(t *testing.T) {
+		delayService := newTimerDelayService(logging.Default()).(*timerDelayService)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		waited, err := delayService.DelayDurationContext(ctx, 3600)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Zero(t, waited)
+		require.ErrorIs(t, delayService.DelayUntilContext(ctx, time.Now().Add(time.Hour)), context.DeadlineExceeded)
+		require.Less(t, time.Since(started), time.Second)
+		require.Nil(t, delayService.DelayUntil(time.Now().Add(-time.Hour)), "A time already passed needs no wait")
+	})
+
+	t.Run("cancelled context captures nothing", func(t *testing.T) {
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		require.Nil(t, session.ConnectToServer())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = session.CaptureFramesAtTemperatures(ctx, true, []float64{-5, -10}, []string{"1,1"}, nil, nil)
+		require.True(t, errors.Is(err, ErrInterrupted), "Expected interruption, got %v", err)
+		require.Equal(t, 0, session.framesCaptured)
+	})
+}
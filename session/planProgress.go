@@ -0,0 +1,200 @@
+package session
+
+import (
+	"goskydarks/config"
+	"slices"
+	"sort"
+)
+
+// SetProgress summarizes how far along one frame set in a capture plan is
+type SetProgress struct {
+	FrameType string // "Dark", "Bias" or "FlatDark"
+	Key       string // State file key for the set
+	Count     int    // Frames required
+	Exposure  float64
+	Binning   int
+	Camera    config.CameraSettings // Gain, offset and readout mode to set, if any
+	Done      int
+	Remaining int
+}
+
+// biasExposureSeconds is the nominal exposure we assume for a bias frame when estimating time
+const biasExposureSeconds = 0.1
+
+// PlanProgress lists the dark sets, then the bias sets, then the flat-dark sets, in the plan with their required, done,
+// and remaining frame counts
+func PlanProgress(plan *CapturePlan) ([]SetProgress, error) {
+	var progress []SetProgress
+	for _, frameType := range []string{"Dark", "Bias", "FlatDark"} {
+		for _, set := range plan.setsRequired(frameType) {
+			frameSet, err := plan.parseFrameSet(frameType, set)
+			if err != nil {
+				return nil, err
+			}
+			progress = append(progress, frameSet.withDone(plan.doneCounts(frameType)[frameSet.Key]))
+		}
+	}
+	return progress, nil
+}
+
+// setsRequired returns the set strings in the plan for the given type of frame
+func (p *CapturePlan) setsRequired(frameType string) []string {
+	switch frameType {
+	case "Dark":
+		return p.DarksRequired
+	case "FlatDark":
+		return p.FlatDarksRequired
+	default:
+		return p.BiasRequired
+	}
+}
+
+// parseFrameSet parses a dark, bias or flat-dark set string, with any camera settings, into a
+// set with its state file key, for the plan's temperature, and nothing yet done
+func (p *CapturePlan) parseFrameSet(frameType string, set string) (SetProgress, error) {
+	var count, binning int
+	exposure := biasExposureSeconds
+	var err error
+	switch frameType {
+	case "Dark":
+		count, exposure, binning, err = config.ParseDarkSet(set)
+	case "FlatDark":
+		count, exposure, binning, err = config.ParseFlatDarkSet(set)
+	default:
+		count, binning, err = config.ParseBiasSet(set)
+	}
+	if err != nil {
+		return SetProgress{}, err
+	}
+	camera, err := config.ParseCameraSettings(set)
+	if err != nil {
+		return SetProgress{}, err
+	}
+
+	var key string
+	switch frameType {
+	case "Dark":
+		key = MakeDarkKey(exposure, binning, p.Temperature, camera)
+	case "FlatDark":
+		key = MakeFlatDarkKey(exposure, binning, p.Temperature, camera)
+	default:
+		key = MakeBiasKey(binning, p.Temperature, camera)
+	}
+	frameSet := SetProgress{
+		FrameType: frameType,
+		Key:       key,
+		Count:     count,
+		Exposure:  exposure,
+		Binning:   binning,
+		Camera:    camera,
+	}
+	return frameSet.withDone(0), nil
+}
+
+// withDone returns the set with the given number of frames done
+func (p SetProgress) withDone(done int) SetProgress {
+	p.Done = done
+	p.Remaining = max(p.Count-done, 0)
+	return p
+}
+
+// darkSetKey returns the state file key for a dark set string, or "" if it won't parse
+func (p *CapturePlan) darkSetKey(set string) string {
+	frameSet, err := p.parseFrameSet("Dark", set)
+	if err != nil {
+		return ""
+	}
+	return frameSet.Key
+}
+
+// flatDarkSetKey returns the state file key for a flat-dark set string, or "" if it won't parse
+func (p *CapturePlan) flatDarkSetKey(set string) string {
+	frameSet, err := p.parseFrameSet("FlatDark", set)
+	if err != nil {
+		return ""
+	}
+	return frameSet.Key
+}
+
+// biasSetKey returns the state file key for a bias set string, or "" if it won't parse
+func (p *CapturePlan) biasSetKey(set string) string {
+	frameSet, err := p.parseFrameSet("Bias", set)
+	if err != nil {
+		return ""
+	}
+	return frameSet.Key
+}
+
+// CaptureEstimate breaks down how long capturing the remaining frames in a plan should take
+type CaptureEstimate struct {
+	RemainingFrames    int
+	ImagingSeconds     float64 // Total exposure time of the remaining frames
+	DownloadSeconds    float64 // Total download time of the remaining frames
+	CoolingWaitSeconds float64 // Allowance for the cooler to reach the target temperature
+	UnmeasuredBinnings []int   // Binnings with no measured download time, so not in DownloadSeconds
+}
+
+// TotalSeconds is the whole estimated duration of the capture
+func (e CaptureEstimate) TotalSeconds() float64 {
+	return e.ImagingSeconds + e.DownloadSeconds + e.CoolingWaitSeconds
+}
+
+// Add combines another estimate into this one, e.g. to total the estimates for several cooling temperatures
+func (e *CaptureEstimate) Add(other CaptureEstimate) {
+	e.RemainingFrames += other.RemainingFrames
+	e.ImagingSeconds += other.ImagingSeconds
+	e.DownloadSeconds += other.DownloadSeconds
+	e.CoolingWaitSeconds += other.CoolingWaitSeconds
+	for _, binning := range other.UnmeasuredBinnings {
+		if !slices.Contains(e.UnmeasuredBinnings, binning) {
+			e.UnmeasuredBinnings = append(e.UnmeasuredBinnings, binning)
+		}
+	}
+	sort.Ints(e.UnmeasuredBinnings)
+}
+
+// EstimateCapture estimates the time to capture the frames still needed in the plan: the exposure
+// time plus the measured download time for every remaining frame, plus the given cooling wait.
+// Dark, bias or flat-dark frames can be left out, to match the nodark, nobias and noflatdark settings.
+func EstimateCapture(plan *CapturePlan, includeDarks bool, includeBias bool, includeFlatDarks bool,
+	coolingWaitSeconds float64) (CaptureEstimate, error) {
+	estimate := CaptureEstimate{CoolingWaitSeconds: coolingWaitSeconds}
+	progress, err := PlanProgress(plan)
+	if err != nil {
+		return estimate, err
+	}
+	unmeasured := make(map[int]bool)
+	for _, set := range progress {
+		if set.Remaining == 0 {
+			continue
+		}
+		if (set.FrameType == "Dark" && !includeDarks) || (set.FrameType == "Bias" && !includeBias) ||
+			(set.FrameType == "FlatDark" && !includeFlatDarks) {
+			continue
+		}
+		downloadTime := plan.DownloadTimes[set.Binning]
+		if downloadTime <= 0 {
+			unmeasured[set.Binning] = true
+		}
+		estimate.RemainingFrames += set.Remaining
+		estimate.ImagingSeconds += float64(set.Remaining) * set.Exposure
+		estimate.DownloadSeconds += float64(set.Remaining) * downloadTime
+	}
+	for binning := range unmeasured {
+		estimate.UnmeasuredBinnings = append(estimate.UnmeasuredBinnings, binning)
+	}
+	sort.Ints(estimate.UnmeasuredBinnings)
+	return estimate, nil
+}
+
+// EstimateRemainingSeconds estimates how long it will take to capture all the frames still needed
+// in the plan, not counting any wait for cooling.
+// Binnings whose download time has not yet been measured are returned, sorted, so the caller can
+// say the estimate leaves them out.
+func EstimateRemainingSeconds(plan *CapturePlan) (float64, []int, error) {
+	estimate, err := EstimateCapture(plan, true, true, true, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	return estimate.TotalSeconds(), estimate.UnmeasuredBinnings, nil
+}
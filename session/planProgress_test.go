@@ -0,0 +1,110 @@
+package session
+
+import (
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"testing"
+)
+
+func TestPlanProgress(t *testing.T) {
+
+	t.Run("counts remaining frames in each set", func(t *testing.T) {
+		plan := &CapturePlan{
+			DarksRequired: []string{"10,300,1", "5,60,2"},
+			BiasRequired:  []string{"20,1"},
+			DarksDone:     map[string]int{MakeDarkKey(300, 1, 0, config.CameraSettings{}): 4, MakeDarkKey(60, 2, 0, config.CameraSettings{}): 5},
+			BiasDone:      map[string]int{},
+			DownloadTimes: map[int]float64{1: 10.0, 2: 3.0},
+		}
+		progress, err := PlanProgress(plan)
+		require.Nil(t, err, "Valid plan should not produce an error")
+		require.Len(t, progress, 3)
+
+		require.Equal(t, "Dark", progress[0].FrameType)
+		require.Equal(t, 10, progress[0].Count)
+		require.Equal(t, 4, progress[0].Done)
+		require.Equal(t, 6, progress[0].Remaining)
+
+		require.Equal(t, 0, progress[1].Remaining, "Completed set should have nothing remaining")
+
+		require.Equal(t, "Bias", progress[2].FrameType)
+		require.Equal(t, 20, progress[2].Remaining)
+	})
+
+	t.Run("done count above required is not negative remaining", func(t *testing.T) {
+		plan := &CapturePlan{
+			DarksRequired: []string{"3,30,1"},
+			DarksDone:     map[string]int{MakeDarkKey(30, 1, 0, config.CameraSettings{}): 7},
+		}
+		progress, err := PlanProgress(plan)
+		require.Nil(t, err)
+		require.Equal(t, 0, progress[0].Remaining)
+	})
+
+	t.Run("syntax error in plan is reported", func(t *testing.T) {
+		plan := &CapturePlan{DarksRequired: []string{"3,junk,1"}}
+		_, err := PlanProgress(plan)
+		require.NotNil(t, err, "Bad dark set should produce an error")
+	})
+}
+
+func TestEstimateRemainingSeconds(t *testing.T) {
+
+	t.Run("exposure plus download for each remaining frame", func(t *testing.T) {
+		plan := &CapturePlan{
+			DarksRequired: []string{"10,300,1"},
+			BiasRequired:  []string{"20,2"},
+			DarksDone:     map[string]int{MakeDarkKey(300, 1, 0, config.CameraSettings{}): 8},
+			BiasDone:      map[string]int{},
+			DownloadTimes: map[int]float64{1: 10.0, 2: 2.0},
+		}
+		seconds, unmeasured, err := EstimateRemainingSeconds(plan)
+		require.Nil(t, err)
+		require.Empty(t, unmeasured)
+		require.InDelta(t, 2*(300+10.0)+20*(biasExposureSeconds+2.0), seconds, 0.001)
+	})
+
+	t.Run("unmeasured download times are reported", func(t *testing.T) {
+		plan := &CapturePlan{
+			DarksRequired: []string{"2,60,3", "2,60,1"},
+			DarksDone:     map[string]int{},
+			DownloadTimes: map[int]float64{1: 5.0},
+		}
+		seconds, unmeasured, err := EstimateRemainingSeconds(plan)
+		require.Nil(t, err)
+		require.Equal(t, []int{3}, unmeasured)
+		require.InDelta(t, 2*60.0+2*(60+5.0), seconds, 0.001)
+	})
+}
+
+func TestEstimateCapture(t *testing.T) {
+	plan := &CapturePlan{
+		DarksRequired: []string{"4,100,1"},
+		BiasRequired:  []string{"10,1"},
+		DarksDone:     map[string]int{MakeDarkKey(100, 1, 0, config.CameraSettings{}): 1},
+		BiasDone:      map[string]int{},
+		DownloadTimes: map[int]float64{1: 5.0},
+	}
+
+	t.Run("breaks down imaging, download and cooling time", func(t *testing.T) {
+		estimate, err := EstimateCapture(plan, true, true, true, 600)
+		require.Nil(t, err)
+		require.Equal(t, 13, estimate.RemainingFrames)
+		require.InDelta(t, 3*100.0+10*biasExposureSeconds, estimate.ImagingSeconds, 0.001)
+		require.InDelta(t, 13*5.0, estimate.DownloadSeconds, 0.001)
+		require.InDelta(t, 600+301+65, estimate.TotalSeconds(), 0.001)
+	})
+
+	t.Run("leaves out bias frames when not wanted", func(t *testing.T) {
+		estimate, err := EstimateCapture(plan, true, false, true, 0)
+		require.Nil(t, err)
+		require.Equal(t, 3, estimate.RemainingFrames)
+		require.InDelta(t, 3*(100.0+5.0), estimate.TotalSeconds(), 0.001)
+	})
+
+	t.Run("leaves out dark frames when not wanted", func(t *testing.T) {
+		estimate, err := EstimateCapture(plan, false, true, true, 0)
+		require.Nil(t, err)
+		require.Equal(t, 10, estimate.RemainingFrames)
+	})
+}
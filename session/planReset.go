@@ -0,0 +1,72 @@
+package session
+
+// ClearDoneCounts sets the number of frames done in every set back to zero, so the
+// whole plan will be captured again
+func (plan *CapturePlan) ClearDoneCounts() {
+	for k := range plan.DarksDone {
+		plan.DarksDone[k] = 0
+	}
+	for k := range plan.BiasDone {
+		plan.BiasDone[k] = 0
+	}
+	for k := range plan.FlatDarksDone {
+		plan.FlatDarksDone[k] = 0
+	}
+}
+
+// ClearDownloadTimes forgets the measured download times, so they will be measured
+// again at the start of the next capture
+func (plan *CapturePlan) ClearDownloadTimes() {
+	for binning := range plan.DownloadTimes {
+		plan.DownloadTimes[binning] = 0
+	}
+}
+
+// RemoveSet removes the set with the given state file key (e.g. "Dark_300.0000_1_-10.00C")
+// from both the required list and the done counts.  Returns false if no such set is in the plan.
+func (plan *CapturePlan) RemoveSet(key string) bool {
+	found := false
+	if _, ok := plan.DarksDone[key]; ok {
+		delete(plan.DarksDone, key)
+		found = true
+	}
+	if _, ok := plan.BiasDone[key]; ok {
+		delete(plan.BiasDone, key)
+		found = true
+	}
+	if _, ok := plan.FlatDarksDone[key]; ok {
+		delete(plan.FlatDarksDone, key)
+		found = true
+	}
+
+	var darksKept []string
+	for _, set := range plan.DarksRequired {
+		if plan.darkSetKey(set) == key {
+			found = true
+			continue
+		}
+		darksKept = append(darksKept, set)
+	}
+	plan.DarksRequired = darksKept
+
+	var biasKept []string
+	for _, set := range plan.BiasRequired {
+		if plan.biasSetKey(set) == key {
+			found = true
+			continue
+		}
+		biasKept = append(biasKept, set)
+	}
+	plan.BiasRequired = biasKept
+
+	var flatDarksKept []string
+	for _, set := range plan.FlatDarksRequired {
+		if plan.flatDarkSetKey(set) == key {
+			found = true
+			continue
+		}
+		flatDarksKept = append(flatDarksKept, set)
+	}
+	plan.FlatDarksRequired = flatDarksKept
+	return found
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"testing"
+)
+
+func makeResetTestPlan() *CapturePlan {
+	return &CapturePlan{
+		DarksRequired: []string{"20,300,1", "10,60,2"},
+		BiasRequired:  []string{"30,1"},
+		DarksDone:     map[string]int{MakeDarkKey(300, 1, 0, config.CameraSettings{}): 12, MakeDarkKey(60, 2, 0, config.CameraSettings{}): 10},
+		BiasDone:      map[string]int{MakeBiasKey(1, 0, config.CameraSettings{}): 5},
+		DownloadTimes: map[int]float64{1: 9.0, 2: 2.5},
+	}
+}
+
+func TestPlanReset(t *testing.T) {
+
+	t.Run("clear done counts", func(t *testing.T) {
+		plan := makeResetTestPlan()
+		plan.ClearDoneCounts()
+		require.Equal(t, 0, plan.DarksDone[MakeDarkKey(300, 1, 0, config.CameraSettings{})])
+		require.Equal(t, 0, plan.DarksDone[MakeDarkKey(60, 2, 0, config.CameraSettings{})])
+		require.Equal(t, 0, plan.BiasDone[MakeBiasKey(1, 0, config.CameraSettings{})])
+		require.Equal(t, 9.0, plan.DownloadTimes[1], "Clearing done counts should not affect download times")
+	})
+
+	t.Run("clear download times", func(t *testing.T) {
+		plan := makeResetTestPlan()
+		plan.ClearDownloadTimes()
+		require.Equal(t, map[int]float64{1: 0, 2: 0}, plan.DownloadTimes)
+		require.Equal(t, 12, plan.DarksDone[MakeDarkKey(300, 1, 0, config.CameraSettings{})], "Clearing download times should not affect done counts")
+	})
+
+	t.Run("remove dark set", func(t *testing.T) {
+		plan := makeResetTestPlan()
+		found := plan.RemoveSet(MakeDarkKey(300, 1, 0, config.CameraSettings{}))
+		require.True(t, found, "Set should have been found")
+		require.Equal(t, []string{"10,60,2"}, plan.DarksRequired)
+		require.NotContains(t, plan.DarksDone, MakeDarkKey(300, 1, 0, config.CameraSettings{}))
+		require.Len(t, plan.BiasRequired, 1, "Removing a dark set should not affect bias sets")
+	})
+
+	t.Run("remove bias set", func(t *testing.T) {
+		plan := makeResetTestPlan()
+		found := plan.RemoveSet(MakeBiasKey(1, 0, config.CameraSettings{}))
+		require.True(t, found, "Set should have been found")
+		require.Empty(t, plan.BiasRequired)
+		require.Empty(t, plan.BiasDone)
+	})
+
+	t.Run("remove unknown set", func(t *testing.T) {
+		plan := makeResetTestPlan()
+		found := plan.RemoveSet("Dark_1.0000_99_0.00C")
+		require.False(t, found, "Unknown set should not be found")
+		require.Len(t, plan.DarksRequired, 2)
+	})
+}
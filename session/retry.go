@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"goskydarks/config"
+	"strings"
+)
+
+//	The connection to TheSkyX can drop part way through a night - e.g. flaky Wi-Fi to the
+//	observatory computer.  Requests to the server are made through withRetry: if one fails with
+//	an error that looks like a dropped connection, we wait (backing off), reconnect, restart the
+//	cooler and wait for it to return to temperature, then try the request again.  A frame is
+//	only counted in the capture plan once it has been captured, so a failed frame is simply
+//	taken again and no progress is lost.
+
+// ErrConnectionFailed means the server couldn't be reached, even after any retries allowed
+var ErrConnectionFailed = errors.New("connection to server failed")
+
+// isTransient reports whether an error looks like a dropped connection, by checking its message
+// for any of the configured error text (ignoring case)
+func isTransient(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, text := range viper.GetStringSlice(config.RetryTransientSetting) {
+		if text != "" && strings.Contains(message, strings.ToLower(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry makes a server request, reconnecting and trying again if it fails with a transient error,
+// up to the configured number of attempts.  Requests made while reconnecting are not themselves
+// retried - a failure there counts against the outer request's attempts.
+func (s *Session) withRetry(ctx context.Context, request string, makeRequest func() error) error {
+	attempts := max(viper.GetInt(config.RetryAttemptsSetting), 1)
+	backoffSeconds := max(viper.GetInt(config.RetryBackoffSecondsSetting), 0)
+	maxBackoffSeconds := viper.GetInt(config.RetryMaxBackoffSecondsSetting)
+
+	err := makeRequest()
+	attempt := 1
+	for ; err != nil && attempt < attempts && !s.reconnecting && isTransient(err); attempt++ {
+		s.logger.Minimalf("Lost connection to server (%v) during %s; reconnecting in %d seconds (attempt %d of %d)",
+			err, request, backoffSeconds, attempt+1, attempts)
+		s.eventLog.Record(EventReconnect, map[string]interface{}{
+			"request":         request,
+			"attempt":         attempt + 1,
+			"error":           err.Error(),
+			"backoff_seconds": backoffSeconds,
+		})
+		if _, delayErr := s.delayDuration(ctx, backoffSeconds); delayErr != nil {
+			return delayErr
+		}
+		if err = s.reconnect(ctx); err == nil {
+			err = makeRequest()
+		}
+		backoffSeconds *= 2
+		if maxBackoffSeconds > 0 {
+			backoffSeconds = min(backoffSeconds, maxBackoffSeconds)
+		}
+	}
+	if err != nil && isTransient(err) {
+		return fmt.Errorf("%w: %s failed after %d attempt(s): %w", ErrConnectionFailed, request, attempt, err)
+	}
+	return err
+}
+
+// reconnect re-opens the connection to the server after it dropped.  The camera may have been
+// power-cycled too, so any camera settings and flat-dark save prefix are made again, and if we're
+// using the cooler we start it again and wait for it to get back to the target temperature before
+// going on.
+func (s *Session) reconnect(ctx context.Context) error {
+	s.reconnecting = true
+	defer func() {
+		s.reconnecting = false
+	}()
+
+	_ = s.theSkyService.Close()
+	s.isConnected = false
+	if err := s.theSkyService.Connect(viper.GetString(config.ServerAddressSetting),
+		viper.GetInt(config.ServerPortSetting)); err != nil {
+		s.logger.Errorf("Error in Session reconnect: %v", err)
+		return err
+	}
+	s.isConnected = true
+	s.eventLog.Record(EventConnect, map[string]interface{}{
+		"server":    viper.GetString(config.ServerAddressSetting),
+		"port":      viper.GetInt(config.ServerPortSetting),
+		"reconnect": true,
+	})
+	s.logger.Informativef("Reconnected to server")
+	//	As when first connecting, the first temperature read may be nonsense
+	_, _ = s.theSkyService.GetCameraTemperature()
+
+	if s.cameraSettingsSet {
+		s.cameraSettingsSet = false
+		if err := s.applyCameraSettings(ctx, s.cameraSettings); err != nil {
+			return err
+		}
+	}
+	if s.flatDarkTagged {
+		if prefixService, ok := s.theSkyService.(SavePrefixService); ok {
+			if err := s.setSavePrefix(ctx, prefixService, FlatDarkSavePrefix+s.untaggedSavePrefix); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !viper.GetBool(config.UseCoolerSetting) {
+		return nil
+	}
+	if err := s.StartCoolingForStart(); err != nil {
+		return err
+	}
+	return s.WaitForTargetTemperature(ctx)
+}
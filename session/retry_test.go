@@ -0,0 +1,124 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// droppingTheSkyService is a dry-run server whose connection drops on chosen dark frames
+type droppingTheSkyService struct {
+	*dryRunTheSkyService
+	dropOnFrames map[int]error // Dark frame attempt number, counting from 1, to the error it fails with
+	darkAttempts int
+	connects     int
+	coolerStarts int
+}
+
+func (t *droppingTheSkyService) Connect(server string, port int) error {
+	t.connects++
+	return t.dryRunTheSkyService.Connect(server, port)
+}
+
+func (t *droppingTheSkyService) StartCooling(targetTemp float64) error {
+	t.coolerStarts++
+	return t.dryRunTheSkyService.StartCooling(targetTemp)
+}
+
+func (t *droppingTheSkyService) CaptureDarkFrame(binning int, seconds float64, downloadTime float64) error {
+	t.darkAttempts++
+	if err, ok := t.dropOnFrames[t.darkAttempts]; ok {
+		return err
+	}
+	return t.dryRunTheSkyService.CaptureDarkFrame(binning, seconds, downloadTime)
+}
+
+func TestRetry(t *testing.T) {
+	stateFilePath := filepath.Join(t.TempDir(), "retry")
+	viper.Set(config.StateFileSetting, stateFilePath)
+	viper.Set(config.VerbositySetting, 0)
+	viper.Set(config.UseCoolerSetting, true)
+	viper.Set(config.CoolToSetting, -10.0)
+	viper.Set(config.CoolStartTolSetting, 1.0)
+	viper.Set(config.CoolWaitMinutesSetting, 30)
+	viper.Set(config.StartPollSecondsSetting, 60)
+	viper.Set(config.AbortOnCoolingSetting, false)
+	viper.Set(config.ClearDoneSetting, false)
+	viper.Set(config.NoBiasSetting, false)
+	viper.Set(config.NoDarkSetting, false)
+	viper.Set(config.RetryAttemptsSetting, 3)
+	viper.Set(config.RetryBackoffSecondsSetting, 10)
+	viper.Set(config.RetryMaxBackoffSecondsSetting, 15)
+	viper.Set(config.RetryTransientSetting, config.DefaultTransientErrors)
+	t.Cleanup(func() {
+		viper.Set(config.RetryAttemptsSetting, 1)
+	})
+
+	newDroppingSession := func(t *testing.T, dropOnFrames map[int]error) (*Session, *droppingTheSkyService, *bytes.Buffer) {
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		stateFileService := NewStateFileService(stateFilePath, -10.0)
+		require.Nil(t, stateFileService.DeleteStateFile())
+		session.SetStateFileService(stateFileService)
+		theSkyService := &droppingTheSkyService{
+			dryRunTheSkyService: session.theSkyService.(*dryRunTheSkyService),
+			dropOnFrames:        dropOnFrames,
+		}
+		session.SetTheSkyService(theSkyService)
+		var events bytes.Buffer
+		session.SetEventLog(NewEventLogWriter(&events))
+		require.Nil(t, session.ConnectToServer())
+		return session, theSkyService, &events
+	}
+
+	t.Run("dropped connection is reconnected, cooler restarted, and no frames lost", func(t *testing.T) {
+		session, theSkyService, events := newDroppingSession(t, map[int]error{
+			2: errors.New("read tcp 192.168.1.20:3040: connection reset by peer"),
+			3: errors.New("dial tcp 192.168.1.20:3040: i/o timeout"),
+		})
+		err := session.CaptureFrames(context.Background(), true, nil, []string{"3,60,1"}, nil)
+		require.Nil(t, err)
+		require.Equal(t, 3, session.framesCaptured)
+		require.Equal(t, 5, theSkyService.darkAttempts)
+		require.Equal(t, 3, theSkyService.connects, "Initial connection plus one per drop")
+		require.Equal(t, 3, theSkyService.coolerStarts, "Cooler should be restarted after each reconnect")
+
+		plan, err := NewStateFileService(stateFilePath, -10.0).ReadStateFile()
+		require.Nil(t, err)
+		require.Equal(t, 3, plan.DarksDone[MakeDarkKey(60, 1, -10, config.CameraSettings{})])
+
+		var backoffs []float64
+		for _, event := range readEvents(t, events) {
+			if event["event"] == EventReconnect {
+				backoffs = append(backoffs, event["backoff_seconds"].(float64))
+				require.Equal(t, "capturing dark frame", event["request"])
+			}
+		}
+		require.Equal(t, []float64{10, 15}, backoffs, "Backoff doubles, up to the maximum")
+	})
+
+	t.Run("gives up after the maximum attempts", func(t *testing.T) {
+		dropped := errors.New("write tcp: broken pipe")
+		session, theSkyService, _ := newDroppingSession(t, map[int]error{1: dropped, 2: dropped, 3: dropped})
+		err := session.CaptureFrames(context.Background(), true, nil, []string{"3,60,1"}, nil)
+		require.True(t, errors.Is(err, dropped), "Expected the connection error, got %v", err)
+		require.Equal(t, 3, theSkyService.darkAttempts)
+		require.Equal(t, 0, session.framesCaptured)
+	})
+
+	t.Run("errors that aren't transient are not retried", func(t *testing.T) {
+		session, theSkyService, _ := newDroppingSession(t, map[int]error{
+			1: errors.New("TheSkyX error: camera not found"),
+		})
+		err := session.CaptureFrames(context.Background(), true, nil, []string{"3,60,1"}, nil)
+		require.NotNil(t, err)
+		require.Equal(t, 1, theSkyService.darkAttempts)
+		require.Equal(t, 1, theSkyService.connects)
+	})
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"github.com/spf13/viper"
+	"goskydarks/config"
+)
+
+// SetResult is what a capture run achieved for one frame set at one cooling temperature
+type SetResult struct {
+	SetProgress
+	Temperature float64 // Cooling target; meaningful only if Cooled
+	Cooled      bool
+	Captured    int // Frames captured by this run; the rest of Done are from earlier runs
+}
+
+// Results lists, in the order they were planned, every frame set the session has worked on,
+// with the progress made.  Sets at a temperature that was never reached are included, with
+// nothing captured; sets of a frame type excluded from the capture are not.
+func (s *Session) Results() []SetResult {
+	return s.results
+}
+
+// trackResults adds the sets in a newly made capture plan to the session's results, leaving out
+// those of frame types that won't be captured, as orderFrameSets does
+func (s *Session) trackResults(plan *CapturePlan) {
+	progress, err := PlanProgress(plan)
+	if err != nil {
+		s.logger.Errorf("Error in Session trackResults, summarizing plan: %v", err)
+		return
+	}
+	s.resultIndex = make(map[string]int, len(progress))
+	for _, set := range progress {
+		if frameTypeExcluded(set.FrameType) {
+			continue
+		}
+		s.resultIndex[set.Key] = len(s.results)
+		s.results = append(s.results, SetResult{
+			SetProgress: set,
+			Temperature: viper.GetFloat64(config.CoolToSetting),
+			Cooled:      viper.GetBool(config.UseCoolerSetting),
+		})
+	}
+}
+
+// updateResult counts a frame just captured in the given set, which now has done frames
+func (s *Session) updateResult(key string, done int) {
+	index, ok := s.resultIndex[key]
+	if !ok {
+		return
+	}
+	result := &s.results[index]
+	result.Captured++
+	result.Done = done
+	result.Remaining = max(result.Count-done, 0)
+}
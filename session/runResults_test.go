@@ -0,0 +1,80 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"goskydarks/config"
+	"io"
+	"testing"
+)
+
+// unreachableTheSkyService is a dry-run server that can't be connected to
+type unreachableTheSkyService struct {
+	*dryRunTheSkyService
+}
+
+func (t *unreachableTheSkyService) Connect(_ string, _ int) error {
+	return errors.New("dial tcp 192.168.1.20:3040: connect: no route to host")
+}
+
+func TestResults(t *testing.T) {
+
+	t.Run("counts frames captured this run, per set and temperature", func(t *testing.T) {
+		viper.Set(config.CoolWaitMinutesSetting, 5)
+		session, stateFilePath := newTemperatureTestSession(t)
+		earlier := NewStateFileService(stateFilePath, 15)
+		require.Nil(t, earlier.SavePlanToFile(&CapturePlan{
+			Temperature:   15,
+			DarksRequired: []string{"3,10,1"},
+			DarksDone:     map[string]int{MakeDarkKey(10, 1, 15, config.CameraSettings{}): 1},
+			BiasDone:      map[string]int{},
+			DownloadTimes: map[int]float64{1: 8},
+		}))
+
+		err := session.CaptureFramesAtTemperatures(context.Background(), true, []float64{15, -30}, []string{"2,1"}, []string{"3,10,1"}, nil)
+		require.True(t, errors.Is(err, ErrCoolingTimeout), "Expected cooling timeout, got %v", err)
+
+		results := session.Results()
+		require.Len(t, results, 4, "Each set at each temperature, including the unreached one")
+		require.Equal(t, 15.0, results[0].Temperature)
+		require.True(t, results[0].Cooled)
+		require.Equal(t, MakeDarkKey(10, 1, 15, config.CameraSettings{}), results[0].Key)
+		require.Equal(t, 2, results[0].Captured, "One of the three was done by an earlier run")
+		require.Equal(t, 3, results[0].Done)
+		require.Equal(t, 0, results[0].Remaining)
+		require.Equal(t, MakeBiasKey(1, 15, config.CameraSettings{}), results[1].Key)
+		require.Equal(t, 2, results[1].Captured)
+		require.Equal(t, -30.0, results[2].Temperature)
+		require.Equal(t, 0, results[2].Captured)
+		require.Equal(t, 3, results[2].Remaining)
+	})
+
+	t.Run("frame types excluded from the capture are left out", func(t *testing.T) {
+		viper.Set(config.CoolWaitMinutesSetting, 30)
+		session, _ := newTemperatureTestSession(t)
+		viper.Set(config.NoBiasSetting, true)
+		viper.Set(config.NoFlatDarkSetting, true)
+		defer viper.Set(config.NoBiasSetting, false)
+		defer viper.Set(config.NoFlatDarkSetting, false)
+
+		err := session.CaptureFramesAtTemperatures(context.Background(), true, []float64{-10}, []string{"2,1"}, []string{"3,10,1"}, []string{"2,0.5,1"})
+		require.Nil(t, err)
+
+		results := session.Results()
+		require.Len(t, results, 1, "Only the darks are captured")
+		require.Equal(t, MakeDarkKey(10, 1, -10, config.CameraSettings{}), results[0].Key)
+		require.Equal(t, 3, results[0].Captured)
+		require.Equal(t, 0, results[0].Remaining)
+	})
+
+	t.Run("connection failure is reported as such", func(t *testing.T) {
+		session, err := newDryRunSession(io.Discard)
+		require.Nil(t, err)
+		session.SetTheSkyService(&unreachableTheSkyService{session.theSkyService.(*dryRunTheSkyService)})
+		err = session.ConnectToServer()
+		require.True(t, errors.Is(err, ErrConnectionFailed), "Expected connection failure, got %v", err)
+		require.Empty(t, session.Results())
+	})
+}
@@ -1,12 +1,14 @@
 package session
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/RMcDOttawa/goMockableDelay"
 	"github.com/RMcDOttawa/goTheSkyX"
 	"github.com/spf13/viper"
 	"goskydarks/config"
+	"goskydarks/logging"
 	"math"
 	"time"
 )
@@ -14,28 +16,42 @@ import (
 // Session struct implements the session service, used for overall session control
 // such as start time or resuming from saved state
 type Session struct {
-	delayService     goMockableDelay.DelayService //	Used to delaypkg start; replace with mock for testing
-	theSkyService    goTheSkyX.TheSkyService
-	stateFileService StateFileService
-	isConnected      bool
+	logger              *logging.Logger
+	delayService        goMockableDelay.DelayService //	Used to delaypkg start; replace with mock for testing
+	theSkyService       goTheSkyX.TheSkyService
+	stateFileService    StateFileService
+	newStateFileService func(stateFilePath string, temperature float64) StateFileService // One per cooling temperature
+	eventLog            *EventLog                                                        // nil unless an event log was requested
+	isConnected         bool
+	reconnecting        bool // Re-establishing a dropped connection; requests made meanwhile aren't retried
+	framesCaptured      int  // Frames captured by this session, for the event log
+	results             []SetResult
+	resultIndex         map[string]int        // Position in results of each set at the current temperature
+	cameraSettings      config.CameraSettings // Gain, offset and readout mode last set on the camera
+	cameraSettingsSet   bool                  // False until camera settings have been set
+	flatDarkTagged      bool                  // The save prefix is set for flat-darks
+	untaggedSavePrefix  string                // Save prefix to put back once flat-darks are done
+	lastTemperature     *float64              // Sensor temperature last read at this cooling temperature, if any
+	runStarted          time.Time             // Start of the current CaptureFramesAtTemperatures run
 }
 
 func NewSession() (*Session, error) {
 	verbosity := viper.GetInt(config.VerbositySetting)
-	if verbosity >= 2 {
-		fmt.Println("Creating a new Frame Capture session")
-	}
-	concreteDelayService := goMockableDelay.NewDelayService(viper.GetBool(config.DebugSetting), verbosity)
+	logger := logging.Default()
+	logger.Informativef("Creating a new Frame Capture session")
+	concreteDelayService := newTimerDelayService(logger)
 	tsxService := goTheSkyX.NewTheSkyService(
 		concreteDelayService,
 		viper.GetBool(config.DebugSetting),
 		verbosity,
 	)
-	stateFileService := NewStateFileService(viper.GetString(config.StateFileSetting), viper.GetFloat64(config.CoolToSetting))
+	stateFileService := NewStateStore(viper.GetString(config.StateFileSetting), viper.GetFloat64(config.CoolToSetting))
 	session := &Session{
-		delayService:     concreteDelayService,
-		theSkyService:    tsxService,
-		stateFileService: stateFileService,
+		logger:              logger,
+		delayService:        concreteDelayService,
+		theSkyService:       &cameraSettingsTheSkyService{TheSkyService: tsxService},
+		stateFileService:    stateFileService,
+		newStateFileService: NewStateStore,
 	}
 	return session, nil
 }
@@ -49,11 +65,16 @@ func NewSession() (*Session, error) {
 // The download time is a linear function of the file size, which is a linear function of the binning factor,
 // so we will just keep a measure for each binning level
 type CapturePlan struct {
-	DarksRequired []string
-	BiasRequired  []string
-	DarksDone     map[string]int
-	BiasDone      map[string]int
-	DownloadTimes map[int]float64 // seconds, indexed by binning
+	Version           int     // layout of the state file the plan is saved in; see StateFileVersion
+	Temperature       float64 // cooling temperature the frames are captured at, part of each key
+	DarksRequired     []string
+	BiasRequired      []string
+	FlatDarksRequired []string
+	DarksDone         map[string]int
+	BiasDone          map[string]int
+	FlatDarksDone     map[string]int
+	DownloadTimes     map[int]float64 // seconds, indexed by binning
+	History           []FrameRecord   `json:",omitempty"` // every frame attempted, oldest first; see historyLog.go
 }
 
 // SetDelayService allows delaypkg service to be replaced with a mock for testing
@@ -61,6 +82,17 @@ func (s *Session) SetDelayService(delayService goMockableDelay.DelayService) {
 	s.delayService = delayService
 }
 
+// SetStateFileServiceFactory replaces the function used to make a state file service for each
+// cooling temperature, allowing it to be replaced with a mock for testing
+func (s *Session) SetStateFileServiceFactory(factory func(stateFilePath string, temperature float64) StateFileService) {
+	s.newStateFileService = factory
+}
+
+// SetLogger replaces the logger used for progress and diagnostic output
+func (s *Session) SetLogger(logger *logging.Logger) {
+	s.logger = logger
+}
+
 // SetTheSkyService allows theSky service to be replaced with a mock for testing
 func (s *Session) SetTheSkyService(theSkyService goTheSkyX.TheSkyService) {
 	s.theSkyService = theSkyService
@@ -71,30 +103,94 @@ func (s *Session) SetStateFileService(theStateFileService StateFileService) {
 	s.stateFileService = theStateFileService
 }
 
+// SetEventLog turns on recording of session events to the given event log
+func (s *Session) SetEventLog(eventLog *EventLog) {
+	s.eventLog = eventLog
+}
+
+// RecordSessionStart records the start of a capture session, with the frames requested, in the event log
+func (s *Session) RecordSessionStart(biasFrames []string, darkFrames []string, flatDarkFrames []string) {
+	s.eventLog.Record(EventSessionStart, map[string]interface{}{
+		"bias_frames":      biasFrames,
+		"dark_frames":      darkFrames,
+		"flat_dark_frames": flatDarkFrames,
+		"cool_to":          viper.GetFloat64(config.CoolToSetting),
+		"use_cooler":       viper.GetBool(config.UseCoolerSetting),
+	})
+}
+
+// RecordSessionEnd records the end of a capture session in the event log, with the error that ended it, if any
+func (s *Session) RecordSessionEnd(err error) {
+	fields := map[string]interface{}{
+		"frames_captured": s.framesCaptured,
+		"completed":       err == nil,
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	s.eventLog.Record(EventSessionEnd, fields)
+}
+
+// recordAbort records, in the event log, that the session is abandoning its work at the given stage
+func (s *Session) recordAbort(stage string, err error) {
+	s.eventLog.Record(EventAbort, map[string]interface{}{
+		"stage":  stage,
+		"reason": err.Error(),
+	})
+}
+
+// recordFrame records a captured frame in the event log, along with the sensor temperature read after
+// capture for the history, if there was one.
+func (s *Session) recordFrame(frameType string, key string, index int, exposure float64, binning int,
+	camera config.CameraSettings, temperature *float64) {
+	s.framesCaptured++
+	s.updateResult(key, index)
+	if s.eventLog == nil {
+		return
+	}
+	fields := map[string]interface{}{
+		"frame_type": frameType,
+		"key":        key,
+		"index":      index,
+		"exposure":   exposure,
+		"binning":    binning,
+	}
+	if !camera.IsEmpty() {
+		fields["camera"] = camera.String()
+	}
+	if temperature != nil {
+		fields["temperature"] = *temperature
+	}
+	s.eventLog.Record(EventFrame, fields)
+}
+
 // DelayStart optionally waits until a specified time before proceeding
 // This can be used to initiate a session early in the day but have collection wait until
 // later - perhaps when it is dark, or cooler
-func (s *Session) DelayStart(startTime time.Time) error {
-	fmt.Println("DelayStart to:", startTime)
-	return s.delayService.DelayUntil(startTime)
+func (s *Session) DelayStart(ctx context.Context, startTime time.Time) error {
+	s.logger.Minimalf("DelayStart to: %v", startTime)
+	return s.delayUntil(ctx, startTime)
 }
 
 // ConnectToServer opens the connection to the high-level communication service, keeping
 // it open for subsequent use
 func (s *Session) ConnectToServer() error {
-	if viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("Session/ConnectToServer entered")
-	}
+	s.logger.Debugf("Session/ConnectToServer entered")
 	if s.isConnected {
-		fmt.Println("Session already connected")
+		s.logger.Verbosef("Session already connected")
 		return nil
 	}
 
 	if err := s.theSkyService.Connect(viper.GetString(config.ServerAddressSetting),
 		viper.GetInt(config.ServerPortSetting)); err != nil {
-		fmt.Println("Error in Session ConnectToServer:", err)
-		return err
+		s.logger.Errorf("Error in Session ConnectToServer: %v", err)
+		s.recordAbort("connect", err)
+		return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
 	}
+	s.eventLog.Record(EventConnect, map[string]interface{}{
+		"server": viper.GetString(config.ServerAddressSetting),
+		"port":   viper.GetInt(config.ServerPortSetting),
+	})
 
 	// TheSky sometimes returns nonsense as its first transaction.  e.g. sometimes the first temperature
 	// read is -100, which is unlikely.  So we read and ignore the first temperature
@@ -108,15 +204,13 @@ func (s *Session) ConnectToServer() error {
 // Close finishes the session, including closing the communication service if it is open
 func (s *Session) Close() error {
 	if !s.isConnected {
-		fmt.Println("Session already disconnected")
+		s.logger.Verbosef("Session already disconnected")
 		return nil
 	}
-	if viper.GetInt(config.VerbositySetting) >= 3 {
-		fmt.Println("Session/Close closing session")
-	}
+	s.logger.Verbosef("Session/Close closing session")
 
 	if err := s.theSkyService.Close(); err != nil {
-		fmt.Println("Error in Session, closing theSky service:", err)
+		s.logger.Errorf("Error in Session, closing theSky service: %v", err)
 		return err
 	}
 	s.isConnected = false
@@ -126,36 +220,29 @@ func (s *Session) Close() error {
 // StartCoolingForStart turns on the camera cooler, if requested, and waits up to a maximum
 // amount of time for the camera to reach the specified target temperature
 func (s *Session) StartCoolingForStart() error {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	debug := viper.GetBool(config.DebugSetting)
 	//	Is the session open?
 	if !s.isConnected {
 		return errors.New("session not connected")
 	}
 	//	See if we are being asked to cool the camera at all
 	if !viper.GetBool(config.UseCoolerSetting) {
-		if verbosity >= 2 || debug {
-			fmt.Println("UseCooling is not on, so nothing to do")
-		}
+		s.logger.Informativef("UseCooling is not on, so nothing to do")
 		return nil
 	}
 	//	Cooling is requested.
 	//	Start the cooler and set the target temperature
 
 	coolTo := viper.GetFloat64(config.CoolToSetting)
-	if verbosity >= 2 {
-		fmt.Printf("Starting camera cooler with target temperature %.2f\n", coolTo)
-	}
+	s.logger.Informativef("Starting camera cooler with target temperature %.2f", coolTo)
 	if err := s.theSkyService.StartCooling(coolTo); err != nil {
-		fmt.Println("Error in Session/startCoolingForStart, starting cooler:", err)
+		s.logger.Errorf("Error in Session/startCoolingForStart, starting cooler: %v", err)
 		return err
 	}
+	s.eventLog.Record(EventCoolerStart, map[string]interface{}{"target": coolTo})
 
 	//	Wait until target temperature reached or time-out (too warm, can't cool that far)
 
-	if verbosity >= 4 {
-		fmt.Printf("Session/startCoolingForStart exits\n")
-	}
+	s.logger.Debugf("Session/startCoolingForStart exits")
 	return nil
 }
 
@@ -164,17 +251,11 @@ func (s *Session) StartCoolingForStart() error {
 //		every minute.  We end the loop in one of two ways:
 //		1. Success: the camera temperature is within the given tolerance of the target temperature
 //		2. Failure: we have waited a specified maximum number of minutes and still haven't reached target
-func (s *Session) WaitForTargetTemperature() error {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	debug := viper.GetBool(config.DebugSetting)
-	if verbosity >= 4 || debug {
-		fmt.Println("Session/WaitForTargetTemperature entered")
-	}
+func (s *Session) WaitForTargetTemperature(ctx context.Context) error {
+	s.logger.Debugf("Session/WaitForTargetTemperature entered")
 	// If we are not using the cooler we can exit immediately
 	if !viper.GetBool(config.UseCoolerSetting) {
-		if verbosity >= 3 || debug {
-			fmt.Println("Cooler not in use, so nothing to do")
-		}
+		s.logger.Verbosef("Cooler not in use, so nothing to do")
 		return nil
 	}
 	secondsElapsed := 0
@@ -182,34 +263,44 @@ func (s *Session) WaitForTargetTemperature() error {
 	coolStartPollSeconds := viper.GetInt(config.StartPollSecondsSetting)
 	target := viper.GetFloat64(config.CoolToSetting)
 	tolerance := viper.GetFloat64(config.CoolStartTolSetting)
-	if verbosity >= 2 || debug {
-		fmt.Printf("  Target temperature: %g, tolerance: %g, max wait: %d\n", target, tolerance, maximumSeconds)
-	}
+	s.logger.Informativef("  Target temperature: %g, tolerance: %g, max wait: %d", target, tolerance, maximumSeconds)
 
 	//	First temperature is sometimes nonsense, so read and ignore one
 	_, _ = s.theSkyService.GetCameraTemperature()
 	for {
 		//fmt.Println("Seconds elapsed waiting for cooling:", secondsElapsed)
+		if err := s.checkStop(ctx); err != nil {
+			return err
+		}
 		if secondsElapsed > maximumSeconds {
-			return errors.New("timed out waiting for target temperature")
+			return ErrCoolingTimeout
 		}
-		currentTemperature, err := s.theSkyService.GetCameraTemperature()
+		var currentTemperature float64
+		err := s.withRetry(ctx, "reading camera temperature", func() error {
+			var err error
+			currentTemperature, err = s.theSkyService.GetCameraTemperature()
+			return err
+		})
 		//fmt.Println("  Current temperature:", currentTemperature)
 		if err != nil {
-			fmt.Println("Error in Session WaitForTargetTemperature:", err)
+			s.logger.Errorf("Error in Session WaitForTargetTemperature: %v", err)
 			return err
 		}
+		s.eventLog.Record(EventTemperature, map[string]interface{}{
+			"phase":           "cooling_wait",
+			"temperature":     currentTemperature,
+			"target":          target,
+			"elapsed_seconds": secondsElapsed,
+		})
 		if math.Abs(currentTemperature-target) <= tolerance {
-			if verbosity >= 2 {
-				fmt.Printf("Current temperature %g is within tolerance %g of target %g\n", currentTemperature, tolerance, target)
-			}
+			s.logger.Informativef("Current temperature %g is within tolerance %g of target %g", currentTemperature, tolerance, target)
 			return nil
 		}
-		if verbosity >= 2 {
-			fmt.Printf("  Current camera temperature is %.1f, target is %.1f, waiting %d seconds for cooling to stabilize.\n",
-				currentTemperature, target, coolStartPollSeconds)
+		s.logger.Informativef("  Current camera temperature is %.1f, target is %.1f, waiting %d seconds for cooling to stabilize.", currentTemperature, target, coolStartPollSeconds)
+		waitedSeconds, err := s.delayDuration(ctx, coolStartPollSeconds)
+		if err != nil {
+			return err
 		}
-		waitedSeconds, err := s.delayService.DelayDuration(coolStartPollSeconds)
 		//fmt.Println("  Waited seconds:", waitedSeconds)
 		secondsElapsed = secondsElapsed + waitedSeconds
 	}
@@ -221,14 +312,15 @@ func (s *Session) WaitForTargetTemperature() error {
 // completed, that session is continued.  So the given bias and dark list represents the
 // total set of frames wanted - not necessarily the captures that will be done on this call
 func (s *Session) CaptureFrames(
+	ctx context.Context,
 	areDarksFirst bool,
 	biasFrames []string,
-	darkFrames []string) error {
-	if viper.GetInt(config.VerbositySetting) >= 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("Session/CaptureFrames entered")
-		fmt.Println("  bias frames:", biasFrames)
-		fmt.Println("  dark frames:", darkFrames)
-	}
+	darkFrames []string,
+	flatDarkFrames []string) error {
+	s.logger.Debugf("Session/CaptureFrames entered")
+	s.logger.Debugf("  bias frames: %v", biasFrames)
+	s.logger.Debugf("  dark frames: %v", darkFrames)
+	s.logger.Debugf("  flat-dark frames: %v", flatDarkFrames)
 
 	//	Is the session open?
 	if !s.isConnected {
@@ -236,203 +328,248 @@ func (s *Session) CaptureFrames(
 	}
 
 	//  Get plan for captures needed, including state of what is already done
-	capturePlan, err := s.getCapturePlan(biasFrames, darkFrames)
+	capturePlan, err := s.getCapturePlan(biasFrames, darkFrames, flatDarkFrames)
 	if err != nil {
-		fmt.Println("Error in Session CaptureFrames, getting capture plan:", err)
+		s.logger.Errorf("Error in Session CaptureFrames, getting capture plan: %v", err)
+		s.recordAbort("plan", err)
+		return err
+	}
+	s.trackResults(capturePlan)
+	if err := s.checkCameraSettingsSupported(capturePlan); err != nil {
+		s.logger.Errorf("Error in Session CaptureFrames: %v", err)
+		s.recordAbort("plan", err)
+		return err
+	}
+	if err := s.checkFlatDarkTagSupported(capturePlan); err != nil {
+		s.logger.Errorf("Error in Session CaptureFrames: %v", err)
+		s.recordAbort("plan", err)
 		return err
 	}
 
 	//	Start cooling the camera (if requested).
 	if err := s.StartCoolingForStart(); err != nil {
-		fmt.Println("Error in Session CaptureFrames, starting cooling", err)
+		s.logger.Errorf("Error in Session CaptureFrames, starting cooling %v", err)
+		s.recordAbort("cooler_start", err)
 		return err
 	}
 
 	//	Ensure we have download time measurements for all the binning factors we will use
 	//	Since measuring download times can take many seconds, we are doing it now, while
 	//	the camera cooler is bringing the camera down to temperature.
-	if err := s.updateDownloadTimes(capturePlan); err != nil {
-		fmt.Println("Error in Session CaptureFrames, updating download times")
+	if err := s.updateDownloadTimes(ctx, capturePlan); err != nil {
+		s.logger.Errorf("Error in Session CaptureFrames, updating download times")
+		s.saveInterruptedPlan(capturePlan, err)
+		s.recordAbort("download_time", err)
 		return err
 	}
 
 	//	Now we have nothing else we can do until we are at temperature, so we will
 	//	poll and wait until target temperature is reached.
-	if err := s.WaitForTargetTemperature(); err != nil {
-		fmt.Println("Error in Session CoolForStart, waiting for cooler:", err)
+	if err := s.WaitForTargetTemperature(ctx); err != nil {
+		s.logger.Errorf("Error in Session CoolForStart, waiting for cooler: %v", err)
+		s.saveInterruptedPlan(capturePlan, err)
+		s.recordAbort("cooling_wait", err)
 		return err
 	}
 
 	//	Capture frames as needed
-	if err := s.captureFrames(areDarksFirst, capturePlan); err != nil {
-		fmt.Println("Error in Session capturing frames")
+	err = s.captureFrames(ctx, areDarksFirst, capturePlan)
+	s.restoreSavePrefix()
+	if err != nil {
+		s.logger.Errorf("Error in Session capturing frames")
+		s.saveInterruptedPlan(capturePlan, err)
+		s.recordAbort("capture", err)
 		return err
 	}
 
 	//  Update the saved plan one last time (has been updated during capture)
 	if err := s.stateFileService.SavePlanToFile(capturePlan); err != nil {
-		fmt.Println("Error in Session saving capture plan")
+		s.logger.Errorf("Error in Session saving capture plan")
 		return err
 	}
 
-	if viper.GetInt(config.VerbositySetting) >= 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("Session/CaptureFrames exits")
-	}
+	s.logger.Debugf("Session/CaptureFrames exits")
 	return nil
 }
 
 func (s *Session) StopCooling() error {
 	if viper.GetBool(config.UseCoolerSetting) && viper.GetBool(config.CoolerOffAtEndSetting) {
 		if err := s.theSkyService.StopCooling(); err != nil {
-			fmt.Println("Error in Session StopCooling:", err)
+			s.logger.Errorf("Error in Session StopCooling: %v", err)
 			return err
 		}
-		if viper.GetInt(config.VerbositySetting) >= 2 {
-			fmt.Printf("Cooling switched off at end of session")
-		}
+		s.eventLog.Record(EventCoolerStop, nil)
+		s.logger.Informativef("Cooling switched off at end of session")
 	}
 	return nil
 }
 
+// PreviewCapturePlan returns the plan a capture would follow - the configured frames, updated
+// with the progress recorded in the state file - without connecting to the server
+func (s *Session) PreviewCapturePlan(biasFrames []string, darkFrames []string, flatDarkFrames []string) (*CapturePlan, error) {
+	return s.getCapturePlan(biasFrames, darkFrames, flatDarkFrames)
+}
+
 // getCapturePlan creates a plan for capturing the frames, based on the configuration and the state file
 // We start with a plan based on the config file, then update it to reflect work already done as recorded in
 //
 //	the state file.
 //	If the state file includes captures not in the current config, we ignore them - we are using only the "how many frames are done"
 //	info from the state file, plus the download times for each binning level that may be recorded
-func (s *Session) getCapturePlan(biasFrames []string, darkFrames []string) (*CapturePlan, error) {
-	if viper.GetInt(config.VerbositySetting) > 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("Session/getCapturePlan entered")
-		fmt.Println("  bias sets:", biasFrames)
-		fmt.Println("  dark sets:", darkFrames)
-	}
-	capturePlan, err := s.createPlanFromConfig(biasFrames, darkFrames)
+func (s *Session) getCapturePlan(biasFrames []string, darkFrames []string, flatDarkFrames []string) (*CapturePlan, error) {
+	s.logger.Tracef("Session/getCapturePlan entered")
+	s.logger.Tracef("  bias sets: %v", biasFrames)
+	s.logger.Tracef("  dark sets: %v", darkFrames)
+	s.logger.Tracef("  flat-dark sets: %v", flatDarkFrames)
+	capturePlan, err := s.createPlanFromConfig(biasFrames, darkFrames, flatDarkFrames)
 	if err != nil {
-		fmt.Println("error in Session getCapturePlan, creating plan from config:", err)
+		s.logger.Errorf("error in Session getCapturePlan, creating plan from config: %v", err)
 		return nil, err
 	}
-	if viper.GetInt(config.VerbositySetting) >= 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("Session/getCapturePlan got captureplan from createPlanFromConfig:", capturePlan)
-	}
+	s.logger.Debugf("Session/getCapturePlan got captureplan from createPlanFromConfig: %v", capturePlan)
 	err = s.stateFileService.UpdatePlanFromFile(capturePlan)
 	if err != nil {
-		fmt.Println("Error in Session getCapturePlan, updating plan from state file:", err)
+		s.logger.Errorf("Error in Session getCapturePlan, updating plan from state file: %v", err)
 		return nil, err
 	}
 
 	if viper.GetBool(config.ClearDoneSetting) {
-		for k := range capturePlan.DarksDone {
-			capturePlan.DarksDone[k] = 0
-		}
-		for k := range capturePlan.BiasDone {
-			capturePlan.BiasDone[k] = 0
-		}
-	}
-	if viper.GetInt(config.VerbositySetting) >= 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("Session/getCapturePlan exits, returning:")
-		fmt.Printf("  Capture plan: %#v\n", *capturePlan)
+		capturePlan.ClearDoneCounts()
 	}
+	s.logger.Debugf("Session/getCapturePlan exits, returning:")
+	s.logger.Debugf("  Capture plan: %#v", *capturePlan)
 	return capturePlan, nil
 }
 
-func (s *Session) createPlanFromConfig(biasSets []string, darkSets []string) (*CapturePlan, error) {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	if verbosity >= 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("createPlanFromConfig entered")
-		fmt.Println("  bias sets:", biasSets)
-		fmt.Println("  dark sets:", darkSets)
-	}
-	capturePlan := &CapturePlan{}
+func (s *Session) createPlanFromConfig(biasSets []string, darkSets []string, flatDarkSets []string) (*CapturePlan, error) {
+	s.logger.Debugf("createPlanFromConfig entered")
+	s.logger.Debugf("  bias sets: %v", biasSets)
+	s.logger.Debugf("  dark sets: %v", darkSets)
+	s.logger.Debugf("  flat-dark sets: %v", flatDarkSets)
+	capturePlan := &CapturePlan{Temperature: viper.GetFloat64(config.CoolToSetting)}
 
 	capturePlan.DarksRequired = darkSets
 	capturePlan.BiasRequired = biasSets
+	capturePlan.FlatDarksRequired = flatDarkSets
 
 	//	Create the empty maps for what is done and download time
 	capturePlan.DarksDone = make(map[string]int)
 	capturePlan.BiasDone = make(map[string]int)
+	capturePlan.FlatDarksDone = make(map[string]int)
 	capturePlan.DownloadTimes = make(map[int]float64)
 
 	//	Create a DownloadTime entry and zero the "done" count for every dark set
 	for _, darkSet := range darkSets {
-		if verbosity >= 4 {
-			fmt.Printf("Session/createPlanFromConfig creating downloadtime and done entry for dark set: %s\n", darkSet)
-		}
-		count, exposure, binning, err := config.ParseDarkSet(darkSet)
+		s.logger.Debugf("Session/createPlanFromConfig creating downloadtime and done entry for dark set: %s", darkSet)
+		frameSet, err := capturePlan.parseFrameSet("Dark", darkSet)
 		if err != nil {
-			fmt.Printf("Error in Session createPlanFromConfig, parsing dark set %s: %s\n:", darkSet, err)
+			s.logger.Errorf("Error in Session createPlanFromConfig, parsing dark set %s: %s", darkSet, err)
 			return nil, err
 		}
-		key := MakeDarkKey(count, exposure, binning)
-		capturePlan.DarksDone[key] = 0
+		capturePlan.DarksDone[frameSet.Key] = 0
 
-		if _, ok := capturePlan.DownloadTimes[binning]; !ok {
-			capturePlan.DownloadTimes[binning] = 0
+		if _, ok := capturePlan.DownloadTimes[frameSet.Binning]; !ok {
+			capturePlan.DownloadTimes[frameSet.Binning] = 0
 		}
 	}
 
 	//	Create a DownloadTime entry and zero the "done" count for every bias dark set
 	for _, biasSet := range biasSets {
-		if verbosity >= 4 {
-			fmt.Printf("Session/createPlanFromConfig creating downloadtime and done entry for bias set: %s\n", biasSet)
-		}
-		count, binning, err := config.ParseBiasSet(biasSet)
+		s.logger.Debugf("Session/createPlanFromConfig creating downloadtime and done entry for bias set: %s", biasSet)
+		frameSet, err := capturePlan.parseFrameSet("Bias", biasSet)
 		if err != nil {
-			fmt.Printf("Error in Session createPlanFromConfig, parsing bias set %s: %s\n", biasSet, err)
+			s.logger.Errorf("Error in Session createPlanFromConfig, parsing bias set %s: %s", biasSet, err)
 			return nil, err
 		}
-		key := MakeBiasKey(count, binning)
-		capturePlan.BiasDone[key] = 0
+		capturePlan.BiasDone[frameSet.Key] = 0
 
-		if _, ok := capturePlan.DownloadTimes[binning]; !ok {
-			capturePlan.DownloadTimes[binning] = 0
+		if _, ok := capturePlan.DownloadTimes[frameSet.Binning]; !ok {
+			capturePlan.DownloadTimes[frameSet.Binning] = 0
 		}
 	}
-	if verbosity >= 4 || viper.GetBool(config.DebugSetting) {
-		fmt.Println("createPlanFromConfig exits")
-		fmt.Printf("  capture plan: %#v\n", capturePlan)
+	//	Create a DownloadTime entry and zero the "done" count for every flat-dark set
+	for _, flatDarkSet := range flatDarkSets {
+		s.logger.Debugf("Session/createPlanFromConfig creating downloadtime and done entry for flat-dark set: %s", flatDarkSet)
+		frameSet, err := capturePlan.parseFrameSet("FlatDark", flatDarkSet)
+		if err != nil {
+			s.logger.Errorf("Error in Session createPlanFromConfig, parsing flat-dark set %s: %s", flatDarkSet, err)
+			return nil, err
+		}
+		capturePlan.FlatDarksDone[frameSet.Key] = 0
+
+		if _, ok := capturePlan.DownloadTimes[frameSet.Binning]; !ok {
+			capturePlan.DownloadTimes[frameSet.Binning] = 0
+		}
 	}
+	s.logger.Debugf("createPlanFromConfig exits")
+	s.logger.Debugf("  capture plan: %#v", capturePlan)
 
 	return capturePlan, nil
 }
 
-func MakeDarkKey(count int, exposure float64, binning int) string {
-	return fmt.Sprintf("Dark_%d_%.4f_%d", count, exposure, binning)
+//	State file keys identify the frames in a set - their type, exposure, binning, cooling temperature
+//	and any camera settings - but not how many are wanted, so changing a set's count keeps the frames
+//	already done.  Camera settings, if the set has any, are added at the end.
+
+func MakeDarkKey(exposure float64, binning int, temperature float64, camera config.CameraSettings) string {
+	return config.FrameKey("Dark", exposure, binning, temperature, camera)
 }
 
-func MakeBiasKey(count int, binning int) string {
-	return fmt.Sprintf("Bias_%d_%d", count, binning)
+func MakeBiasKey(binning int, temperature float64, camera config.CameraSettings) string {
+	return config.FrameKey("Bias", 0, binning, temperature, camera)
 }
 
-func (s *Session) updateDownloadTimes(capturePlan *CapturePlan) error {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	debug := viper.GetBool(config.DebugSetting)
-	if debug || verbosity >= 4 {
-		fmt.Printf("updateDownloadTimes. CapturePlan: %#v\n", *capturePlan)
-	}
+func MakeFlatDarkKey(exposure float64, binning int, temperature float64, camera config.CameraSettings) string {
+	return config.FrameKey("FlatDark", exposure, binning, temperature, camera)
+}
+
+func (s *Session) updateDownloadTimes(ctx context.Context, capturePlan *CapturePlan) error {
+	s.logger.Debugf("updateDownloadTimes. CapturePlan: %#v", *capturePlan)
 	for binning, seconds := range capturePlan.DownloadTimes {
 		//fmt.Printf("  Binning %d, download time %g\n", binning, seconds)
 		if seconds == 0 {
-			if verbosity >= 2 || debug {
-				fmt.Printf("Measuring download time for binning %d\n", binning)
+			if err := s.checkStop(ctx); err != nil {
+				return err
 			}
-			measuredTime, err := s.theSkyService.MeasureDownloadTime(binning)
+			s.logger.Informativef("Measuring download time for binning %d", binning)
+			var measuredTime float64
+			err := s.withRetry(ctx, "measuring download time", func() error {
+				var err error
+				measuredTime, err = s.theSkyService.MeasureDownloadTime(binning)
+				return err
+			})
 			if err != nil {
 				return errors.New("error measuring download time")
 			}
 			capturePlan.DownloadTimes[binning] = measuredTime
+			s.eventLog.Record(EventDownloadTime, map[string]interface{}{
+				"binning": binning,
+				"seconds": measuredTime,
+			})
 		}
 	}
-	if debug || verbosity >= 4 {
-		fmt.Printf("  updateDownloadTimes exits, Download times now %#v\n", capturePlan.DownloadTimes)
-	}
+	s.logger.Debugf("  updateDownloadTimes exits, Download times now %#v", capturePlan.DownloadTimes)
 	return nil
 }
 
-func (s *Session) captureFrames(careDarksFirst bool, capturePlan *CapturePlan) error {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	debug := viper.GetBool(config.DebugSetting)
-	if verbosity >= 4 || debug {
-		fmt.Printf("captureFrames. CapturePlan: %#v\n", *capturePlan)
+func (s *Session) captureFrames(ctx context.Context, careDarksFirst bool, capturePlan *CapturePlan) error {
+	s.logger.Debugf("captureFrames. CapturePlan: %#v", *capturePlan)
+	order, err := config.CaptureOrder()
+	if err != nil {
+		return err
+	}
+
+	if order != config.CaptureOrderByType {
+		return s.captureFramesInOrder(ctx, careDarksFirst, order, capturePlan)
+	}
+
+	//	Flat-darks are done before or after both the darks and bias frames
+	flatDarksFirst := viper.GetBool(config.FlatDarkFirstSetting)
+	if flatDarksFirst {
+		if err := s.captureFlatDarkFrames(ctx, capturePlan); err != nil {
+			s.logger.Errorf("Error in Session captureFrames, capturing flat-dark frames: %v", err)
+			return err
+		}
 	}
 
 	//	We might be asked to do either the dark or bias frames first
@@ -440,197 +577,182 @@ func (s *Session) captureFrames(careDarksFirst bool, capturePlan *CapturePlan) e
 	darksThisPass := careDarksFirst
 	for i := 0; i < 2; i++ {
 		if darksThisPass {
-			if err := s.captureDarkFrames(capturePlan); err != nil {
-				fmt.Println("Error in Session captureFrames, capturing dark frames:", err)
+			if err := s.captureDarkFrames(ctx, capturePlan); err != nil {
+				s.logger.Errorf("Error in Session captureFrames, capturing dark frames: %v", err)
 				return err
 			}
 		} else {
-			if err := s.captureBiasFrames(capturePlan); err != nil {
-				fmt.Println("Error in Session captureFrames, capturing dark frames:", err)
+			if err := s.captureBiasFrames(ctx, capturePlan); err != nil {
+				s.logger.Errorf("Error in Session captureFrames, capturing dark frames: %v", err)
 				return err
 			}
 		}
 		darksThisPass = !darksThisPass
 	}
+
+	if !flatDarksFirst {
+		if err := s.captureFlatDarkFrames(ctx, capturePlan); err != nil {
+			s.logger.Errorf("Error in Session captureFrames, capturing flat-dark frames: %v", err)
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Session) captureDarkFrames(capturePlan *CapturePlan) error {
-	if viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("captureDarkFrames ")
-		fmt.Printf("   Frames required: %v\n", capturePlan.DarksRequired)
-		fmt.Printf("   Frames done: %v\n", capturePlan.DarksDone)
-		fmt.Printf("   Download times: %v\n", capturePlan.DownloadTimes)
-	}
+func (s *Session) captureDarkFrames(ctx context.Context, capturePlan *CapturePlan) error {
+	s.logger.Debugf("captureDarkFrames ")
+	s.logger.Debugf("   Frames required: %v", capturePlan.DarksRequired)
+	s.logger.Debugf("   Frames done: %v", capturePlan.DarksDone)
+	s.logger.Debugf("   Download times: %v", capturePlan.DownloadTimes)
 	if viper.GetBool(config.NoDarkSetting) {
-		if viper.GetInt(config.VerbositySetting) >= 2 {
-			fmt.Println("nodark flag, skipping dark frames")
-		}
+		s.logger.Informativef("nodark flag, skipping dark frames")
 		return nil
 	}
 	for _, set := range capturePlan.DarksRequired {
 		//fmt.Printf("   Checking dark set %s: %v\n", key, set)
-		if err := s.captureDarkSet(capturePlan, set); err != nil {
-			fmt.Println("Error in Session captureDarkFrames, capturing dark set:", err)
+		if err := s.captureDarkSet(ctx, capturePlan, set); err != nil {
+			s.logger.Errorf("Error in Session captureDarkFrames, capturing dark set: %v", err)
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Session) captureDarkSet(plan *CapturePlan, set string) error {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	debug := viper.GetBool(config.DebugSetting)
-	count, exposure, binning, err := config.ParseDarkSet(set)
-	if verbosity >= 1 || debug {
-		fmt.Printf("Handling dark frames set: %d frames of %.1f seconds binned %d\n", count, exposure, binning)
-	}
+func (s *Session) captureDarkSet(ctx context.Context, plan *CapturePlan, set string) error {
+	frameSet, err := plan.parseFrameSet("Dark", set)
 	if err != nil {
-		fmt.Println("Error in Session captureDarkSet, parsing dark set:", err)
+		s.logger.Errorf("Error in Session captureDarkSet, parsing dark set: %v", err)
 		return err
 	}
-	key := MakeDarkKey(count, exposure, binning)
+	count, key := frameSet.Count, frameSet.Key
+	s.logger.Minimalf("Handling dark frames set: %d frames of %.1f seconds binned %d%s",
+		count, frameSet.Exposure, frameSet.Binning, cameraNote(frameSet.Camera))
 	if plan.DarksDone[key] >= count {
-		if verbosity > 1 {
-			fmt.Printf("  Already have all %d dark frames in set %s\n", count, key)
-		}
+		s.logger.Informativef("  Already have all %d dark frames in set %s", count, key)
 		return nil
 	}
 
 	framesNeeded := count - plan.DarksDone[key]
 	if framesNeeded > 0 {
-		if verbosity >= 2 {
-			fmt.Printf("  Still need %d dark frames (of %d) in set %s\n", framesNeeded, count, key)
-		}
+		s.logger.Informativef("  Still need %d dark frames (of %d) in set %s", framesNeeded, count, key)
 	}
-	frameCount := 0
 	for plan.DarksDone[key] < count {
-		abandon, err := s.CheckAbandonForCooling()
-		if err != nil {
-			fmt.Println("Error in Session captureDarkSet, checking for cooling abandon:", err)
+		if err := s.captureSetFrame(ctx, plan, frameSet); err != nil {
 			return err
 		}
-		if abandon {
-			const message = "abandoning dark frame capture due to temperature exceeding cooling tolerance"
-			fmt.Println(message)
-			return errors.New(message)
-		}
-
-		frameCount++
-		if verbosity >= 2 {
-			fmt.Printf("    Capturing dark frame %d of %d:  %.2f seconds binned %d\n", frameCount, framesNeeded, exposure, binning)
-		}
+	}
+	return nil
+}
 
-		if err := s.theSkyService.CaptureDarkFrame(binning, exposure, plan.DownloadTimes[binning]); err != nil {
-			fmt.Println("Error in Session captureDarkSet, capturing dark frame:", err)
+func (s *Session) captureFlatDarkFrames(ctx context.Context, capturePlan *CapturePlan) error {
+	s.logger.Debugf("captureFlatDarkFrames ")
+	s.logger.Debugf("   Frames required: %v", capturePlan.FlatDarksRequired)
+	s.logger.Debugf("   Frames done: %v", capturePlan.FlatDarksDone)
+	if viper.GetBool(config.NoFlatDarkSetting) {
+		s.logger.Informativef("noflatdark flag, skipping flat-dark frames")
+		return nil
+	}
+	for _, set := range capturePlan.FlatDarksRequired {
+		if err := s.captureFlatDarkSet(ctx, capturePlan, set); err != nil {
+			s.logger.Errorf("Error in Session captureFlatDarkFrames, capturing flat-dark set: %v", err)
 			return err
 		}
-		plan.DarksDone[key]++
-		if err := s.stateFileService.SavePlanToFile(plan); err != nil {
-			fmt.Println("Error in Session captureDarkSet, saving plan:", err)
+	}
+	return nil
+}
+
+func (s *Session) captureFlatDarkSet(ctx context.Context, plan *CapturePlan, set string) error {
+	frameSet, err := plan.parseFrameSet("FlatDark", set)
+	if err != nil {
+		s.logger.Errorf("Error in Session captureFlatDarkSet, parsing flat-dark set: %v", err)
+		return err
+	}
+	count, key := frameSet.Count, frameSet.Key
+	s.logger.Minimalf("Handling flat-dark frames set: %d frames of %.2f seconds binned %d%s",
+		count, frameSet.Exposure, frameSet.Binning, cameraNote(frameSet.Camera))
+	if plan.FlatDarksDone[key] >= count {
+		s.logger.Informativef("  Already have all %d flat-dark frames in set %s", count, key)
+		return nil
+	}
+	s.logger.Informativef("  Still need %d flat-dark frames (of %d) in set %s", count-plan.FlatDarksDone[key], count, key)
+
+	for plan.FlatDarksDone[key] < count {
+		if err := s.captureSetFrame(ctx, plan, frameSet); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Session) captureBiasFrames(capturePlan *CapturePlan) error {
-	if viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("captureBiasFrames ")
-		fmt.Printf("   Frames required: %v\n", capturePlan.BiasRequired)
-		fmt.Printf("   Frames done: %v\n", capturePlan.BiasRequired)
-		fmt.Printf("   Download times: %v\n", capturePlan.BiasRequired)
-	}
+func (s *Session) captureBiasFrames(ctx context.Context, capturePlan *CapturePlan) error {
+	s.logger.Debugf("captureBiasFrames ")
+	s.logger.Debugf("   Frames required: %v", capturePlan.BiasRequired)
+	s.logger.Debugf("   Frames done: %v", capturePlan.BiasRequired)
+	s.logger.Debugf("   Download times: %v", capturePlan.BiasRequired)
 	if viper.GetBool(config.NoBiasSetting) {
-		if viper.GetInt(config.VerbositySetting) >= 2 {
-			fmt.Println("nodark flag, skipping bias frames")
-		}
+		s.logger.Informativef("nodark flag, skipping bias frames")
 		return nil
 	}
 	for _, set := range capturePlan.BiasRequired {
 		//fmt.Printf("   Checking bias set %s: %v\n", key, set)
-		if err := s.captureBiasSet(capturePlan, set); err != nil {
-			fmt.Println("Error in Session captureBiasFrames, capturing bias set:", err)
+		if err := s.captureBiasSet(ctx, capturePlan, set); err != nil {
+			s.logger.Errorf("Error in Session captureBiasFrames, capturing bias set: %v", err)
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Session) captureBiasSet(plan *CapturePlan, set string) error {
-	verbosity := viper.GetInt(config.VerbositySetting)
-	debug := viper.GetBool(config.DebugSetting)
-	count, binning, err := config.ParseBiasSet(set)
+func (s *Session) captureBiasSet(ctx context.Context, plan *CapturePlan, set string) error {
+	frameSet, err := plan.parseFrameSet("Bias", set)
 	if err != nil {
-		fmt.Println("Error in Session captureBiasSet, parsing bias set:", err)
+		s.logger.Errorf("Error in Session captureBiasSet, parsing bias set: %v", err)
 		return err
 	}
-	if verbosity >= 1 || debug {
-		fmt.Printf("Handling bias frames set: %d frames  binned %d\n", count, binning)
-	}
-	key := MakeBiasKey(count, binning)
+	count, key := frameSet.Count, frameSet.Key
+	s.logger.Minimalf("Handling bias frames set: %d frames  binned %d%s", count, frameSet.Binning, cameraNote(frameSet.Camera))
 	if plan.BiasDone[key] >= count {
-		if verbosity >= 2 {
-			fmt.Printf("  Already have all %d bias frames in set %s\n", count, key)
-		}
+		s.logger.Informativef("  Already have all %d bias frames in set %s", count, key)
 		return nil
 	}
 
 	framesNeeded := count - plan.BiasDone[key]
 	if framesNeeded > 0 {
-		if verbosity >= 2 {
-			fmt.Printf("  Still need %d bias frames (of %d) in set %s\n", framesNeeded, count, key)
-		}
+		s.logger.Informativef("  Still need %d bias frames (of %d) in set %s", framesNeeded, count, key)
 	}
-	frameCount := 0
 	for plan.BiasDone[key] < count {
-		abandon, err := s.CheckAbandonForCooling()
-		if err != nil {
-			fmt.Println("Error in Session captureBiasSet, checking for cooling abandon:", err)
-			return err
-		}
-		if abandon {
-			const message = "abandoning bias frame capture due to temperature exceeding cooling tolerance"
-			fmt.Println(message)
-			return errors.New(message)
-		}
-
-		frameCount++
-		if verbosity >= 2 {
-			fmt.Printf("    Capturing bias frame %d of %d, binned %d\n", frameCount, framesNeeded, binning)
-		}
-
-		if err := s.theSkyService.CaptureBiasFrame(binning, plan.DownloadTimes[binning]); err != nil {
-			fmt.Println("Error in Session captureBiasSet, capturing bias frame:", err)
-			return err
-		}
-		plan.BiasDone[key]++
-		if err := s.stateFileService.SavePlanToFile(plan); err != nil {
-			fmt.Println("Error in Session captureBiasSet, saving plan:", err)
+		if err := s.captureSetFrame(ctx, plan, frameSet); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Session) CheckAbandonForCooling() (bool, error) {
-	if viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("CheckAbandonForCooling")
-	}
+func (s *Session) CheckAbandonForCooling(ctx context.Context) (bool, error) {
+	s.logger.Debugf("CheckAbandonForCooling")
 	if !viper.GetBool(config.UseCoolerSetting) {
 		return false, nil
 	}
 	if !viper.GetBool(config.AbortOnCoolingSetting) {
 		return false, nil
 	}
-	cameraTemperature, err := s.theSkyService.GetCameraTemperature()
-	if viper.GetInt(config.VerbositySetting) >= 4 {
-		fmt.Println("  Camera temperature:", cameraTemperature)
-	}
+	var cameraTemperature float64
+	err := s.withRetry(ctx, "reading camera temperature", func() error {
+		var err error
+		cameraTemperature, err = s.theSkyService.GetCameraTemperature()
+		return err
+	})
+	s.logger.Debugf("  Camera temperature: %v", cameraTemperature)
 	if err != nil {
-		fmt.Println("Error in Session CheckAbandonForCooling, getting camera temperature:", err)
+		s.logger.Errorf("Error in Session CheckAbandonForCooling, getting camera temperature: %v", err)
 		return false, err
 	}
+	s.lastTemperature = &cameraTemperature
+	s.eventLog.Record(EventTemperature, map[string]interface{}{
+		"phase":       "abort_check",
+		"temperature": cameraTemperature,
+		"target":      viper.GetFloat64(config.CoolToSetting),
+	})
 	variation := math.Abs(cameraTemperature - viper.GetFloat64(config.CoolToSetting))
 	//fmt.Printf("  Temp %g and target %g = variation %g\n", cameraTemperature, coolingConfig.CoolTo, variation)
 	if variation >= viper.GetFloat64(config.CoolAbortTolSetting) {
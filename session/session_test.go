@@ -1,6 +1,7 @@
 package session
 
 import (
+	"context"
 	"github.com/RMcDOttawa/goMockableDelay"
 	"github.com/RMcDOttawa/goTheSkyX"
 	"github.com/golang/mock/gomock"
@@ -58,7 +59,7 @@ func TestCoolForStart(t *testing.T) {
 
 		err = session.StartCoolingForStart()
 		require.Nil(t, err, "Can't cool for start")
-		err = session.WaitForTargetTemperature()
+		err = session.WaitForTargetTemperature(context.Background())
 		require.Nil(t, err, "Error waiting to reach target temperature")
 
 		err = session.Close()
@@ -107,7 +108,7 @@ func TestCoolForStart(t *testing.T) {
 
 		err = session.StartCoolingForStart()
 		require.Nil(t, err, "Can't begin cooling for start")
-		err = session.WaitForTargetTemperature()
+		err = session.WaitForTargetTemperature(context.Background())
 		require.Nil(t, err, "Error waiting to reach target temperature")
 
 		err = session.Close()
@@ -152,7 +153,7 @@ func TestCoolForStart(t *testing.T) {
 
 		err = session.StartCoolingForStart()
 		require.Nil(t, err, "Can't begin cooling for start")
-		err = session.WaitForTargetTemperature()
+		err = session.WaitForTargetTemperature(context.Background())
 		require.NotNil(t, err, "Expected cooling to fail on timeout")
 		require.ErrorContains(t, err, "timed out")
 
@@ -196,12 +197,13 @@ func TestDarkFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 dark frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{},
 			DarksDone:     darksDone,
@@ -215,7 +217,7 @@ func TestDarkFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureDarkFrame(1, 5.0, 5.0).Return(nil)
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureDarkFrames(capturePlan)
+		err = session.captureDarkFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Dark frame capture should not report error")
 	})
 
@@ -247,12 +249,13 @@ func TestDarkFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 dark frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{},
 			DarksDone:     darksDone,
@@ -266,12 +269,12 @@ func TestDarkFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureDarkFrame(1, 5.0, 5.0).Return(nil)
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureDarkFrames(capturePlan)
+		err = session.captureDarkFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Dark frame capture should not report error")
 		require.Equal(t, 1, len(capturePlan.DarksDone), "Should have 1 darksDone entry")
-		require.Equal(t, 3, capturePlan.DarksDone[MakeDarkKey(3, 5.0, 1)], "Should have 3 dark frames done")
+		require.Equal(t, 3, capturePlan.DarksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})], "Should have 3 dark frames done")
 		require.Equal(t, 1, len(capturePlan.BiasDone), "Should have one biasDone entry")
-		require.Equal(t, 0, capturePlan.BiasDone[MakeBiasKey(3, 1)], "Should have 0 bias frames done")
+		require.Equal(t, 0, capturePlan.BiasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})], "Should have 0 bias frames done")
 	})
 
 	t.Run("Capture remaining frames - state file says some are done", func(t *testing.T) {
@@ -302,12 +305,13 @@ func TestDarkFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 dark frames, of which 1 is already done, so only 2 more need to be captured
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 1 // 1 frame already done
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 1 // 1 frame already done
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{},
 			DarksDone:     darksDone,
@@ -320,7 +324,7 @@ func TestDarkFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureDarkFrame(1, 5.0, 5.0).Return(nil)
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureDarkFrames(capturePlan)
+		err = session.captureDarkFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Dark frame capture should not report error")
 	})
 
@@ -355,12 +359,13 @@ func TestDarkFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 dark frames, of which 1 is already done, so only 2 more need to be captured
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 1 // 1 frame already done
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 1 // 1 frame already done
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{},
 			DarksDone:     darksDone,
@@ -372,9 +377,10 @@ func TestDarkFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureDarkFrame(1, 5.0, 5.0).AnyTimes().Return(nil)
 		// Mock temperature rising beyond the tolerance after one successful frame
 		mockTheSkyService.EXPECT().GetCameraTemperature().Return(-10.0, nil)
+		mockTheSkyService.EXPECT().GetCameraTemperature().Return(-10.0, nil) // After the frame, for its history
 		mockTheSkyService.EXPECT().GetCameraTemperature().Return(-7.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureDarkFrames(capturePlan)
+		err = session.captureDarkFrames(context.Background(), capturePlan)
 		require.NotNil(t, err, "Dark frame capture should report error")
 		require.ErrorContains(t, err, "exceeding cooling tolerance", "Error message should contain 'exceeding cooling tolerance'")
 	})
@@ -404,12 +410,13 @@ func TestDarkFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 dark frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{},
 			DarksDone:     darksDone,
@@ -420,7 +427,7 @@ func TestDarkFrameCapture(t *testing.T) {
 		//	Set up mock expects
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureDarkFrames(capturePlan)
+		err = session.captureDarkFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Dark frame capture should not report error")
 	})
 }
@@ -461,12 +468,13 @@ func TestBiasFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 bias frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -480,7 +488,7 @@ func TestBiasFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).Return(nil)
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureBiasFrames(capturePlan)
+		err = session.captureBiasFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Bias frame capture should not report error")
 	})
 
@@ -511,12 +519,13 @@ func TestBiasFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 bias frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -530,12 +539,12 @@ func TestBiasFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).Return(nil)
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureBiasFrames(capturePlan)
+		err = session.captureBiasFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Bias frame capture should not report error")
 		require.Equal(t, 1, len(capturePlan.DarksDone), "Should have 1 darksDone entry")
-		require.Equal(t, 0, capturePlan.DarksDone[MakeDarkKey(3, 5.0, 1)], "Should have 0 dark frames done")
+		require.Equal(t, 0, capturePlan.DarksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})], "Should have 0 dark frames done")
 		require.Equal(t, 1, len(capturePlan.BiasDone), "Should have one biasDone entry")
-		require.Equal(t, 3, capturePlan.BiasDone[MakeBiasKey(3, 1)], "Should have 3 bias frames done")
+		require.Equal(t, 3, capturePlan.BiasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})], "Should have 3 bias frames done")
 	})
 
 	t.Run("Capture remaining frames - state file says some are done", func(t *testing.T) {
@@ -567,12 +576,13 @@ func TestBiasFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 bias frames, of which 1 is already done, so only 2 more need to be captured
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0 // 1 frame already done
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0 // 1 frame already done
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 1
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 1
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -583,8 +593,9 @@ func TestBiasFrameCapture(t *testing.T) {
 		//	Set up mock expects. Now there should only be 2 captures because 1 is done
 		mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).Return(nil)
 		mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).Return(nil)
+		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureBiasFrames(capturePlan)
+		err = session.captureBiasFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Bias frame capture should not report error")
 	})
 
@@ -617,12 +628,13 @@ func TestBiasFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 dark frames, of which 1 is already done, so only 2 more need to be captured
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0 // 1 frame already done
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0 // 1 frame already done
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{""},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -634,9 +646,10 @@ func TestBiasFrameCapture(t *testing.T) {
 		mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).AnyTimes().Return(nil)
 		// Mock temperature rising beyond the tolerance after one successful frame
 		mockTheSkyService.EXPECT().GetCameraTemperature().Return(-10.0, nil)
+		mockTheSkyService.EXPECT().GetCameraTemperature().Return(-10.0, nil) // After the frame, for its history
 		mockTheSkyService.EXPECT().GetCameraTemperature().Return(-7.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureBiasFrames(capturePlan)
+		err = session.captureBiasFrames(context.Background(), capturePlan)
 		require.NotNil(t, err, "Bias frame capture should report error")
 		require.ErrorContains(t, err, "exceeding cooling tolerance", "Error message should contain 'exceeding cooling tolerance'")
 	})
@@ -666,12 +679,13 @@ func TestBiasFrameCapture(t *testing.T) {
 
 		//	Set up plan for 3 bias frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -682,7 +696,7 @@ func TestBiasFrameCapture(t *testing.T) {
 		//	Set up mock expects
 		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureBiasFrames(capturePlan)
+		err = session.captureBiasFrames(context.Background(), capturePlan)
 		require.Nil(t, err, "Bias frame capture should not report error")
 	})
 }
@@ -717,12 +731,13 @@ func TestCaptureOrder(t *testing.T) {
 
 		//	Set up plan for 3 bias frames and 3 dark frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -735,8 +750,9 @@ func TestCaptureOrder(t *testing.T) {
 			mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).Times(3).Return(nil),
 			mockTheSkyService.EXPECT().CaptureDarkFrame(1, 5.0, 5.0).Times(3).Return(nil),
 		)
+		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureFrames(false, capturePlan)
+		err = session.captureFrames(context.Background(), false, capturePlan)
 		require.Nil(t, err, "Bias frame capture should not report error")
 
 	})
@@ -766,12 +782,13 @@ func TestCaptureOrder(t *testing.T) {
 
 		//	Set up plan for 3 bias frames and 3 dark frames
 		darksDone := make(map[string]int)
-		darksDone[MakeDarkKey(3, 5.0, 1)] = 0
+		darksDone[MakeDarkKey(5.0, 1, targetTemperature, config.CameraSettings{})] = 0
 		biasDone := make(map[string]int)
-		biasDone[MakeBiasKey(3, 1)] = 0
+		biasDone[MakeBiasKey(1, targetTemperature, config.CameraSettings{})] = 0
 		downloadTimes := make(map[int]float64)
 		downloadTimes[1] = 5.0
 		capturePlan := &CapturePlan{
+			Temperature:   targetTemperature,
 			DarksRequired: []string{"3,5.0,1"},
 			BiasRequired:  []string{"3,1"},
 			DarksDone:     darksDone,
@@ -784,8 +801,9 @@ func TestCaptureOrder(t *testing.T) {
 			mockTheSkyService.EXPECT().CaptureDarkFrame(1, 5.0, 5.0).Times(3).Return(nil),
 			mockTheSkyService.EXPECT().CaptureBiasFrame(1, 5.0).Times(3).Return(nil),
 		)
+		mockTheSkyService.EXPECT().GetCameraTemperature().AnyTimes().Return(-10.0, nil)
 		mockStateFileService.EXPECT().SavePlanToFile(capturePlan).AnyTimes().Return(nil)
-		err = session.captureFrames(true, capturePlan)
+		err = session.captureFrames(context.Background(), true, capturePlan)
 		require.Nil(t, err, "Bias frame capture should not report error")
 	})
 
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+//	The state file records its layout's version, so a state file written by an older goskydarks can
+//	be upgraded as it is read, and one written by a newer goskydarks is refused rather than misread.
+//	The versions so far:
+//		1  No version field.  DarksRequired, BiasRequired, DarksDone, BiasDone, DownloadTimes, with
+//		   keys Dark_count_exposure_binning and Bias_count_binning.  Later files of this version may
+//		   also have FlatDarksRequired and FlatDarksDone, and keys ending in camera settings (_g100_o30_rX).
+//		2  Version field added.
+//		3  Keys identify the frames rather than the set: Dark_exposure_binning_temperatureC, and
+//		   Bias_binning_temperatureC, without the count.  Temperature field added.
+//		4  History of every frame attempted added.
+//		5  History moved out, to a log beside the state file (see historyLog.go).
+//	To change the layout, increase StateFileVersion and add a migration from the previous version.
+
+// StateFileVersion is the version of the state file layout this program writes
+const StateFileVersion = 5
+
+// stateFileVersionKey is the state file field holding its version
+const stateFileVersionKey = "Version"
+
+// ErrStateFileTooNew means the state file was written by a newer goskydarks than this one
+var ErrStateFileTooNew = errors.New("state file is from a newer version of goskydarks")
+
+// stateFileMigrations upgrade a state file, as generic JSON, from each version to the next:
+// stateFileMigrations[1] upgrades version 1 to version 2.  They are given the cooling temperature
+// the state file is for, which is part of its name.
+var stateFileMigrations = map[int]func(state map[string]interface{}, temperature float64) error{
+	1: migrateStateFileV1,
+	2: migrateStateFileV2,
+	3: migrateStateFileV3,
+	4: migrateStateFileV4,
+}
+
+// migrateStateFile upgrades a state file for the given temperature to the current version,
+// returning the version it had
+func migrateStateFile(contents []byte, temperature float64) ([]byte, int, error) {
+	var state map[string]interface{}
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, 0, err
+	}
+	version := 1
+	if value, present := state[stateFileVersionKey]; present {
+		number, isNumber := value.(float64)
+		if !isNumber || number != float64(int(number)) || number < 1 {
+			return nil, 0, fmt.Errorf("state file version must be a whole number >= 1, not %v", value)
+		}
+		version = int(number)
+	}
+	if version > StateFileVersion {
+		return nil, version, fmt.Errorf("%w: it has version %d, and this goskydarks reads up to version %d",
+			ErrStateFileTooNew, version, StateFileVersion)
+	}
+	if version == StateFileVersion {
+		return contents, version, nil
+	}
+
+	for from := version; from < StateFileVersion; from++ {
+		if err := stateFileMigrations[from](state, temperature); err != nil {
+			return nil, version, fmt.Errorf("upgrading state file from version %d: %w", from, err)
+		}
+		state[stateFileVersionKey] = from + 1
+	}
+	migrated, err := json.Marshal(state)
+	return migrated, version, err
+}
+
+// migrateStateFileV1 adds the lists and counts missing from state files written before flat-darks
+func migrateStateFileV1(state map[string]interface{}, _ float64) error {
+	for _, list := range []string{"DarksRequired", "BiasRequired", "FlatDarksRequired"} {
+		if state[list] == nil {
+			state[list] = []interface{}{}
+		}
+	}
+	for _, counts := range []string{"DarksDone", "BiasDone", "FlatDarksDone", "DownloadTimes"} {
+		if state[counts] == nil {
+			state[counts] = map[string]interface{}{}
+		}
+	}
+	return nil
+}
+
+// Version 2 keys, which include the set's count
+var (
+	version2DarkKey = regexp.MustCompile(`^(Dark|FlatDark)_\d+_([0-9.]+)_(\d+)(.*)$`)
+	version2BiasKey = regexp.MustCompile(`^Bias_\d+_(\d+)(.*)$`)
+)
+
+// migrateStateFileV2 rekeys the done counts by frame identity, adding the temperature and dropping
+// the count.  Sets that differed only in count become one, with the frames done in each added together,
+// as they are all frames of that kind.  Keys that don't match the old layout are kept as they are.
+func migrateStateFileV2(state map[string]interface{}, temperature float64) error {
+	for _, countsName := range []string{"DarksDone", "BiasDone", "FlatDarksDone"} {
+		counts, isMap := state[countsName].(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		rekeyed := make(map[string]interface{}, len(counts))
+		for key, value := range counts {
+			done, isNumber := value.(float64)
+			if !isNumber {
+				return fmt.Errorf("%s[%s] must be a number, not %v", countsName, key, value)
+			}
+			if parts := version2DarkKey.FindStringSubmatch(key); parts != nil {
+				key = fmt.Sprintf("%s_%s_%s_%.2fC%s", parts[1], parts[2], parts[3], temperature, parts[4])
+			} else if parts := version2BiasKey.FindStringSubmatch(key); parts != nil {
+				key = fmt.Sprintf("Bias_%s_%.2fC%s", parts[1], temperature, parts[2])
+			}
+			earlier, _ := rekeyed[key].(float64)
+			rekeyed[key] = earlier + done
+		}
+		state[countsName] = rekeyed
+	}
+	state["Temperature"] = temperature
+	return nil
+}
+
+// migrateStateFileV3 starts an empty frame history; frames captured before it was kept aren't known
+func migrateStateFileV3(state map[string]interface{}, _ float64) error {
+	if state["History"] == nil {
+		state["History"] = []interface{}{}
+	}
+	return nil
+}
+
+// migrateStateFileV4 leaves the history in place; ReadStateFile uses it until the next save moves it
+// to the history log
+func migrateStateFileV4(_ map[string]interface{}, _ float64) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateFileSchema(t *testing.T) {
+
+	//	readStateFile writes the given contents as a state file, then reads it back
+	readStateFile := func(t *testing.T, contents string) (*CapturePlan, *StateFileServiceInstance, error) {
+		service := NewStateFileService(filepath.Join(t.TempDir(), "state"), -10).(*StateFileServiceInstance)
+		require.Nil(t, os.WriteFile(service.StateFilePath, []byte(contents), 0644))
+		plan, err := service.ReadStateFile()
+		return plan, service, err
+	}
+
+	t.Run("version 1, as first written", func(t *testing.T) {
+		plan, _, err := readStateFile(t, `{
+   "DarksRequired": ["16,300,1"],
+   "BiasRequired": ["32,1"],
+   "DarksDone": {"Dark_16_300.0000_1": 5},
+   "BiasDone": {"Bias_32_1": 32},
+   "DownloadTimes": {"1": 6.5}
+}`)
+		require.Nil(t, err)
+		require.Equal(t, &CapturePlan{
+			Version:           StateFileVersion,
+			Temperature:       -10,
+			DarksRequired:     []string{"16,300,1"},
+			BiasRequired:      []string{"32,1"},
+			FlatDarksRequired: []string{},
+			DarksDone:         map[string]int{"Dark_300.0000_1_-10.00C": 5},
+			BiasDone:          map[string]int{"Bias_1_-10.00C": 32},
+			FlatDarksDone:     map[string]int{},
+			DownloadTimes:     map[int]float64{1: 6.5},
+			History:           []FrameRecord{},
+		}, plan)
+	})
+
+	t.Run("version 1, with flat-darks and no download times yet", func(t *testing.T) {
+		plan, _, err := readStateFile(t, `{
+   "DarksRequired": null,
+   "BiasRequired": null,
+   "FlatDarksRequired": ["10,0.5,1"],
+   "DarksDone": null,
+   "BiasDone": null,
+   "FlatDarksDone": {"FlatDark_10_0.5000_1": 3},
+   "DownloadTimes": null
+}`)
+		require.Nil(t, err)
+		require.Equal(t, StateFileVersion, plan.Version)
+		require.Equal(t, []string{"10,0.5,1"}, plan.FlatDarksRequired)
+		require.Equal(t, 3, plan.FlatDarksDone["FlatDark_0.5000_1_-10.00C"])
+		require.NotNil(t, plan.DarksDone)
+		require.NotNil(t, plan.DownloadTimes)
+	})
+
+	t.Run("version 1, with camera settings in the keys", func(t *testing.T) {
+		plan, _, err := readStateFile(t, `{
+   "DarksRequired": ["16,300,1,gain=100,offset=30"],
+   "DarksDone": {"Dark_16_300.0000_1_g100_o30": 7},
+   "BiasDone": {},
+   "DownloadTimes": {}
+}`)
+		require.Nil(t, err)
+		require.Equal(t, 7, plan.DarksDone["Dark_300.0000_1_-10.00C_g100_o30"])
+	})
+
+	t.Run("upgraded file is saved with the current version", func(t *testing.T) {
+		plan, service, err := readStateFile(t, `{"DarksDone": {"Dark_1_6.0000_1": 1}}`)
+		require.Nil(t, err)
+		require.Nil(t, service.SavePlanToFile(plan))
+		contents, err := os.ReadFile(service.StateFilePath)
+		require.Nil(t, err)
+		require.Contains(t, string(contents), `"Version": 5`)
+	})
+
+	t.Run("version 2, keyed by set including its count", func(t *testing.T) {
+		plan, _, err := readStateFile(t, `{
+   "Version": 2,
+   "DarksRequired": ["30,300,1"],
+   "BiasRequired": ["32,2"],
+   "FlatDarksRequired": ["10,0.5,1,readout=High Gain"],
+   "DarksDone": {"Dark_20_300.0000_1": 20, "Dark_30_300.0000_1": 4, "Dark_5_60.0000_2_g100": 5},
+   "BiasDone": {"Bias_32_2": 32},
+   "FlatDarksDone": {"FlatDark_10_0.5000_1_rHigh Gain": 10},
+   "DownloadTimes": {"1": 6.5, "2": 2}
+}`)
+		require.Nil(t, err)
+		require.Equal(t, -10.0, plan.Temperature)
+		require.Equal(t, map[string]int{"Dark_300.0000_1_-10.00C": 24, "Dark_60.0000_2_-10.00C_g100": 5}, plan.DarksDone,
+			"Sets differing only in count become one")
+		require.Equal(t, map[string]int{"Bias_2_-10.00C": 32}, plan.BiasDone)
+		require.Equal(t, map[string]int{"FlatDark_0.5000_1_-10.00C_rHigh Gain": 10}, plan.FlatDarksDone)
+
+		progress, err := PlanProgress(plan)
+		require.Nil(t, err)
+		require.Equal(t, 24, progress[0].Done, "Migrated keys match the keys made for the sets")
+		require.Equal(t, 6, progress[0].Remaining)
+		require.Equal(t, 0, progress[1].Remaining)
+		require.Equal(t, 0, progress[2].Remaining)
+	})
+
+	t.Run("version 2, with a count that isn't a number", func(t *testing.T) {
+		_, _, err := migrateStateFile([]byte(`{"Version": 2, "DarksDone": {"Dark_20_300.0000_1": "lots"}}`), -10)
+		require.ErrorContains(t, err, "DarksDone[Dark_20_300.0000_1] must be a number")
+	})
+
+	t.Run("version 3, without history", func(t *testing.T) {
+		plan, _, err := readStateFile(t, `{"Version": 3, "Temperature": -10, "DarksDone": {"Dark_6.0000_1_-10.00C": 1}}`)
+		require.Nil(t, err)
+		require.Equal(t, 1, plan.DarksDone["Dark_6.0000_1_-10.00C"])
+		require.NotNil(t, plan.History)
+		require.Empty(t, plan.History)
+	})
+
+	t.Run("version 4, with history in the state file", func(t *testing.T) {
+		plan, _, err := readStateFile(t, `{"Version": 4, "Temperature": -10, "DarksDone": {"Dark_6.0000_1_-10.00C": 1},
+   "History": [{"Time": "2026-01-02T03:04:05Z", "FrameType": "Dark", "Key": "Dark_6.0000_1_-10.00C", "Exposure": 6,
+                "Binning": 1, "TemperatureAfter": -9.5, "DownloadSeconds": 4, "Result": "ok"}]}`)
+		require.Nil(t, err)
+		require.Equal(t, 1, plan.DarksDone["Dark_6.0000_1_-10.00C"])
+		require.Nil(t, plan.BiasDone)
+		require.Len(t, plan.History, 1)
+		require.Nil(t, plan.History[0].TemperatureBefore)
+		require.Equal(t, -9.5, *plan.History[0].TemperatureAfter)
+		require.True(t, plan.History[0].Succeeded())
+	})
+
+	t.Run("fail on a newer version, without using the backup", func(t *testing.T) {
+		service := NewStateFileService(filepath.Join(t.TempDir(), "state"), -10).(*StateFileServiceInstance)
+		require.Nil(t, os.WriteFile(service.BackupFilePath(), []byte(`{"Version": 4}`), 0644))
+		require.Nil(t, os.WriteFile(service.StateFilePath, []byte(`{"Version": 99}`), 0644))
+		_, err := service.ReadStateFile()
+		require.ErrorIs(t, err, ErrStateFileTooNew)
+		require.ErrorContains(t, err, "it has version 99, and this goskydarks reads up to version 5")
+	})
+
+	t.Run("fail on a bad version", func(t *testing.T) {
+		_, _, err := migrateStateFile([]byte(`{"Version": "two"}`), 0)
+		require.ErrorContains(t, err, "state file version must be a whole number >= 1")
+	})
+}
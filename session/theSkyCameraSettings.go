@@ -0,0 +1,106 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RMcDOttawa/goTheSkyX"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//	goTheSkyX has no way to set the camera's gain, offset, readout mode or autosave prefix, and
+//	doesn't export the means to send a script of our own, so cameraSettingsTheSkyService sends those
+//	scripts itself, using the same JavaScript-over-socket protocol as goTheSkyX.  Like goTheSkyX, it
+//	opens a connection for each script.
+
+// ccdsoftCamera properties holding the camera's sensor settings
+const (
+	theSkyGainProperty        = "Gain"
+	theSkyOffsetProperty      = "Offset"
+	theSkyReadoutModeProperty = "ReadoutMode"
+	theSkySavePrefixProperty  = "AutoSavePrefix"
+)
+
+// theSkyScriptTimeout limits how long a camera settings script may take, connection included
+const theSkyScriptTimeout = 30 * time.Second
+
+// cameraSettingsTheSkyService is goTheSkyX's service, with camera settings added
+type cameraSettingsTheSkyService struct {
+	goTheSkyX.TheSkyService
+	server string // Address and port given to Connect, where the scripts are sent
+	port   int
+}
+
+// Connect remembers the server's address for camera settings scripts, then connects as usual
+func (t *cameraSettingsTheSkyService) Connect(server string, port int) error {
+	t.server = server
+	t.port = port
+	return t.TheSkyService.Connect(server, port)
+}
+
+func (t *cameraSettingsTheSkyService) SetCameraGain(gain int) error {
+	return t.setCameraProperty(theSkyGainProperty, strconv.Itoa(gain))
+}
+
+func (t *cameraSettingsTheSkyService) SetCameraOffset(offset int) error {
+	return t.setCameraProperty(theSkyOffsetProperty, strconv.Itoa(offset))
+}
+
+func (t *cameraSettingsTheSkyService) SetCameraReadoutMode(mode string) error {
+	return t.setCameraProperty(theSkyReadoutModeProperty, strconv.Quote(mode))
+}
+
+func (t *cameraSettingsTheSkyService) GetSavePrefix() (string, error) {
+	return sendTheSkyScript(t.server, t.port, fmt.Sprintf("var Out;\nOut=ccdsoftCamera.%s;\n", theSkySavePrefixProperty))
+}
+
+func (t *cameraSettingsTheSkyService) SetSavePrefix(prefix string) error {
+	return t.setCameraProperty(theSkySavePrefixProperty, strconv.Quote(prefix))
+}
+
+// setCameraProperty sets a ccdsoftCamera property to a value, given as script text
+func (t *cameraSettingsTheSkyService) setCameraProperty(property string, scriptValue string) error {
+	script := fmt.Sprintf("ccdsoftCamera.%s = %s;\nvar Out;\nOut=0;\n", property, scriptValue)
+	_, err := sendTheSkyScript(t.server, t.port, script)
+	return err
+}
+
+// sendTheSkyScript runs a script in TheSkyX and returns its result.  TheSkyX replies with the
+// result, then "|", then a line reporting any error, such as "No error. Error = 0."
+func sendTheSkyScript(server string, port int, script string) (string, error) {
+	if server == "" {
+		return "", errors.New("not connected to TheSkyX")
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(server, strconv.Itoa(port)), theSkyScriptTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(theSkyScriptTimeout))
+
+	packet := "/* Java Script */\n/* Socket Start Packet */\n" + script + "/* Socket End Packet */\n"
+	if _, err := conn.Write([]byte(packet)); err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	buffer := make([]byte, 4096)
+	for !strings.Contains(reply.String(), "|") || !strings.HasSuffix(strings.TrimSpace(reply.String()), ".") {
+		read, err := conn.Read(buffer)
+		reply.Write(buffer[:read])
+		if err != nil {
+			if strings.Contains(reply.String(), "|") {
+				break
+			}
+			return "", err
+		}
+	}
+
+	result, errorLine, _ := strings.Cut(strings.TrimSpace(reply.String()), "|")
+	if errorLine != "" && !strings.HasPrefix(strings.ToLower(errorLine), "no error.") {
+		//	The result is then TheSkyX's description of the error
+		return "", fmt.Errorf("TheSkyX error: %s %s", result, errorLine)
+	}
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"github.com/RMcDOttawa/goMockableDelay"
+	"github.com/RMcDOttawa/goTheSkyX"
+	"github.com/stretchr/testify/require"
+	"goskydarks/simulator"
+	"net"
+	"testing"
+)
+
+func TestTheSkyCameraSettings(t *testing.T) {
+	server := simulator.NewServer(simulator.Settings{AmbientTemperature: 20, FullFrameDownloadSeconds: 1, TimeScale: 1})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	delayService := goMockableDelay.NewDelayService(false, 0)
+	service := &cameraSettingsTheSkyService{TheSkyService: goTheSkyX.NewTheSkyService(delayService, false, 0)}
+	require.Nil(t, service.Connect("127.0.0.1", port))
+
+	t.Run("settings are made on the camera", func(t *testing.T) {
+		require.Nil(t, service.SetCameraGain(100))
+		require.Nil(t, service.SetCameraOffset(30))
+		require.Nil(t, service.SetCameraReadoutMode(`High "Gain"`))
+		settings, err := sendTheSkyScript("127.0.0.1", port,
+			"Out = ccdsoftCamera.Gain + \",\" + ccdsoftCamera.Offset + \",\" + ccdsoftCamera.ReadoutMode;\n")
+		require.Nil(t, err)
+		require.Equal(t, `100,30,High "Gain"`, settings)
+	})
+
+	t.Run("save prefix is read and changed", func(t *testing.T) {
+		require.Nil(t, service.SetSavePrefix(FlatDarkSavePrefix+"Night1_"))
+		prefix, err := service.GetSavePrefix()
+		require.Nil(t, err)
+		require.Equal(t, "FlatDark_Night1_", prefix)
+		require.Nil(t, service.SetSavePrefix(""))
+		prefix, err = service.GetSavePrefix()
+		require.Nil(t, err)
+		require.Equal(t, "", prefix)
+	})
+
+	t.Run("setting the camera refuses is an error", func(t *testing.T) {
+		err := service.SetCameraGain(-5)
+		require.ErrorContains(t, err, "TheSkyX error")
+		require.ErrorContains(t, err, "must be a whole number >= 0")
+	})
+
+	t.Run("server that can't be reached is an error", func(t *testing.T) {
+		unreachable := &cameraSettingsTheSkyService{server: "127.0.0.1", port: 1}
+		require.NotNil(t, unreachable.SetCameraGain(100))
+	})
+}
@@ -0,0 +1,277 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// TheSkyX frame type codes, as used in ccdsoftCamera.Frame
+const (
+	frameLight = 1
+	frameBias  = 2
+	frameDark  = 3
+	frameFlat  = 4
+)
+
+// defaultReadoutMode is the simulated camera's readout mode until a script sets another
+const defaultReadoutMode = "Normal"
+
+// camera models the state of the ccdsoftCamera object inside TheSkyX.
+// Only enough behaviour is modelled to let a client do what goskydarks does:
+// regulate temperature, take bias and dark frames, and wait for them to download.
+type camera struct {
+	mutex    sync.Mutex
+	settings Settings
+	clock    Clock
+
+	connected bool
+
+	//	Temperature model.  The sensor temperature decays exponentially from the temperature
+	//	at anchorTime toward a goal, which is either the set point (if regulating) or ambient.
+	regulating        bool
+	setPoint          float64
+	anchorTemperature float64
+	anchorTime        time.Time
+
+	//	Exposure state
+	exposing      bool
+	exposureStart time.Time
+	exposureEnd   time.Time // includes the download time
+
+	framesTaken          int
+	lastFrameExposure    float64
+	lastFrameTemperature float64
+
+	//	Sensor settings, for cameras that offer them
+	gain        float64
+	offset      float64
+	readoutMode string
+
+	//	Properties we don't model but will remember if a script sets them
+	otherProperties map[string]value
+}
+
+func newCamera(settings Settings, clock Clock) *camera {
+	return &camera{
+		settings:          settings,
+		clock:             clock,
+		setPoint:          settings.AmbientTemperature,
+		anchorTemperature: settings.AmbientTemperature,
+		anchorTime:        clock.Now(),
+		readoutMode:       defaultReadoutMode,
+		otherProperties: map[string]value{
+			"Frame":          float64(frameLight),
+			"ExposureTime":   1.0,
+			"BinX":           1.0,
+			"BinY":           1.0,
+			"Asynchronous":   0.0,
+			"AutoSaveOn":     1.0,
+			"AutoSavePrefix": "",
+		},
+	}
+}
+
+// temperature returns the modelled sensor temperature at the current time.
+// Caller must hold the mutex
+func (c *camera) temperature() float64 {
+	goal := c.temperatureGoal()
+	elapsed := c.clock.Now().Sub(c.anchorTime).Seconds()
+	if c.settings.CoolingTimeConstant <= 0 {
+		return goal
+	}
+	return goal + (c.anchorTemperature-goal)*math.Exp(-elapsed/c.settings.CoolingTimeConstant)
+}
+
+// temperatureGoal is where the sensor temperature is heading: the set point if the
+// cooler is on (limited by how far below ambient the cooler can reach), otherwise ambient.
+// Caller must hold the mutex
+func (c *camera) temperatureGoal() float64 {
+	if !c.regulating {
+		return c.settings.AmbientTemperature
+	}
+	return math.Max(c.setPoint, c.settings.AmbientTemperature-c.settings.MaxCoolingDelta)
+}
+
+// reanchorTemperature records the current temperature as the start of a new decay curve.
+// Must be called before anything that changes the goal.  Caller must hold the mutex
+func (c *camera) reanchorTemperature() {
+	c.anchorTemperature = c.temperature()
+	c.anchorTime = c.clock.Now()
+}
+
+// coolerPower is a rough percentage power figure, proportional to how far below ambient we are
+// Caller must hold the mutex
+func (c *camera) coolerPower() float64 {
+	if !c.regulating || c.settings.MaxCoolingDelta <= 0 {
+		return 0
+	}
+	power := 100 * (c.settings.AmbientTemperature - c.temperature()) / c.settings.MaxCoolingDelta
+	return math.Round(math.Min(100, math.Max(0, power)))
+}
+
+// downloadSeconds is the simulated time to download a frame at the given binning.
+// The image size, and so the download time, goes down with the square of the binning
+func (c *camera) downloadSeconds(binning int) float64 {
+	if binning < 1 {
+		binning = 1
+	}
+	return c.settings.FullFrameDownloadSeconds / float64(binning*binning)
+}
+
+// exposureComplete reports whether an exposure (including its download) has finished.
+// Caller must hold the mutex
+func (c *camera) exposureComplete() bool {
+	if c.exposing && !c.clock.Now().Before(c.exposureEnd) {
+		c.exposing = false
+		c.framesTaken++
+	}
+	return !c.exposing
+}
+
+// getProperty returns the value of a ccdsoftCamera property
+func (c *camera) getProperty(name string) (value, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch name {
+	case "Temperature":
+		return math.Round(c.temperature()*100) / 100, nil
+	case "TemperatureSetPoint":
+		return c.setPoint, nil
+	case "RegulateTemperature":
+		return boolToNumber(c.regulating), nil
+	case "ThermoElectricCoolerPower":
+		return c.coolerPower(), nil
+	case "IsExposureComplete":
+		return boolToNumber(c.exposureComplete()), nil
+	case "Status":
+		if c.exposureComplete() {
+			return "Ready", nil
+		}
+		return "Exposing", nil
+	case "LastImageFileName":
+		prefix, _ := c.otherProperties["AutoSavePrefix"].(string)
+		return fmt.Sprintf("%sSimulated_%04d.fit", prefix, c.framesTaken), nil
+	case "Gain":
+		return c.gain, nil
+	case "Offset":
+		return c.offset, nil
+	case "ReadoutMode":
+		return c.readoutMode, nil
+	}
+	if v, ok := c.otherProperties[name]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("ccdsoftCamera has no property %s", name)
+}
+
+// setProperty changes the value of a ccdsoftCamera property
+func (c *camera) setProperty(name string, v value) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch name {
+	case "Temperature", "ThermoElectricCoolerPower", "IsExposureComplete", "Status", "LastImageFileName":
+		return fmt.Errorf("ccdsoftCamera property %s is read-only", name)
+	case "TemperatureSetPoint":
+		number, err := toNumber(v)
+		if err != nil {
+			return err
+		}
+		c.reanchorTemperature()
+		c.setPoint = number
+		return nil
+	case "RegulateTemperature":
+		c.reanchorTemperature()
+		c.regulating = isTruthy(v)
+		return nil
+	case "Gain", "Offset":
+		number, err := toNumber(v)
+		if err != nil {
+			return err
+		}
+		if number < 0 || number != math.Trunc(number) {
+			return fmt.Errorf("ccdsoftCamera %s must be a whole number >= 0, not %s", name, formatValue(v))
+		}
+		if name == "Gain" {
+			c.gain = number
+		} else {
+			c.offset = number
+		}
+		return nil
+	case "ReadoutMode":
+		mode, isString := v.(string)
+		if !isString || mode == "" {
+			return fmt.Errorf("ccdsoftCamera ReadoutMode must be the name of a mode, not %s", formatValue(v))
+		}
+		c.readoutMode = mode
+		return nil
+	}
+	c.otherProperties[name] = v
+	return nil
+}
+
+// callMethod invokes a ccdsoftCamera method
+func (c *camera) callMethod(name string, args []value) (value, error) {
+	switch name {
+	case "Connect":
+		c.mutex.Lock()
+		c.connected = true
+		c.mutex.Unlock()
+		return 0.0, nil
+	case "Disconnect":
+		c.mutex.Lock()
+		c.connected = false
+		c.mutex.Unlock()
+		return 0.0, nil
+	case "TakeImage":
+		return c.takeImage()
+	case "Abort":
+		c.mutex.Lock()
+		c.exposing = false
+		c.mutex.Unlock()
+		return 0.0, nil
+	}
+	return nil, fmt.Errorf("ccdsoftCamera has no method %s (%d arguments)", name, len(args))
+}
+
+// takeImage starts an exposure.  In asynchronous mode we return immediately and the client
+// polls IsExposureComplete; otherwise we block until the exposure and download are done.
+func (c *camera) takeImage() (value, error) {
+	c.mutex.Lock()
+	if !c.connected {
+		c.mutex.Unlock()
+		return nil, errors.New("camera is not connected")
+	}
+	if !c.exposureComplete() {
+		c.mutex.Unlock()
+		return nil, errors.New("an exposure is already in progress")
+	}
+	exposure, err := toNumber(c.otherProperties["ExposureTime"])
+	if err != nil {
+		c.mutex.Unlock()
+		return nil, err
+	}
+	frame, _ := toNumber(c.otherProperties["Frame"])
+	if int(frame) == frameBias {
+		exposure = 0
+	}
+	binning, _ := toNumber(c.otherProperties["BinX"])
+	total := time.Duration((exposure + c.downloadSeconds(int(binning))) * float64(time.Second))
+	c.exposing = true
+	c.lastFrameExposure = exposure
+	c.lastFrameTemperature = c.temperature()
+	c.exposureStart = c.clock.Now()
+	c.exposureEnd = c.exposureStart.Add(total)
+	asynchronous := isTruthy(c.otherProperties["Asynchronous"])
+	c.mutex.Unlock()
+
+	if !asynchronous {
+		c.clock.Sleep(total)
+		c.mutex.Lock()
+		c.exposureComplete()
+		c.mutex.Unlock()
+	}
+	return 0.0, nil
+}
@@ -0,0 +1,39 @@
+package simulator
+
+import "time"
+
+// Clock is the simulator's source of time.  The simulated camera reads it to decide how
+// far the sensor has cooled and whether an exposure is finished.  It is an interface so
+// tests can substitute a clock they control.
+type Clock interface {
+	Now() time.Time
+	Sleep(duration time.Duration)
+}
+
+// scaledClock runs simulated time faster (or slower) than real time by a constant factor.
+// A time scale of 1 is real time; a scale of 60 makes one real second count as one simulated minute.
+type scaledClock struct {
+	realStart time.Time
+	timeScale float64
+}
+
+// NewScaledClock returns a clock that starts at the current time and runs timeScale times faster than real time
+func NewScaledClock(timeScale float64) Clock {
+	if timeScale <= 0 {
+		timeScale = 1
+	}
+	return &scaledClock{realStart: time.Now(), timeScale: timeScale}
+}
+
+func (c *scaledClock) Now() time.Time {
+	realElapsed := time.Since(c.realStart)
+	return c.realStart.Add(time.Duration(float64(realElapsed) * c.timeScale))
+}
+
+func (c *scaledClock) Sleep(duration time.Duration) {
+	time.Sleep(time.Duration(float64(duration) / c.timeScale))
+}
+
+func millisecondsToDuration(milliseconds float64) time.Duration {
+	return time.Duration(milliseconds * float64(time.Millisecond))
+}
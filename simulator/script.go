@@ -0,0 +1,471 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TheSkyX accepts JavaScript over its TCP socket and replies with the value of the
+// last statement executed.  We implement just enough of the language to run the kind
+// of scripts a camera-control client sends: variable declarations, assignments,
+// property reads and writes on ccdsoftCamera, method calls, and simple arithmetic
+// and string concatenation.  Control flow (if, while, functions) is not supported.
+
+// value is a script value: float64, string, bool, or nil (undefined)
+type value interface{}
+
+type tokenKind int
+
+const (
+	tokenIdentifier tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenPunctuation
+	tokenEnd
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits a script into tokens, discarding comments and whitespace.
+// Newlines are returned as ";" tokens, since we treat them as statement separators.
+func tokenize(script string) ([]token, error) {
+	var tokens []token
+	runes := []rune(script)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			tokens = append(tokens, token{tokenPunctuation, ";"})
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			if i+1 >= len(runes) {
+				return nil, errors.New("unterminated comment")
+			}
+			i += 2
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdentifier, string(runes[start:i])})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+		case r == '"' || r == '\'':
+			quote := r
+			var text strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != quote; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						text.WriteRune('\n')
+					case 't':
+						text.WriteRune('\t')
+					default:
+						text.WriteRune(runes[i])
+					}
+					continue
+				}
+				text.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			i++
+			tokens = append(tokens, token{tokenString, text.String()})
+		case strings.ContainsRune(";=+-*/().,!", r):
+			tokens = append(tokens, token{tokenPunctuation, string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	tokens = append(tokens, token{tokenEnd, ""})
+	return tokens, nil
+}
+
+// interpreter runs scripts against the simulated camera.  Variables persist only for the
+// duration of one script, as in TheSkyX.
+type interpreter struct {
+	objects   map[string]scriptObject
+	variables map[string]value
+	tokens    []token
+	position  int
+}
+
+// scriptObject is one of TheSkyX's scriptable objects, such as ccdsoftCamera
+type scriptObject interface {
+	getProperty(name string) (value, error)
+	setProperty(name string, v value) error
+	callMethod(name string, args []value) (value, error)
+}
+
+// runScript executes the script and returns the value of the last statement, formatted
+// as TheSkyX would return it
+func runScript(script string, objects map[string]scriptObject) (string, error) {
+	tokens, err := tokenize(script)
+	if err != nil {
+		return "", err
+	}
+	interp := &interpreter{objects: objects, variables: make(map[string]value), tokens: tokens}
+	var result value
+	for interp.peek().kind != tokenEnd {
+		if interp.accept(";") {
+			continue
+		}
+		v, hasValue, err := interp.statement()
+		if err != nil {
+			return "", err
+		}
+		if hasValue {
+			result = v
+		}
+		if !interp.accept(";") && interp.peek().kind != tokenEnd {
+			return "", fmt.Errorf("unexpected %q after statement", interp.peek().text)
+		}
+	}
+	return formatValue(result), nil
+}
+
+func (in *interpreter) peek() token {
+	return in.tokens[in.position]
+}
+
+func (in *interpreter) next() token {
+	t := in.tokens[in.position]
+	if t.kind != tokenEnd {
+		in.position++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given punctuation
+func (in *interpreter) accept(punctuation string) bool {
+	t := in.peek()
+	if t.kind == tokenPunctuation && t.text == punctuation {
+		in.position++
+		return true
+	}
+	return false
+}
+
+func (in *interpreter) expect(punctuation string) error {
+	if !in.accept(punctuation) {
+		return fmt.Errorf("expected %q but found %q", punctuation, in.peek().text)
+	}
+	return nil
+}
+
+// statement runs one statement.  The boolean result is false for statements, such as
+// a bare "var x", that have no completion value in JavaScript
+func (in *interpreter) statement() (value, bool, error) {
+	if t := in.peek(); t.kind == tokenIdentifier && t.text == "var" {
+		in.next()
+		name := in.next()
+		if name.kind != tokenIdentifier {
+			return nil, false, errors.New("expected variable name after var")
+		}
+		in.variables[name.text] = nil
+		if in.accept("=") {
+			v, err := in.expression()
+			if err != nil {
+				return nil, false, err
+			}
+			in.variables[name.text] = v
+		}
+		return nil, false, nil
+	}
+
+	//	Assignment: a name or dotted path followed by "="
+	start := in.position
+	if path, ok := in.path(); ok && in.accept("=") {
+		v, err := in.expression()
+		if err != nil {
+			return nil, false, err
+		}
+		return v, true, in.assign(path, v)
+	}
+	in.position = start
+	v, err := in.expression()
+	return v, true, err
+}
+
+// path reads an identifier, optionally dotted, without evaluating it
+func (in *interpreter) path() ([]string, bool) {
+	t := in.peek()
+	if t.kind != tokenIdentifier {
+		return nil, false
+	}
+	in.next()
+	path := []string{t.text}
+	for in.accept(".") {
+		part := in.next()
+		if part.kind != tokenIdentifier {
+			return nil, false
+		}
+		path = append(path, part.text)
+	}
+	return path, true
+}
+
+func (in *interpreter) assign(path []string, v value) error {
+	switch {
+	case len(path) == 1:
+		in.variables[path[0]] = v
+		return nil
+	case len(path) == 2 && in.objects[path[0]] != nil:
+		return in.objects[path[0]].setProperty(path[1], v)
+	}
+	return fmt.Errorf("cannot assign to %s", strings.Join(path, "."))
+}
+
+// expression handles + and -
+func (in *interpreter) expression() (value, error) {
+	left, err := in.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case in.accept("+"):
+			right, err := in.term()
+			if err != nil {
+				return nil, err
+			}
+			left = add(left, right)
+		case in.accept("-"):
+			right, err := in.term()
+			if err != nil {
+				return nil, err
+			}
+			if left, err = arithmetic(left, right, func(a, b float64) float64 { return a - b }); err != nil {
+				return nil, err
+			}
+		default:
+			return left, nil
+		}
+	}
+}
+
+// term handles * and /
+func (in *interpreter) term() (value, error) {
+	left, err := in.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case in.accept("*"):
+			right, err := in.factor()
+			if err != nil {
+				return nil, err
+			}
+			if left, err = arithmetic(left, right, func(a, b float64) float64 { return a * b }); err != nil {
+				return nil, err
+			}
+		case in.accept("/"):
+			right, err := in.factor()
+			if err != nil {
+				return nil, err
+			}
+			if left, err = arithmetic(left, right, func(a, b float64) float64 { return a / b }); err != nil {
+				return nil, err
+			}
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (in *interpreter) factor() (value, error) {
+	switch {
+	case in.accept("("):
+		v, err := in.expression()
+		if err != nil {
+			return nil, err
+		}
+		return v, in.expect(")")
+	case in.accept("-"):
+		v, err := in.factor()
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(0.0, v, func(a, b float64) float64 { return a - b })
+	case in.accept("!"):
+		v, err := in.factor()
+		if err != nil {
+			return nil, err
+		}
+		return !isTruthy(v), nil
+	}
+
+	t := in.peek()
+	switch t.kind {
+	case tokenNumber:
+		in.next()
+		return strconv.ParseFloat(t.text, 64)
+	case tokenString:
+		in.next()
+		return t.text, nil
+	case tokenIdentifier:
+		switch t.text {
+		case "true":
+			in.next()
+			return true, nil
+		case "false":
+			in.next()
+			return false, nil
+		case "undefined", "null":
+			in.next()
+			return nil, nil
+		}
+		path, _ := in.path()
+		if in.accept("(") {
+			args, err := in.arguments()
+			if err != nil {
+				return nil, err
+			}
+			return in.call(path, args)
+		}
+		return in.lookup(path)
+	}
+	return nil, fmt.Errorf("unexpected %q in expression", t.text)
+}
+
+func (in *interpreter) arguments() ([]value, error) {
+	var args []value
+	if in.accept(")") {
+		return args, nil
+	}
+	for {
+		v, err := in.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+		if in.accept(")") {
+			return args, nil
+		}
+		if err := in.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (in *interpreter) lookup(path []string) (value, error) {
+	switch {
+	case len(path) == 1:
+		v, ok := in.variables[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("ReferenceError: %s is not defined", path[0])
+		}
+		return v, nil
+	case len(path) == 2 && in.objects[path[0]] != nil:
+		return in.objects[path[0]].getProperty(path[1])
+	}
+	return nil, fmt.Errorf("unknown object %s", strings.Join(path, "."))
+}
+
+func (in *interpreter) call(path []string, args []value) (value, error) {
+	switch {
+	case len(path) == 2 && in.objects[path[0]] != nil:
+		return in.objects[path[0]].callMethod(path[1], args)
+	}
+	return nil, fmt.Errorf("unknown function %s", strings.Join(path, "."))
+}
+
+// add implements JavaScript "+": string concatenation if either side is a string
+func add(left, right value) value {
+	_, leftIsString := left.(string)
+	_, rightIsString := right.(string)
+	if leftIsString || rightIsString {
+		return formatValue(left) + formatValue(right)
+	}
+	result, err := arithmetic(left, right, func(a, b float64) float64 { return a + b })
+	if err != nil {
+		return formatValue(left) + formatValue(right)
+	}
+	return result
+}
+
+func arithmetic(left, right value, operation func(a, b float64) float64) (value, error) {
+	a, err := toNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toNumber(right)
+	if err != nil {
+		return nil, err
+	}
+	return operation(a, b), nil
+}
+
+func toNumber(v value) (float64, error) {
+	switch typed := v.(type) {
+	case float64:
+		return typed, nil
+	case bool:
+		return boolToNumber(typed), nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", typed)
+		}
+		return number, nil
+	}
+	return 0, errors.New("undefined is not a number")
+}
+
+func isTruthy(v value) bool {
+	switch typed := v.(type) {
+	case float64:
+		return typed != 0
+	case bool:
+		return typed
+	case string:
+		return typed != ""
+	}
+	return false
+}
+
+func boolToNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// formatValue converts a value to text the way JavaScript would
+func formatValue(v value) string {
+	switch typed := v.(type) {
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	case string:
+		return typed
+	}
+	return "undefined"
+}
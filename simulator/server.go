@@ -0,0 +1,204 @@
+package simulator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a stand-in for the TCP server inside TheSkyX.  It accepts the same
+// JavaScript-over-socket protocol, runs the scripts against a simulated camera, and
+// replies the way TheSkyX does, so goskydarks can be run end-to-end without an observatory.
+
+// Markers that delimit a script sent to TheSkyX, and the suffix TheSkyX appends to replies
+const scriptStartMarker = "/* Socket Start Packet */"
+const scriptEndMarker = "/* Socket End Packet */"
+const successSuffix = "|No error. Error = 0."
+
+// Settings control the simulated camera and the server address
+type Settings struct {
+	Address                  string  // Interface to listen on; empty for all
+	Port                     int     // TCP port, normally 3040
+	AmbientTemperature       float64 // Sensor temperature with the cooler off
+	CoolingTimeConstant      float64 // Seconds for the sensor to cover 63% of the way to a new temperature
+	MaxCoolingDelta          float64 // How far below ambient the cooler can reach
+	FullFrameDownloadSeconds float64 // Download time of an unbinned frame; binned frames are faster
+	TimeScale                float64 // Simulated seconds per real second
+	Verbosity                int
+	Debug                    bool
+}
+
+type Server struct {
+	settings Settings
+	objects  map[string]scriptObject
+
+	mutex       sync.Mutex
+	listener    net.Listener
+	connections map[net.Conn]struct{}
+	closed      bool
+}
+
+// NewServer creates a simulator using a clock scaled by the TimeScale setting
+func NewServer(settings Settings) *Server {
+	return NewServerWithClock(settings, NewScaledClock(settings.TimeScale))
+}
+
+// NewServerWithClock creates a simulator using the given clock; used in testing
+func NewServerWithClock(settings Settings, clock Clock) *Server {
+	cam := newCamera(settings, clock)
+	return &Server{
+		settings: settings,
+		objects: map[string]scriptObject{
+			"ccdsoftCamera":      cam,
+			"ccdsoftCameraImage": &cameraImage{camera: cam},
+			"sky6Utils":          &utilities{clock: clock},
+			"sky6Web":            &web{clock: clock},
+		},
+		connections: make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the configured address and port and handles connections until Close is called
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.settings.Address, strconv.Itoa(s.settings.Port)))
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve handles connections on the given listener until Close is called
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		_ = listener.Close()
+		return errors.New("simulator server is closed")
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	if s.settings.Verbosity >= 1 || s.settings.Debug {
+		fmt.Printf("TheSkyX simulator listening on %s\n", listener.Addr())
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mutex.Lock()
+		s.connections[conn] = struct{}{}
+		s.mutex.Unlock()
+		go s.handleConnection(conn)
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not yet listening
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the server and drops any open connections
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for conn := range s.connections {
+		_ = conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// handleConnection reads scripts from one client and replies to each.  A client may send
+// any number of scripts on one connection.
+func (s *Server) handleConnection(conn net.Conn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.connections, conn)
+		s.mutex.Unlock()
+		_ = conn.Close()
+	}()
+	if s.settings.Verbosity >= 2 || s.settings.Debug {
+		fmt.Printf("Simulator: connection from %s\n", conn.RemoteAddr())
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		script, err := readScript(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && (s.settings.Verbosity >= 2 || s.settings.Debug) {
+				fmt.Println("Simulator: error reading from client:", err)
+			}
+			return
+		}
+		reply := s.HandleScript(script)
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// HandleScript runs one script and returns the reply TheSkyX would send
+func (s *Server) HandleScript(script string) string {
+	if s.settings.Verbosity >= 4 || s.settings.Debug {
+		fmt.Printf("Simulator: received script:\n%s\n", script)
+	}
+	result, err := runScript(script, s.objects)
+	var reply string
+	if err != nil {
+		reply = fmt.Sprintf("TypeError: %s|Error = 1.", err)
+	} else {
+		reply = result + successSuffix
+	}
+	if s.settings.Verbosity >= 4 || s.settings.Debug {
+		fmt.Printf("Simulator: reply: %s\n", reply)
+	}
+	return reply
+}
+
+// readScript reads up to and including the end-of-script marker and returns the
+// script between the start and end markers.  The marker ends in "/", so we read in
+// chunks ending in "/" - the client need not send a newline after the marker.
+func readScript(reader *bufio.Reader) (string, error) {
+	var received strings.Builder
+	for {
+		chunk, err := reader.ReadString('/')
+		received.WriteString(chunk)
+		if strings.HasSuffix(received.String(), scriptEndMarker) {
+			break
+		}
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(received.String()) != "" {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+	script := received.String()
+	if start := strings.Index(script, scriptStartMarker); start >= 0 {
+		script = script[start+len(scriptStartMarker):]
+	}
+	if end := strings.Index(script, scriptEndMarker); end >= 0 {
+		script = script[:end]
+	}
+	return script, nil
+}
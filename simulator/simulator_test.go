@@ -0,0 +1,257 @@
+package simulator
+
+import (
+	"bufio"
+	"github.com/RMcDOttawa/goTheSkyX"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when the test tells it to, or when the simulator sleeps
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(duration time.Duration) {
+	c.Advance(duration)
+}
+
+func (c *fakeClock) Advance(duration time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(duration)
+}
+
+func testSettings() Settings {
+	return Settings{
+		Address:                  "127.0.0.1",
+		AmbientTemperature:       20.0,
+		CoolingTimeConstant:      60.0,
+		MaxCoolingDelta:          35.0,
+		FullFrameDownloadSeconds: 8.0,
+		TimeScale:                1.0,
+	}
+}
+
+func wrapScript(script string) string {
+	return "/* Java Script */\n" + scriptStartMarker + "\n" + script + "\n" + scriptEndMarker + "\n"
+}
+
+func TestScriptInterpreter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)}
+	server := NewServerWithClock(testSettings(), clock)
+
+	t.Run("result is value of last statement", func(t *testing.T) {
+		reply := server.HandleScript("var a = 2;\nvar b = 3;\nOut = a * b + 1;")
+		require.Equal(t, "7"+successSuffix, reply)
+	})
+
+	t.Run("string concatenation", func(t *testing.T) {
+		reply := server.HandleScript(`var Out; Out = "T=" + ccdsoftCamera.Temperature;`)
+		require.Equal(t, "T=20"+successSuffix, reply)
+	})
+
+	t.Run("comments are ignored", func(t *testing.T) {
+		reply := server.HandleScript("/* leading */ ccdsoftCamera.Connect(); // trailing\n 42")
+		require.Equal(t, "42"+successSuffix, reply)
+	})
+
+	t.Run("unknown object is an error", func(t *testing.T) {
+		reply := server.HandleScript("sky6Nonsense.Frobnicate();")
+		require.Contains(t, reply, "Error = 1.")
+		require.Contains(t, reply, "unknown function")
+	})
+
+	t.Run("read-only property is an error", func(t *testing.T) {
+		reply := server.HandleScript("ccdsoftCamera.Temperature = 5;")
+		require.Contains(t, reply, "read-only")
+	})
+}
+
+func TestCameraSettings(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)}
+	server := NewServerWithClock(testSettings(), clock)
+
+	t.Run("gain, offset and readout mode are kept", func(t *testing.T) {
+		require.Equal(t, "Normal"+successSuffix, server.HandleScript("ccdsoftCamera.ReadoutMode;"))
+		reply := server.HandleScript("ccdsoftCamera.Gain = 100;\nccdsoftCamera.Offset = 30;\n" +
+			"ccdsoftCamera.ReadoutMode = \"High Gain\";\n" +
+			"Out = ccdsoftCamera.Gain + \",\" + ccdsoftCamera.Offset + \",\" + ccdsoftCamera.ReadoutMode;")
+		require.Equal(t, "100,30,High Gain"+successSuffix, reply)
+	})
+
+	t.Run("bad settings are errors", func(t *testing.T) {
+		require.Contains(t, server.HandleScript("ccdsoftCamera.Gain = -1;"), "must be a whole number >= 0")
+		require.Contains(t, server.HandleScript("ccdsoftCamera.Offset = 2.5;"), "must be a whole number >= 0")
+		require.Contains(t, server.HandleScript("ccdsoftCamera.ReadoutMode = \"\";"), "must be the name of a mode")
+	})
+}
+
+func TestCoolingModel(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)}
+	server := NewServerWithClock(testSettings(), clock)
+
+	t.Run("starts at ambient", func(t *testing.T) {
+		require.Equal(t, "20"+successSuffix, server.HandleScript("ccdsoftCamera.Temperature"))
+	})
+
+	t.Run("approaches set point over time", func(t *testing.T) {
+		server.HandleScript("ccdsoftCamera.TemperatureSetPoint = -10; ccdsoftCamera.RegulateTemperature = true;")
+		clock.Advance(60 * time.Second)
+		afterOneMinute := parseReply(t, server.HandleScript("ccdsoftCamera.Temperature"))
+		require.Less(t, afterOneMinute, 20.0)
+		require.Greater(t, afterOneMinute, -10.0)
+
+		clock.Advance(20 * time.Minute)
+		settled := parseReply(t, server.HandleScript("ccdsoftCamera.Temperature"))
+		require.InDelta(t, -10.0, settled, 0.01)
+	})
+
+	t.Run("cannot cool further than the cooler's limit", func(t *testing.T) {
+		server.HandleScript("ccdsoftCamera.TemperatureSetPoint = -40")
+		clock.Advance(time.Hour)
+		settled := parseReply(t, server.HandleScript("ccdsoftCamera.Temperature"))
+		require.InDelta(t, 20.0-35.0, settled, 0.01)
+	})
+
+	t.Run("warms to ambient when regulation is off", func(t *testing.T) {
+		server.HandleScript("ccdsoftCamera.RegulateTemperature = 0")
+		clock.Advance(time.Hour)
+		settled := parseReply(t, server.HandleScript("ccdsoftCamera.Temperature"))
+		require.InDelta(t, 20.0, settled, 0.01)
+	})
+}
+
+func TestExposureTiming(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)}
+	server := NewServerWithClock(testSettings(), clock)
+	server.HandleScript("ccdsoftCamera.Connect()")
+
+	t.Run("asynchronous dark frame completes after exposure plus download", func(t *testing.T) {
+		reply := server.HandleScript("ccdsoftCamera.Frame = 3; ccdsoftCamera.ExposureTime = 30; ccdsoftCamera.BinX = 2;" +
+			" ccdsoftCamera.BinY = 2; ccdsoftCamera.Asynchronous = 1; ccdsoftCamera.TakeImage();")
+		require.Equal(t, "0"+successSuffix, reply)
+		require.Equal(t, "0"+successSuffix, server.HandleScript("ccdsoftCamera.IsExposureComplete"))
+		clock.Advance(30 * time.Second)
+		//	Still downloading: 8 seconds full frame, binned 2 is 2 seconds
+		require.Equal(t, "0"+successSuffix, server.HandleScript("ccdsoftCamera.IsExposureComplete"))
+		clock.Advance(2 * time.Second)
+		require.Equal(t, "1"+successSuffix, server.HandleScript("ccdsoftCamera.IsExposureComplete"))
+	})
+
+	t.Run("synchronous bias frame blocks for download time only", func(t *testing.T) {
+		before := clock.Now()
+		reply := server.HandleScript("ccdsoftCamera.Frame = 2; ccdsoftCamera.ExposureTime = 30; ccdsoftCamera.BinX = 1;" +
+			" ccdsoftCamera.Asynchronous = 0; ccdsoftCamera.TakeImage();")
+		require.Equal(t, "0"+successSuffix, reply)
+		require.Equal(t, 8*time.Second, clock.Now().Sub(before))
+	})
+
+	t.Run("cannot take image when disconnected", func(t *testing.T) {
+		server.HandleScript("ccdsoftCamera.Disconnect()")
+		reply := server.HandleScript("ccdsoftCamera.TakeImage()")
+		require.Contains(t, reply, "not connected")
+	})
+}
+
+func TestServerOverTCP(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)}
+	server := NewServerWithClock(testSettings(), clock)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Can't open listener")
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.Nil(t, err, "Can't connect to simulator")
+	defer func() {
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+
+	//	Several scripts on the same connection
+	for _, expected := range []string{"20", "3"} {
+		script := "ccdsoftCamera.Temperature"
+		if expected == "3" {
+			script = "1 + 2"
+		}
+		_, err = conn.Write([]byte(wrapScript(script)))
+		require.Nil(t, err, "Can't send script")
+		reply, err := reader.ReadString('.')
+		require.Nil(t, err, "Can't read reply")
+		for !strings.HasSuffix(reply, successSuffix) {
+			more, err := reader.ReadString('.')
+			require.Nil(t, err, "Can't read reply")
+			reply += more
+		}
+		require.Equal(t, expected+successSuffix, reply)
+	}
+}
+
+func parseReply(t *testing.T, reply string) float64 {
+	require.True(t, strings.HasSuffix(reply, successSuffix), "Reply should indicate success: "+reply)
+	number, err := toNumber(strings.TrimSuffix(reply, successSuffix))
+	require.Nil(t, err, "Reply should be a number: "+reply)
+	return number
+}
+
+// TestWithTheSkyXDriver runs the real TheSkyX driver against the simulator, to confirm
+// that the simulator understands the scripts goskydarks actually sends
+func TestWithTheSkyXDriver(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)}
+	server := NewServerWithClock(testSettings(), clock)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err, "Can't open listener")
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	driver := goTheSkyX.NewTheSkyDriver(false, 0)
+	require.Nil(t, driver.Connect("127.0.0.1", port), "Driver can't connect")
+	require.Nil(t, driver.ConnectCamera(), "Driver can't connect camera")
+
+	require.Nil(t, driver.StartCooling(-10.0), "Driver can't start cooling")
+	clock.Advance(time.Hour)
+	temperature, err := driver.GetCameraTemperature()
+	require.Nil(t, err, "Driver can't read temperature")
+	require.InDelta(t, -10.0, temperature, 0.01)
+
+	downloadTime, err := driver.MeasureDownloadTime(1)
+	require.Nil(t, err, "Driver can't measure download time")
+	require.InDelta(t, 8.0, downloadTime, 0.01)
+
+	require.Nil(t, driver.StartDarkFrameCapture(1, 60.0, downloadTime), "Driver can't start dark frame")
+	done, err := driver.IsCaptureDone()
+	require.Nil(t, err, "Driver can't poll for capture done")
+	require.False(t, done, "Dark frame should not be done immediately")
+	clock.Advance(68 * time.Second)
+	done, err = driver.IsCaptureDone()
+	require.Nil(t, err, "Driver can't poll for capture done")
+	require.True(t, done, "Dark frame should be done after exposure and download")
+
+	adu, err := driver.GetADUValue()
+	require.Nil(t, err, "Driver can't measure image")
+	require.Greater(t, adu, int64(biasLevelADU))
+
+	require.Nil(t, driver.StopCooling(), "Driver can't stop cooling")
+	require.Nil(t, driver.Close(), "Driver can't close")
+}
@@ -0,0 +1,107 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Simulated image statistics: a fixed bias level, plus dark current that doubles
+// for every few degrees of sensor temperature
+const biasLevelADU = 1000.0
+const darkCurrentADUPerSecondAtZero = 0.5
+const darkCurrentDoublingDegrees = 6.0
+
+// utilities models sky6Utils, which clients use to read the time inside TheSkyX.
+// ComputeUniversalTime leaves the current UT, in hours, in the dOut0 property.
+type utilities struct {
+	mutex sync.Mutex
+	clock Clock
+	dOut0 float64
+}
+
+func (u *utilities) getProperty(name string) (value, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if name == "dOut0" {
+		return u.dOut0, nil
+	}
+	return nil, fmt.Errorf("sky6Utils has no property %s", name)
+}
+
+func (u *utilities) setProperty(name string, _ value) error {
+	return fmt.Errorf("sky6Utils property %s is read-only", name)
+}
+
+func (u *utilities) callMethod(name string, _ []value) (value, error) {
+	if name != "ComputeUniversalTime" {
+		return nil, fmt.Errorf("sky6Utils has no method %s", name)
+	}
+	now := u.clock.Now().UTC()
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.dOut0 = float64(now.Hour()) + float64(now.Minute())/60 + (float64(now.Second())+float64(now.Nanosecond())/1e9)/3600
+	return 0.0, nil
+}
+
+// web models sky6Web, used by some scripts to pause inside TheSkyX
+type web struct {
+	clock Clock
+}
+
+func (w *web) getProperty(name string) (value, error) {
+	return nil, fmt.Errorf("sky6Web has no property %s", name)
+}
+
+func (w *web) setProperty(name string, _ value) error {
+	return fmt.Errorf("sky6Web has no property %s", name)
+}
+
+func (w *web) callMethod(name string, args []value) (value, error) {
+	if name != "Sleep" || len(args) != 1 {
+		return nil, fmt.Errorf("sky6Web has no method %s (%d arguments)", name, len(args))
+	}
+	milliseconds, err := toNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	w.clock.Sleep(millisecondsToDuration(milliseconds))
+	return nil, nil
+}
+
+// cameraImage models ccdsoftCameraImage, which clients use to measure the last image taken
+type cameraImage struct {
+	camera   *camera
+	attached bool // Guarded by camera.mutex, as the image is shared by all connections
+}
+
+func (ci *cameraImage) getProperty(name string) (value, error) {
+	return nil, fmt.Errorf("ccdsoftCameraImage has no property %s", name)
+}
+
+func (ci *cameraImage) setProperty(name string, _ value) error {
+	return fmt.Errorf("ccdsoftCameraImage has no property %s", name)
+}
+
+func (ci *cameraImage) callMethod(name string, _ []value) (value, error) {
+	switch name {
+	case "AttachToActive":
+		ci.camera.mutex.Lock()
+		defer ci.camera.mutex.Unlock()
+		if ci.camera.framesTaken == 0 {
+			return nil, errors.New("no active image")
+		}
+		ci.attached = true
+		return 0.0, nil
+	case "averagePixelValue":
+		ci.camera.mutex.Lock()
+		defer ci.camera.mutex.Unlock()
+		if !ci.attached {
+			return nil, errors.New("no image attached")
+		}
+		darkCurrent := darkCurrentADUPerSecondAtZero * math.Pow(2, ci.camera.lastFrameTemperature/darkCurrentDoublingDegrees)
+		return math.Round(biasLevelADU + darkCurrent*ci.camera.lastFrameExposure), nil
+	}
+	return nil, fmt.Errorf("ccdsoftCameraImage has no method %s", name)
+}